@@ -0,0 +1,14 @@
+package text
+
+import (
+	"strings"
+)
+
+// UTF8BOM is the byte order mark as it appears at the
+// beginning of some UTF-8 encoded files.
+const UTF8BOM = "\uFEFF"
+
+// StripBOM returns s without a leading UTF-8 byte order mark.
+func StripBOM(s string) string {
+	return strings.TrimPrefix(s, UTF8BOM)
+}
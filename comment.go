@@ -0,0 +1,44 @@
+package text
+
+import "strings"
+
+type commentScanner struct {
+	Scanner
+	prefix  string
+	escaped string
+	text    string
+}
+
+// NewCommentScanner returns a Scanner that skips blank lines, and
+// lines that, apart from leading white-space, start with prefix.
+// If escaped is not empty, lines starting with escaped, like "\#" for
+// a prefix "#", are passed through, with escaped replaced by prefix.
+func NewCommentScanner(s Scanner, prefix, escaped string) Scanner {
+	return &commentScanner{Scanner: s, prefix: prefix, escaped: escaped}
+}
+
+func (s *commentScanner) Scan() bool {
+	for s.Scanner.Scan() {
+		line := s.Scanner.Text()
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" {
+			continue
+		}
+		indent := line[:len(line)-len(trimmed)]
+		if s.escaped != "" && strings.HasPrefix(trimmed, s.escaped) {
+			s.text = indent + s.prefix + trimmed[len(s.escaped):]
+			return true
+		}
+		if s.prefix != "" && strings.HasPrefix(trimmed, s.prefix) {
+			continue
+		}
+		s.text = line
+		return true
+	}
+	s.text = ""
+	return false
+}
+
+func (s *commentScanner) Text() string {
+	return s.text
+}
@@ -0,0 +1,43 @@
+package text
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCommentScanner(t *testing.T) {
+	src := `# comment
+a
+	# indented comment
+
+  b # not a comment
+\# escaped
+	\#indented escaped
+c`
+	s := NewCommentScanner(newScanner(src), "#", `\#`)
+	var lines []string
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+	want := []string{"a", "  b # not a comment", "# escaped", "\t#indented escaped", "c"}
+	if got := strings.Join(lines, "|"); got != strings.Join(want, "|") {
+		t.Errorf("unexpected lines: %q", lines)
+	}
+	if s.Text() != "" {
+		t.Errorf("Text after end of input: %q", s.Text())
+	}
+	if err := s.Err(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCommentScannerNoEscape(t *testing.T) {
+	s := NewCommentScanner(newScanner("// x\n\\// y\n\n \t\nz\n"), "//", "")
+	var lines []string
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+	if got := strings.Join(lines, "|"); got != `\// y|z` {
+		t.Errorf("unexpected lines: %q", got)
+	}
+}
@@ -9,11 +9,15 @@ import (
 	"os/user"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"unicode"
 
 	"github.com/knieriem/fsutil"
 
+	"github.com/knieriem/text"
 	"github.com/knieriem/text/line"
+	"github.com/knieriem/text/rc"
 	"github.com/knieriem/text/tidata"
 )
 
@@ -134,6 +138,23 @@ type DecodeFn func(v interface{}) error
 // or nil.
 type WalkFn func(partName string, decode DecodeFn) error
 
+// A WalkOption modifies the behaviour of WalkParts.
+type WalkOption func(*walkConfig)
+
+type walkConfig struct {
+	continueOnError bool
+}
+
+// ContinueOnError makes WalkParts continue with the remaining parts
+// of a directory if walkFn returns an error for a part. The errors
+// of all parts are returned in a combined *line.ErrorList, each
+// entry being an *line.ErrorList naming the part's file.
+func ContinueOnError() WalkOption {
+	return func(c *walkConfig) {
+		c.continueOnError = true
+	}
+}
+
 // WalkParts looks for a file or directory with the given name -- with
 // or without the extension -- within the configured namespace. If it
 // finds a directory, each file within that directory is considered a
@@ -144,7 +165,15 @@ type WalkFn func(partName string, decode DecodeFn) error
 // contained in one part, or split over multiple project files within
 // a directory. How exactly the parts are handled, is left to the
 // caller, who specifies walkFn.
-func WalkParts(name string, walkFn WalkFn) (label string, err error) {
+//
+// The parts of a directory are walked in lexical order of their
+// names. By default, WalkParts stops at the first error returned
+// by walkFn; see ContinueOnError for an alternative.
+func WalkParts(name string, walkFn WalkFn, opts ...WalkOption) (label string, err error) {
+	var conf walkConfig
+	for _, o := range opts {
+		o(&conf)
+	}
 	var inf fsAnnotations
 	ext := path.Ext(name)
 	stem := name[:len(name)-len(ext)]
@@ -171,18 +200,22 @@ func WalkParts(name string, walkFn WalkFn) (label string, err error) {
 	}
 
 	if fi.IsDir() {
-		err = parseDir(name, ext, walkFn, &inf)
+		err = parseDir(name, ext, walkFn, &inf, &conf)
 	} else {
 		err = parsePart(name, walkFn, &inf)
 	}
 	return inf.label, err
 }
 
-func parseDir(dirname, ext string, walkFn WalkFn, inf *fsAnnotations) error {
+func parseDir(dirname, ext string, walkFn WalkFn, inf *fsAnnotations, conf *walkConfig) error {
 	list, err := ns.ReadDir(dirname)
 	if err != nil {
 		return err
 	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name() < list[j].Name()
+	})
+	var errs line.ErrorList
 	for _, d := range list {
 		if d.IsDir() {
 			continue
@@ -194,10 +227,13 @@ func parseDir(dirname, ext string, walkFn WalkFn, inf *fsAnnotations) error {
 		path := path.Join(dirname, name)
 		err := parsePart(path, walkFn, inf)
 		if err != nil {
-			return err
+			if !conf.continueOnError {
+				return err
+			}
+			errs.Add(err)
 		}
 	}
-	return nil
+	return errs.Err()
 }
 
 func parsePart(name string, walkFn WalkFn, inf *fsAnnotations) error {
@@ -223,22 +259,105 @@ func ParseFile(name string, conf interface{}) (fsLabel string, err error) {
 	return f.Label, err
 }
 
+// ParseLayered parses each of the configuration files confs in
+// order into the same value v, so that values specified in later
+// files override those from earlier ones, while fields not mentioned
+// in a file keep their values. Missing files are skipped. After
+// ParseLayered returns, the Using and Label fields of each File
+// tell whether, and from where, it has contributed.
+//
+// Since each file is decoded separately, struct tag options like
+// "required" and "default=" apply to each file individually.
+func ParseLayered(confs []*File, v interface{}) error {
+	for _, f := range confs {
+		err := f.Parse(v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 var MultiStringSep string
 
-func Parse(r io.Reader, conf interface{}) (err error) {
+// A ParseOption modifies the behaviour of Parse.
+type ParseOption func(*parseConfig)
+
+type parseConfig struct {
+	lookupEnv          func(string) (string, bool)
+	undefinedVarErrors bool
+}
+
+// ExpandEnv makes Parse expand references to variables, written
+// as $NAME or ${NAME}, in values of string fields. Lookup returns
+// the value of a variable, and whether it is defined; if it is nil,
+// os.LookupEnv is used. Undefined variables expand to the empty
+// string, see UndefinedVarErrors for an alternative.
+func ExpandEnv(lookup func(name string) (string, bool)) ParseOption {
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+	return func(c *parseConfig) {
+		c.lookupEnv = lookup
+	}
+}
+
+// UndefinedVarErrors makes Parse report references to undefined
+// variables as errors, if variables are expanded, see ExpandEnv.
+func UndefinedVarErrors() ParseOption {
+	return func(c *parseConfig) {
+		c.undefinedVarErrors = true
+	}
+}
+
+func Parse(r io.Reader, conf interface{}, opts ...ParseOption) (err error) {
+	var pc parseConfig
+	for _, o := range opts {
+		o(&pc)
+	}
 	el, err := readTiData(r)
 	if err != nil {
 		return
 	}
 
-	ticonf.MultiStringSep = MultiStringSep
-	err = el.Decode(conf, &ticonf)
+	c := ticonf
+	c.MultiStringSep = MultiStringSep
+	c.LookupEnv = pc.lookupEnv
+	c.UndefinedVarErrors = pc.undefinedVarErrors
+	err = el.Decode(conf, &c)
 	if err != nil {
 		return
 	}
 	return
 }
 
+// ParseFlat reads lines of NAME=VALUE assignments from r into m,
+// skipping empty lines and comments starting with '#'. Values
+// may be quoted as in rc, see rc.ParseEnv. Errors are reported
+// as a *line.ErrorList.
+func ParseFlat(r io.Reader, m map[string]string) error {
+	var errList line.ErrorList
+	s := bufio.NewScanner(r)
+	for lineNum := 1; s.Scan(); lineNum++ {
+		t := s.Text()
+		if lineNum == 1 {
+			t = text.StripBOM(t)
+		}
+		env, err := rc.ParseEnv(t)
+		if err != nil {
+			errList.AddError(lineNum, err)
+			continue
+		}
+		for name, val := range env {
+			m[name] = strings.Join(val, " ")
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	return errList.Err()
+}
+
 func readTiData(r io.Reader) (el *tidata.Elem, err error) {
 	tr := tidata.NewReader(bufio.NewScanner(r))
 	tr.CommentPrefix = "#"
@@ -249,18 +368,80 @@ func readTiData(r io.Reader) (el *tidata.Elem, err error) {
 }
 
 var ticonf = tidata.Config{
-	MapSym: "",
-	KeyToFieldName: func(key string) (name string) {
-		s := strings.Title(key)
-		s = replaceSpecial(s, "-", "")
-		name = replaceSpecial(s, "/", "Per")
-		if strings.HasSuffix(name, "Id") {
-			name = name[:len(name)-1] + "D"
-		} else if strings.HasSuffix(name, "Url") {
-			name = name[:len(name)-2] + "RL"
+	MapSym:         "",
+	KeyToFieldName: keyToFieldName,
+	FieldNameToKey: FieldNameToKey,
+}
+
+func keyToFieldName(key string) (name string) {
+	s := strings.Title(key)
+	s = replaceSpecial(s, "-", "")
+	name = replaceSpecial(s, "/", "Per")
+	if strings.HasSuffix(name, "Id") {
+		name = name[:len(name)-1] + "D"
+	} else if strings.HasSuffix(name, "Url") {
+		name = name[:len(name)-2] + "RL"
+	}
+	return
+}
+
+// Write writes conf, a struct or a pointer to a struct, to w in the
+// format read by Parse, so that parsing the output results in a value
+// equal to conf. Keys are derived from field names using FieldNameToKey.
+// See tidata.Encoder for details, and for values that are rejected.
+func Write(w io.Writer, conf interface{}) error {
+	c := ticonf
+	c.MultiStringSep = MultiStringSep
+	enc := tidata.NewEncoder(w, &c)
+	enc.CommentPrefix = "#"
+	return enc.Encode(conf)
+}
+
+// FieldNameToKey converts a struct field name into a key as it
+// may appear in a configuration file. It is the inverse of the
+// conversion done by Parse: Words of a camel case name are converted
+// to lower case and joined by '-', and "Per" between two words
+// is replaced by '/'; e.g. "BytesPerSec" results in "bytes/sec",
+// "ServerID" in "server-id". If the resulting key would not map back
+// to name, as for "HTTPPort", name is returned unchanged.
+func FieldNameToKey(name string) string {
+	words := splitCamelCase(name)
+	key := ""
+	for i, w := range words {
+		switch {
+		case i == 0:
+		case w == "Per" && i < len(words)-1:
+			continue
+		case words[i-1] == "Per" && i > 1:
+			key += "/"
+		default:
+			key += "-"
 		}
-		return
-	},
+		key += strings.ToLower(w)
+	}
+	if keyToFieldName(key) != name {
+		return name
+	}
+	return key
+}
+
+// splitCamelCase splits a name at the start of each upper case
+// word; a sequence of capitals, like "ID" in "ServerIDs", is
+// regarded as a single word, unless it is followed by lower case
+// characters, like "HTTPPort".
+func splitCamelCase(name string) (words []string) {
+	r := []rune(name)
+	i0 := 0
+	for i := 1; i < len(r); i++ {
+		if !unicode.IsUpper(r[i]) {
+			continue
+		}
+		if !unicode.IsUpper(r[i-1]) || i+1 < len(r) && unicode.IsLower(r[i+1]) {
+			words = append(words, string(r[i0:i]))
+			i0 = i
+		}
+	}
+	return append(words, string(r[i0:]))
 }
 
 func replaceSpecial(s, old, new string) string {
@@ -0,0 +1,249 @@
+package ini
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/knieriem/fsutil"
+	"github.com/knieriem/text/line"
+)
+
+// isolateNameSpace replaces the package's name space by an
+// empty one, and returns a function that restores it.
+func isolateNameSpace() (restore func()) {
+	saved := ns
+	ns = fsutil.NameSpace{}
+	return func() {
+		ns = saved
+	}
+}
+
+func TestParseLayered(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"system.conf": "name\tsystem\nport\t1\nhost\tlocalhost\n",
+		"user.conf":   "port\t2\n",
+	}
+	for name, content := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer isolateNameSpace()()
+	BindOS(dir, "test")
+
+	var conf struct {
+		Name string
+		Port int
+		Host string
+	}
+	confs := []*File{
+		NewFile("system.conf", "system config", ""),
+		NewFile("missing.conf", "project config", ""),
+		NewFile("user.conf", "user config", ""),
+	}
+	if err := ParseLayered(confs, &conf); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Name != "system" || conf.Port != 2 || conf.Host != "localhost" {
+		t.Errorf("unexpected result: %+v", conf)
+	}
+	if u := confs[1].Using; u != "using no project config file" {
+		t.Errorf("missing file: Using = %q", u)
+	}
+	for _, i := range []int{0, 2} {
+		if f := confs[i]; !strings.Contains(f.Using, f.Name) || f.Label != "test" {
+			t.Errorf("%s: Using = %q, Label = %q", f.Name, f.Using, f.Label)
+		}
+	}
+}
+
+func TestParseFlat(t *testing.T) {
+	src := `# settings
+NAME=server
+
+TITLE='it''s a test'  # trailing comment
+EMPTY=
+URL=http://host/a'#'b
+`
+	m := map[string]string{"KEEP": "x"}
+	err := ParseFlat(strings.NewReader(src), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"KEEP":  "x",
+		"NAME":  "server",
+		"TITLE": "it's a test",
+		"EMPTY": "",
+		"URL":   "http://host/a#b",
+	}
+	if len(m) != len(want) {
+		t.Errorf("unexpected result: %q", m)
+	}
+	for k, v := range want {
+		if m[k] != v {
+			t.Errorf("%s: got %q, want %q", k, m[k], v)
+		}
+	}
+
+	err = ParseFlat(strings.NewReader("A=1\nB=2 echo\n"), m)
+	list, ok := err.(*line.ErrorList)
+	if !ok || len(list.List) != 1 {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if e, ok := list.List[0].(line.Error); !ok || e.Line() != 2 {
+		t.Errorf("unexpected error: %v", list.List[0])
+	}
+}
+
+func TestWalkParts(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"c.ini": "port\tx\n",
+		"a.ini": "port\t1\n",
+		"b.ini": "port\ty\n",
+	}
+	if err := os.Mkdir(filepath.Join(dir, "parts"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, "parts", name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer isolateNameSpace()()
+	BindOS(dir, "test")
+
+	walk := func(opts ...WalkOption) ([]string, error) {
+		var names []string
+		_, err := WalkParts("parts.ini", func(name string, decode DecodeFn) error {
+			names = append(names, name)
+			var conf struct{ Port int }
+			return decode(&conf)
+		}, opts...)
+		return names, err
+	}
+
+	names, err := walk()
+	if s := strings.Join(names, " "); s != "a.ini b.ini" {
+		t.Errorf("fail-fast: unexpected parts walked: %s", s)
+	}
+	if el, ok := err.(*line.ErrorList); !ok || !strings.HasSuffix(el.Filename, "b.ini") {
+		t.Errorf("fail-fast: unexpected error: %#v", err)
+	}
+
+	names, err = walk(ContinueOnError())
+	if s := strings.Join(names, " "); s != "a.ini b.ini c.ini" {
+		t.Errorf("unexpected parts walked: %s", s)
+	}
+	el, ok := err.(*line.ErrorList)
+	if !ok || len(el.List) != 2 {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+	for i, suffix := range []string{"b.ini", "c.ini"} {
+		if e, ok := el.List[i].(*line.ErrorList); !ok || !strings.HasSuffix(e.Filename, suffix) {
+			t.Errorf("[%d] unexpected error: %#v", i, el.List[i])
+		}
+	}
+}
+
+func TestFieldNameToKey(t *testing.T) {
+	for name, key := range map[string]string{
+		"Port":        "port",
+		"ListenAddr":  "listen-addr",
+		"ServerID":    "server-id",
+		"ProxyURL":    "proxy-url",
+		"BytesPerSec": "bytes/sec",
+		"PerSec":      "per-sec",
+		"HTTPPort":    "HTTPPort",
+	} {
+		if got := FieldNameToKey(name); got != key {
+			t.Errorf("%s: got %q, want %q", name, got, key)
+		}
+		if got := keyToFieldName(key); got != name {
+			t.Errorf("%s: key %q maps to %q", name, key, got)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	type server struct {
+		Host        string
+		ServerID    int
+		BytesPerSec float64
+		Aliases     []string
+	}
+	type config struct {
+		Name    string
+		Title   string
+		Servers []server
+		Env     map[string]string
+		Tags    []string
+	}
+	in := config{
+		Name:  "main",
+		Title: "it's '#1'",
+		Servers: []server{
+			{Host: "a", ServerID: 1, BytesPerSec: 1.5, Aliases: []string{"x y", "z"}},
+			{Host: "b", ServerID: 2},
+			{Host: "c", Aliases: []string{}},
+		},
+		Env:  map[string]string{"PATH": "/bin /usr/bin"},
+		Tags: []string{},
+	}
+	var b strings.Builder
+	if err := Write(&b, &in); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "\tserver-id\t1\n") {
+		t.Errorf("unexpected output:\n%s", b.String())
+	}
+	var out config
+	if err := Parse(strings.NewReader(b.String()), &out); err != nil {
+		t.Fatalf("%v\n%s", err, b.String())
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("result differs:\n%+v\nwant:\n%+v\nencoded:\n%s", out, in, b.String())
+	}
+
+	in.Title = "a # comment"
+	if err := Write(&b, &in); err == nil {
+		t.Error("value looking like a comment not rejected")
+	}
+}
+
+func TestParseExpandEnv(t *testing.T) {
+	os.Setenv("INI_TEST_HOME", "/home/u")
+	defer os.Unsetenv("INI_TEST_HOME")
+
+	var conf struct {
+		Dir   string
+		Cache string
+	}
+	src := "dir\t${INI_TEST_HOME}/data\ncache\t$INI_TEST_UNDEF/cache\n"
+	if err := Parse(strings.NewReader(src), &conf, ExpandEnv(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Dir != "/home/u/data" || conf.Cache != "/cache" {
+		t.Errorf("unexpected result: %+v", conf)
+	}
+
+	err := Parse(strings.NewReader(src), &conf, ExpandEnv(nil), UndefinedVarErrors())
+	if err == nil || !strings.Contains(err.Error(), "INI_TEST_UNDEF") {
+		t.Errorf("undefined variable not reported: %v", err)
+	}
+
+	if err := Parse(strings.NewReader(src), &conf); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Dir != "${INI_TEST_HOME}/data" {
+		t.Errorf("unexpected expansion: %q", conf.Dir)
+	}
+}
@@ -0,0 +1,179 @@
+package interp
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/knieriem/text/stringutil"
+)
+
+var ErrDivByZero = errors.New("division by zero")
+
+const letHelp = `Evaluate the integer expression EXPR, and assign the result
+to variable NAME. Multiple EXPR arguments are joined using spaces.
+The expression may contain decimal numbers, parentheses, the
+operators + - * / %, and the comparisons == != < <= > >=, which
+evaluate to 1 if true, and 0 otherwise. As < and > would be
+interpreted as redirections, they must be quoted, as in: let x $a '<' 3
+Returns failure if the result is zero.`
+
+func (cl *CmdLine) letCmd(_ Context, arg []string) error {
+	v, err := evalArith(strings.Join(arg[2:], " "))
+	if err != nil {
+		return err
+	}
+	cl.env.set(arg[1], []string{strconv.FormatInt(v, 10)})
+	if v == 0 {
+		return errFalse
+	}
+	return nil
+}
+
+// arithOps lists the binary operators by increasing precedence.
+var arithOps = [][]string{
+	{"==", "!=", "<=", ">=", "<", ">"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// evalArith evaluates an integer expression.
+func evalArith(s string) (int64, error) {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+		if depth < 0 {
+			break
+		}
+	}
+	if depth != 0 {
+		return 0, errors.New("unbalanced parentheses")
+	}
+	return evalExpr(s)
+}
+
+// evalExpr evaluates an expression having balanced parentheses.
+// To achieve left associativity, the expression is split at the
+// rightmost operator of the lowest precedence level found outside
+// of parentheses.
+func evalExpr(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, errors.New("missing operand")
+	}
+	for _, ops := range arithOps {
+		i, op, err := findArithOp(s, ops)
+		if err != nil {
+			return 0, err
+		}
+		if i == -1 {
+			continue
+		}
+		x, err := evalExpr(s[:i])
+		if err != nil {
+			return 0, err
+		}
+		y, err := evalExpr(s[i+len(op):])
+		if err != nil {
+			return 0, err
+		}
+		return applyArithOp(op, x, y)
+	}
+	switch s[0] {
+	case '-':
+		v, err := evalExpr(s[1:])
+		return -v, err
+	case '+':
+		return evalExpr(s[1:])
+	}
+	if n := len(s) - 1; s[n] == ')' {
+		if stringutil.FindOpeningBracket(s, '(', n) != 0 {
+			return 0, errors.New("unbalanced parentheses")
+		}
+		return evalExpr(s[1:n])
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, errors.New("invalid number: " + s)
+	}
+	return v, nil
+}
+
+// findArithOp returns the position of the rightmost binary
+// operator out of ops that is not enclosed in parentheses,
+// or -1 if none is found.
+func findArithOp(s string, ops []string) (int, string, error) {
+	for i := len(s) - 1; i > 0; i-- {
+		switch s[i] {
+		case ')':
+			j := stringutil.FindOpeningBracket(s, '(', i)
+			if j == -1 {
+				return -1, "", errors.New("unbalanced parentheses")
+			}
+			i = j
+			continue
+		case '(':
+			return -1, "", errors.New("unbalanced parentheses")
+		}
+		for _, op := range ops {
+			start := i + 1 - len(op)
+			if start <= 0 || s[start:i+1] != op {
+				continue
+			}
+			if len(op) == 1 && strings.IndexByte("=!<>", s[start-1]) != -1 {
+				// part of a two character operator
+				continue
+			}
+			left := strings.TrimRight(s[:start], " \t")
+			if left == "" || strings.IndexByte("+-*/%=!<>(", left[len(left)-1]) != -1 {
+				// a unary operator
+				continue
+			}
+			return start, op, nil
+		}
+	}
+	return -1, "", nil
+}
+
+func applyArithOp(op string, x, y int64) (int64, error) {
+	b := func(cond bool) int64 {
+		if cond {
+			return 1
+		}
+		return 0
+	}
+	switch op {
+	case "==":
+		return b(x == y), nil
+	case "!=":
+		return b(x != y), nil
+	case "<=":
+		return b(x <= y), nil
+	case ">=":
+		return b(x >= y), nil
+	case "<":
+		return b(x < y), nil
+	case ">":
+		return b(x > y), nil
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	case "/", "%":
+		if y == 0 {
+			return 0, ErrDivByZero
+		}
+		if op == "/" {
+			return x / y, nil
+		}
+		return x % y, nil
+	}
+	return 0, errors.New("unknown operator: " + op)
+}
@@ -0,0 +1,69 @@
+package interp
+
+import (
+	"strings"
+	"testing"
+)
+
+var arithTests = []struct {
+	expr string
+	want int64
+	err  string
+}{
+	{expr: "1 + 2 * 3", want: 7},
+	{expr: "(1 + 2) * 3", want: 9},
+	{expr: "10 - 4 - 3", want: 3},
+	{expr: "7 / 2", want: 3},
+	{expr: "7 % 4", want: 3},
+	{expr: "-3 * -(2 + 1)", want: 9},
+	{expr: "2*-3", want: -6},
+	{expr: "1 + 1 == 2", want: 1},
+	{expr: "3 <= 2", want: 0},
+	{expr: "2 != 3", want: 1},
+	{expr: "1 / 0", err: "division by zero"},
+	{expr: "(1 + 2", err: "unbalanced parentheses"},
+	{expr: "1 + 2)", err: "unbalanced parentheses"},
+	{expr: "1 +", err: "missing operand"},
+	{expr: "x + 1", err: "invalid number: x"},
+}
+
+func TestEvalArith(t *testing.T) {
+	for _, tt := range arithTests {
+		v, err := evalArith(tt.expr)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%q: got error %v, want %q", tt.expr, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.expr, err)
+		} else if v != tt.want {
+			t.Errorf("%q: got %d, want %d", tt.expr, v, tt.want)
+		}
+	}
+}
+
+func TestLet(t *testing.T) {
+	script := `let d 1 / 0
+let a 6 * 7
+echo $a
+let b $a '>' 40
+if let c $a '<' 40 {
+	echo no
+}
+if not {
+	echo yes $b $c
+}
+`
+	stdout, stderr, err := runScript(t, script, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "42\nyes 1 0\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+	if !strings.Contains(stderr, ErrDivByZero.Error()) || strings.Contains(stderr, "false") {
+		t.Errorf("stderr: %q", stderr)
+	}
+}
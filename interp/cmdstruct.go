@@ -0,0 +1,117 @@
+package interp
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+)
+
+var cmdFnType = reflect.TypeOf(func(Context, []string) error { return nil })
+
+// CmdsFromStruct creates a CmdMap from the struct, or pointer to
+// a struct, v.
+//
+// Each exported field of v having type func(Context, []string) error
+// becomes a command. The function is used as the command's Fn.
+// Fields that are nil are ignored. Further attributes of the command
+// are derived from the field's tags:
+//
+//	cmd	the command's name, optionally followed by ",hidden";
+//		"-" skips the field. Defaults to the lower-cased field name.
+//	arg	space separated list of required arguments, e.g. "FILE"
+//	opt	space separated list of optional arguments, e.g. "N ..."
+//	help	the help text
+//	group	the group the command is listed in by `help'
+//
+// Exported fields that are structs, or pointers to structs, are
+// converted recursively, resulting in a command containing a map
+// of subcommands. The cmd, help, and group tags apply to them too.
+//
+// Exported methods of v with the same signature as a command
+// function become commands too; their names are lower-cased, and
+// they accept any number of arguments.
+func CmdsFromStruct(v interface{}) (CmdMap, error) {
+	rv := reflect.ValueOf(v)
+	isPtr := rv.Kind() == reflect.Ptr && !rv.IsNil() && rv.Elem().Kind() == reflect.Struct
+	if !isPtr && rv.Kind() != reflect.Struct {
+		return nil, errors.New("CmdsFromStruct: argument is not a struct, or pointer to a struct")
+	}
+	m := make(CmdMap, 8)
+
+	t := rv.Type()
+	for i, n := 0, t.NumMethod(); i < n; i++ {
+		meth := t.Method(i)
+		fn := rv.Method(i)
+		if fn.Type() != cmdFnType {
+			continue
+		}
+		m[strings.ToLower(meth.Name)] = &Cmd{
+			Fn:  fn.Interface().(func(Context, []string) error),
+			Opt: []string{"ARG", "..."},
+		}
+	}
+
+	sv := reflect.Indirect(rv)
+	st := sv.Type()
+	for i, n := 0, st.NumField(); i < n; i++ {
+		f := st.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name, hidden := parseCmdTag(f)
+		if name == "-" {
+			continue
+		}
+		fv := sv.Field(i)
+		cmd := &Cmd{
+			Help:   f.Tag.Get("help"),
+			Group:  f.Tag.Get("group"),
+			Hidden: hidden,
+		}
+		switch {
+		case f.Type == cmdFnType:
+			if fv.IsNil() {
+				continue
+			}
+			cmd.Fn = fv.Interface().(func(Context, []string) error)
+			cmd.Arg = strings.Fields(f.Tag.Get("arg"))
+			cmd.Opt = strings.Fields(f.Tag.Get("opt"))
+		case f.Type.Kind() == reflect.Struct:
+			if fv.CanAddr() {
+				fv = fv.Addr()
+			}
+			sub, err := CmdsFromStruct(fv.Interface())
+			if err != nil {
+				return nil, err
+			}
+			cmd.Map = sub
+		case f.Type.Kind() == reflect.Ptr && f.Type.Elem().Kind() == reflect.Struct:
+			if fv.IsNil() {
+				continue
+			}
+			sub, err := CmdsFromStruct(fv.Interface())
+			if err != nil {
+				return nil, err
+			}
+			cmd.Map = sub
+		default:
+			continue
+		}
+		m[name] = cmd
+	}
+	return m, nil
+}
+
+func parseCmdTag(f reflect.StructField) (name string, hidden bool) {
+	list := strings.Split(f.Tag.Get("cmd"), ",")
+	name = list[0]
+	if name == "" {
+		name = strings.ToLower(f.Name)
+	}
+	for _, opt := range list[1:] {
+		if opt == "hidden" {
+			hidden = true
+		}
+	}
+	return
+}
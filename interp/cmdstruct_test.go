@@ -0,0 +1,49 @@
+package interp
+
+import (
+	"strings"
+	"testing"
+)
+
+type testCmds struct {
+	Greet func(Context, []string) error `arg:"NAME" help:"Greet NAME."`
+	Sub   struct {
+		Add func(Context, []string) error `cmd:"plus" arg:"A B"`
+	} `help:"Subcommands."`
+	Skipped func(Context, []string) error `cmd:"-"`
+	prefix  string
+}
+
+func (c *testCmds) Shout(ctx Context, arg []string) error {
+	_, err := ctx.Println(c.prefix + strings.ToUpper(strings.Join(arg[1:], " ")))
+	return err
+}
+
+func TestCmdsFromStruct(t *testing.T) {
+	c := &testCmds{prefix: "> "}
+	c.Greet = func(ctx Context, arg []string) error {
+		_, err := ctx.Println("hello,", arg[1])
+		return err
+	}
+	c.Sub.Add = func(ctx Context, arg []string) error {
+		_, err := ctx.Println(arg[1] + "+" + arg[2])
+		return err
+	}
+	m, err := CmdsFromStruct(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd := m["greet"]; cmd == nil || cmd.Help != "Greet NAME." || len(cmd.Arg) != 1 {
+		t.Fatalf("unexpected greet command: %+v", cmd)
+	}
+	if _, ok := m["skipped"]; ok {
+		t.Errorf("skipped command present")
+	}
+	out, _, err := runScript(t, "greet world\nshout a b\nsub.plus 1 2\n", m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "hello, world\n> A B\n1+2\n"; out != want {
+		t.Errorf("unexpected output: %q, want %q", out, want)
+	}
+}
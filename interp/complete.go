@@ -0,0 +1,134 @@
+package interp
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/knieriem/text/rc"
+)
+
+// Complete returns completion candidates for the last word
+// of line; see CompleteAt, which also returns the offset of
+// the word being completed.
+func (cl *CmdLine) Complete(line string) []string {
+	candidates, _ := cl.CompleteAt(line, len(line))
+	return candidates
+}
+
+// CompleteAt returns completion candidates for the word of line
+// ending at byte offset pos, like a cursor position within the line.
+// Each candidate is meant to replace line[start:pos], and is quoted,
+// if necessary. Words are separated by white-space outside of
+// single quotes.
+//
+// The first word of a command line, and the arguments of help, are
+// completed using the names of commands and functions; words
+// starting with `$' are completed using the names of variables.
+func (cl *CmdLine) CompleteAt(line string, pos int) (candidates []string, start int) {
+	if pos < 0 || pos > len(line) {
+		return nil, pos
+	}
+	start, word := wordAt(line, pos)
+
+	var names []string
+	pfx := ""
+	switch {
+	case strings.HasPrefix(word, "$"):
+		pfx = "$"
+		word = word[1:]
+		for name := range cl.env.Visible() {
+			names = append(names, name)
+		}
+	case isFirstWord(line[:start]), firstWord(line) == "help":
+		names = cl.cmdNames(word)
+	}
+	for _, name := range names {
+		if strings.HasPrefix(name, word) {
+			candidates = append(candidates, pfx+rc.Quote(name))
+		}
+	}
+	sort.Strings(candidates)
+	return candidates, start
+}
+
+// wordAt returns the start offset of the word ending at pos,
+// together with the word's unquoted text.
+func wordAt(line string, pos int) (start int, word string) {
+	inQuote := false
+	for i := 0; i < pos; i++ {
+		switch c := line[i]; {
+		case c == '\'':
+			inQuote = !inQuote
+		case !inQuote && (c == ' ' || c == '\t'):
+			start = i + 1
+		}
+	}
+	word = line[start:pos]
+	if inQuote {
+		word += "'"
+	}
+	if u, err := rc.Unquote(word); err == nil {
+		word = u
+	}
+	return start, word
+}
+
+// isFirstWord reports whether the text preceding a word
+// consists of assignments only.
+func isFirstWord(preceding string) bool {
+	for _, f := range strings.Fields(preceding) {
+		if !strings.Contains(f, "=") {
+			return false
+		}
+	}
+	return true
+}
+
+func firstWord(line string) string {
+	for _, f := range strings.Fields(line) {
+		if !strings.Contains(f, "=") {
+			return f
+		}
+	}
+	return ""
+}
+
+// cmdNames returns the names of the commands and functions that
+// may complete the possibly dotted prefix, as in `cmd.subcmd'.
+func (cl *CmdLine) cmdNames(prefix string) (names []string) {
+	m := cl.cmdMap
+	isRoot := true
+	dotted := ""
+	if i := strings.LastIndex(prefix, "."); i != -1 {
+		cmd, ok := cl.lookupCmd(prefix[:i])
+		if !ok || cmd.Map == nil {
+			return nil
+		}
+		dotted = prefix[:i+1]
+		m = cmd.Map
+		isRoot = false
+	}
+	seen := make(map[string]bool, 32)
+	add := func(m CmdMap) {
+		for name, cmd := range m {
+			if name != "" && !cmd.Hidden && !seen[name] {
+				seen[name] = true
+				names = append(names, dotted+name)
+			}
+		}
+	}
+	add(m)
+	if isRoot {
+		add(cl.builtin)
+		for name := range cl.funcMap {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+		if !seen["help"] {
+			names = append(names, "help")
+		}
+	}
+	return names
+}
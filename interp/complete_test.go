@@ -0,0 +1,78 @@
+package interp
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompleteAt(t *testing.T) {
+	noop := func(Context, []string) error { return nil }
+	m := CmdMap{
+		"eval":  {Fn: noop},
+		"echo":  {Fn: noop},
+		"ecu":   {Map: CmdMap{"read": {Fn: noop}, "reset": {Fn: noop}}},
+		"xecho": {Fn: noop, Hidden: true},
+	}
+	cl := NewCmdInterp(bufio.NewScanner(strings.NewReader("")), m)
+	cl.env.stack.Set("editor", []string{"vi"})
+
+	tests := []struct {
+		line  string
+		pos   int
+		want  []string
+		start int
+	}{
+		{"ec", 2, []string{"echo", "ecu"}, 0},
+		{"ec foo bar", 2, []string{"echo", "ecu"}, 0},
+		{"a=1 ev", 6, []string{"eval"}, 4},
+		{"ecu.re x", 6, []string{"ecu.read", "ecu.reset"}, 0},
+		{"builtin.ech", 11, []string{"builtin.echo"}, 0},
+		{"nonexist.x", 10, nil, 0},
+		{"help ev x", 7, []string{"eval"}, 5},
+		{"echo ev x", 7, nil, 5},
+		{"echo 'a b' $ed", 14, []string{"$editor"}, 11},
+		{"'ev", 3, []string{"eval"}, 0},
+	}
+	for _, tt := range tests {
+		got, start := cl.CompleteAt(tt.line, tt.pos)
+		if !reflect.DeepEqual(got, tt.want) || start != tt.start {
+			t.Errorf("%q at %d: got %q, %d; want %q, %d", tt.line, tt.pos, got, start, tt.want, tt.start)
+		}
+	}
+
+	if got := cl.Complete("echo $ed"); !reflect.DeepEqual(got, []string{"$editor"}) {
+		t.Errorf("Complete: got %q", got)
+	}
+}
+
+func TestFindCommands(t *testing.T) {
+	noop := func(Context, []string) error { return nil }
+	m := CmdMap{
+		"net": {Map: CmdMap{
+			"up":   {Fn: noop},
+			"down": {Fn: noop},
+			"dbg":  {Fn: noop, Hidden: true},
+			"if":   {Map: CmdMap{"list": {Fn: noop}}},
+		}},
+		"netstat": {Fn: noop},
+	}
+	cl := NewCmdInterp(bufio.NewScanner(strings.NewReader("")), m)
+
+	want := []string{"net.down", "net.if", "net.if.list", "net.up"}
+	if got := cl.FindCommands("net.*"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := cl.FindCommands("ech?"); !reflect.DeepEqual(got, []string{"echo"}) {
+		t.Errorf("builtin not found: %q", got)
+	}
+
+	stdout, _, err := runScript(t, "fn netfn {}\nwhich 'net*'\n", m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "net\nnet.down\nnet.if\nnet.if.list\nnet.up\nnetfn\nnetstat\n"; stdout != want {
+		t.Errorf("unexpected output: %q, want %q", stdout, want)
+	}
+}
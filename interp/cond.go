@@ -0,0 +1,77 @@
+package interp
+
+import (
+	"errors"
+)
+
+var errFalse = errors.New("false")
+
+const testHelp = `Evaluate a conditional expression, and return success
+if it is true. Supported expressions are:
+
+	STRING	STRING is not empty
+	-n STRING	STRING is not empty
+	-z STRING	STRING is empty
+	S1 = S2	the strings are equal
+	S1 != S2	the strings are not equal
+	-e FILE	FILE exists
+	-f FILE	FILE exists and is a regular file
+	-d FILE	FILE exists and is a directory
+
+If called as ` + "`['" + `, the last argument must be ` + "`]'."
+
+func (cl *CmdLine) testCmd(_ Context, arg []string) error {
+	if arg[0] == "[" {
+		n := len(arg) - 1
+		if n == 0 || arg[n] != "]" {
+			return errors.New("missing `]'")
+		}
+		arg = arg[:n]
+	}
+	ok, err := cl.evalTest(arg[1:])
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errFalse
+	}
+	return nil
+}
+
+func (cl *CmdLine) evalTest(arg []string) (bool, error) {
+	switch len(arg) {
+	case 0:
+		return false, nil
+	case 1:
+		return arg[0] != "", nil
+	case 2:
+		switch arg[0] {
+		case "-n":
+			return arg[1] != "", nil
+		case "-z":
+			return arg[1] == "", nil
+		case "-e", "-f", "-d":
+			fi, err := cl.Stat(arg[1])
+			if err != nil {
+				return false, nil
+			}
+			switch arg[0] {
+			case "-f":
+				return fi.Mode().IsRegular(), nil
+			case "-d":
+				return fi.IsDir(), nil
+			}
+			return true, nil
+		}
+	case 3:
+		switch arg[1] {
+		case "=":
+			return arg[0] == arg[2], nil
+		case "!=":
+			return arg[0] != arg[2], nil
+		}
+	default:
+		return false, ErrWrongNArg
+	}
+	return false, errors.New("unknown operator")
+}
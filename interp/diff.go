@@ -0,0 +1,230 @@
+package interp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var errFilesDiffer = errors.New("files differ")
+
+func (cl *CmdLine) diffCmd(ctx Context, arg []string) error {
+	a, err := cl.readLines(arg[1])
+	if err != nil {
+		return err
+	}
+	b, err := cl.readLines(arg[2])
+	if err != nil {
+		return err
+	}
+	hunks := diffLines(a, b)
+	if len(hunks) == 0 {
+		return nil
+	}
+	w := extractWriter(ctx)
+	w.Printf("--- %s\n+++ %s", arg[1], arg[2])
+	for _, h := range hunks {
+		w.Printf("@@ -%s +%s @@", hunkRange(h.i, len(h.del)), hunkRange(h.j, len(h.ins)))
+		for _, s := range h.del {
+			w.Printf("-%s", s)
+		}
+		for _, s := range h.ins {
+			w.Printf("+%s", s)
+		}
+	}
+	return errFilesDiffer
+}
+
+func (cl *CmdLine) readLines(filename string) ([]string, error) {
+	f, err := cl.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return scanLines(f)
+}
+
+func scanLines(r io.Reader) ([]string, error) {
+	var lines []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+	return lines, s.Err()
+}
+
+// A hunk describes lines del, starting at index i of the
+// first file, that have been replaced by lines ins, starting
+// at index j of the second file.
+type hunk struct {
+	i, j     int
+	del, ins []string
+}
+
+// hunkRange formats the start line and the number of lines
+// of a hunk like diff -u does.
+func hunkRange(start, n int) string {
+	if n == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if n == 1 {
+		return fmt.Sprint(start + 1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, n)
+}
+
+// diffLines computes the differences between a and b using the
+// linear space variant of Myers' algorithm, which finds a shortest
+// edit script in O((n+m)·d) time, d being the number of differences.
+func diffLines(a, b []string) []hunk {
+	d := &differ{a: a, b: b, del: make([]bool, len(a)), ins: make([]bool, len(b))}
+	d.compare(0, len(a), 0, len(b))
+
+	var hunks []hunk
+	n, m := len(a), len(b)
+	i, j := 0, 0
+	for i < n || j < m {
+		if i < n && j < m && !d.del[i] && !d.ins[j] {
+			i++
+			j++
+			continue
+		}
+		h := hunk{i: i, j: j}
+		for ; i < n && d.del[i]; i++ {
+			h.del = append(h.del, a[i])
+		}
+		for ; j < m && d.ins[j]; j++ {
+			h.ins = append(h.ins, b[j])
+		}
+		hunks = append(hunks, h)
+	}
+	return hunks
+}
+
+// A differ marks the lines of a that have to be deleted,
+// and the lines of b that have to be inserted.
+type differ struct {
+	a, b     []string
+	del, ins []bool
+}
+
+// compare marks the differences between a[aLo:aHi] and b[bLo:bHi].
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		aLo++
+		bLo++
+	}
+	for aLo < aHi && bLo < bHi && d.a[aHi-1] == d.b[bHi-1] {
+		aHi--
+		bHi--
+	}
+	if aLo == aHi || bLo == bHi {
+		d.mark(aLo, aHi, bLo, bHi)
+		return
+	}
+	x, y, ok := d.middleSnake(aLo, aHi, bLo, bHi)
+	if !ok {
+		d.mark(aLo, aHi, bLo, bHi)
+		return
+	}
+	d.compare(aLo, x, bLo, y)
+	d.compare(x, aHi, y, bHi)
+}
+
+// mark marks all lines of a[aLo:aHi] as deleted,
+// and those of b[bLo:bHi] as inserted.
+func (d *differ) mark(aLo, aHi, bLo, bHi int) {
+	for i := aLo; i < aHi; i++ {
+		d.del[i] = true
+	}
+	for j := bLo; j < bHi; j++ {
+		d.ins[j] = true
+	}
+}
+
+// middleSnake searches a shortest edit script for a[aLo:aHi]
+// and b[bLo:bHi] from both ends at the same time, and returns
+// the point (x, y) where the forward and the reverse paths meet,
+// which splits the problem into two smaller ones. The slices
+// must neither be empty, nor start or end with equal lines.
+func (d *differ) middleSnake(aLo, aHi, bLo, bHi int) (x, y int, ok bool) {
+	a, b := d.a[aLo:aHi], d.b[bLo:bHi]
+	n, m := len(a), len(b)
+	maxD := (n + m + 1) / 2
+	off := maxD
+
+	// vf[off+k] and vb[off+k] hold the furthest x reached on
+	// diagonal k by the forward, and the reverse path; the
+	// latter counts from the end of the slices
+	vf := make([]int, 2*maxD+2)
+	vb := make([]int, 2*maxD+2)
+	for i := range vf {
+		vf[i] = -1
+		vb[i] = -1
+	}
+	vf[off+1] = 0
+	vb[off+1] = 0
+	delta := n - m
+	front := delta%2 != 0
+
+	// bounds of the diagonals that are still within the slices
+	kfStart, kfEnd, kbStart, kbEnd := 0, 0, 0, 0
+
+	for D := 0; D < maxD; D++ {
+		for k := -D + kfStart; k <= D-kfEnd; k += 2 {
+			var x int
+			if k == -D || k != D && vf[off+k-1] < vf[off+k+1] {
+				x = vf[off+k+1]
+			} else {
+				x = vf[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			vf[off+k] = x
+			switch {
+			case x > n:
+				kfEnd += 2
+			case y > m:
+				kfStart += 2
+			case front:
+				if kb := off + delta - k; kb >= 0 && kb < len(vb) && vb[kb] != -1 {
+					if x >= n-vb[kb] {
+						return aLo + x, bLo + y, true
+					}
+				}
+			}
+		}
+		for k := -D + kbStart; k <= D-kbEnd; k += 2 {
+			var x int
+			if k == -D || k != D && vb[off+k-1] < vb[off+k+1] {
+				x = vb[off+k+1]
+			} else {
+				x = vb[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[n-x-1] == b[m-y-1] {
+				x++
+				y++
+			}
+			vb[off+k] = x
+			switch {
+			case x > n:
+				kbEnd += 2
+			case y > m:
+				kbStart += 2
+			case !front:
+				if kf := off + delta - k; kf >= 0 && kf < len(vf) && vf[kf] != -1 {
+					xf := vf[kf]
+					if xf >= n-x {
+						return aLo + xf, bLo + xf - (kf - off), true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
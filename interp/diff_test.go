@@ -0,0 +1,76 @@
+package interp
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// lcsLen returns the length of the longest common subsequence
+// of a and b.
+func lcsLen(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for i := range a {
+		for j := range b {
+			switch {
+			case a[i] == b[j]:
+				cur[j+1] = prev[j] + 1
+			case prev[j+1] >= cur[j]:
+				cur[j+1] = prev[j+1]
+			default:
+				cur[j+1] = cur[j]
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// applyHunks applies the hunks to a, which must result in b.
+func applyHunks(a []string, hunks []hunk) []string {
+	var out []string
+	i := 0
+	for _, h := range hunks {
+		out = append(out, a[i:h.i]...)
+		out = append(out, h.ins...)
+		i = h.i + len(h.del)
+	}
+	return append(out, a[i:]...)
+}
+
+func TestDiffLines(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	gen := func() []string {
+		lines := make([]string, rnd.Intn(30))
+		for i := range lines {
+			lines[i] = string(rune('a' + rnd.Intn(4)))
+		}
+		return lines
+	}
+	for n := 0; n < 2000; n++ {
+		a, b := gen(), gen()
+		hunks := diffLines(a, b)
+		if got := applyHunks(a, hunks); strings.Join(got, "") != strings.Join(b, "") {
+			t.Fatalf("%q -> %q: got %q", a, b, got)
+		}
+		nEdit := 0
+		for _, h := range hunks {
+			nEdit += len(h.del) + len(h.ins)
+		}
+		if want := len(a) + len(b) - 2*lcsLen(a, b); nEdit != want {
+			t.Fatalf("%q -> %q: %d edits, want %d", a, b, nEdit, want)
+		}
+	}
+
+	// large inputs with few differences
+	a := make([]string, 100000)
+	for i := range a {
+		a[i] = strings.Repeat("x", i%7)
+	}
+	b := append([]string{"new"}, a[:50000]...)
+	b = append(b, a[50001:]...)
+	if hunks := diffLines(a, b); len(hunks) != 2 {
+		t.Errorf("got %d hunks, want 2", len(hunks))
+	}
+}
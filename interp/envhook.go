@@ -0,0 +1,88 @@
+package interp
+
+import (
+	"sort"
+
+	"github.com/knieriem/text/rc"
+)
+
+// An EnvHookFunc is called for each modification of the environment
+// of the interpreter. Op is one of "set", "insert", "push", and "pop",
+// named after the corresponding rc.EnvStack methods, "local", if
+// a variable has been declared local, or "restore", if the value of
+// a local variable is restored at the end of a function, or if the
+// environment is rolled back after a failed transaction, see
+// WithTransaction; value is nil if the variable did not exist before.
+// For "insert", "push", "pop", and a rollback, the hook is called for
+// each variable concerned, in order of their names.
+type EnvHookFunc func(op, name string, value []string)
+
+// WithEnvHook registers a function that is called each time
+// the environment is modified, e.g. by assignments, or by
+// calls of functions, which set $*. Environments of
+// background jobs are not observed.
+func WithEnvHook(f EnvHookFunc) Option {
+	return func(cl *CmdLine) {
+		cl.envHook = f
+	}
+}
+
+func (env *Env) set(name string, value []string) {
+	env.stack.Set(name, value)
+	env.notify("set", name, value)
+}
+
+func (env *Env) insert(m rc.EnvMap) {
+	env.stack.Insert(m)
+	env.notifyMap("insert", m)
+}
+
+func (env *Env) push(m rc.EnvMap) {
+	env.stack.Push(m)
+	env.notifyMap("push", m)
+}
+
+func (env *Env) pop() {
+	m := env.stack[len(env.stack)-1]
+	env.stack.Pop()
+	env.notifyMap("pop", m)
+}
+
+// restore reinstates the state of the stack captured by snap,
+// reporting each variable whose visible value changes as "restore".
+func (env *Env) restore(snap rc.EnvStack) {
+	if env.hook == nil {
+		env.stack.Restore(snap)
+		return
+	}
+	before := env.Visible()
+	env.stack.Restore(snap)
+	added, changed, removed := before.Diff(env.Visible())
+	for name, value := range added {
+		changed[name] = value
+	}
+	for name := range removed {
+		changed[name] = nil
+	}
+	env.notifyMap("restore", changed)
+}
+
+func (env *Env) notify(op, name string, value []string) {
+	if env.hook != nil {
+		env.hook(op, name, value)
+	}
+}
+
+func (env *Env) notifyMap(op string, m rc.EnvMap) {
+	if env.hook == nil {
+		return
+	}
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		env.hook(op, name, m[name])
+	}
+}
@@ -0,0 +1,70 @@
+package interp
+
+import (
+	"path"
+	"sort"
+)
+
+// FindCommands returns the sorted, full dotted names of the commands
+// whose names match pattern, as understood by path.Match. Nested
+// command maps, builtins and functions are considered; hidden
+// commands are excluded.
+func (cl *CmdLine) FindCommands(pattern string) []string {
+	names, _ := cl.findCommands(pattern, false)
+	return names
+}
+
+func (cl *CmdLine) findCommands(pattern string, hidden bool) (names []string, err error) {
+	if _, err = path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, 32)
+	add := func(name string) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		if ok, _ := path.Match(pattern, name); ok {
+			names = append(names, name)
+		}
+	}
+	var walk func(prefix string, m CmdMap)
+	walk = func(prefix string, m CmdMap) {
+		for name, cmd := range m {
+			if name == "" || cmd.Hidden && !hidden {
+				continue
+			}
+			add(prefix + name)
+			// The `builtin' map is walked separately, at
+			// the top level, to avoid listing each builtin twice.
+			if cmd.Map != nil && !(prefix == "" && name == "builtin") {
+				walk(prefix+name+".", cmd.Map)
+			}
+		}
+	}
+	walk("", cl.cmdMap)
+	walk("", cl.builtin)
+	for name := range cl.funcMap {
+		add(name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (cl *CmdLine) whichCmd(ctx Context, arg []string) error {
+	found := false
+	for _, pattern := range arg[1:] {
+		names, err := cl.findCommands(pattern, false)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			ctx.Println(name)
+			found = true
+		}
+	}
+	if !found {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package interp
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+type helpGroup struct {
+	Name     string    `json:"name"`
+	Commands []helpCmd `json:"commands"`
+}
+
+type helpCmd struct {
+	Name   string   `json:"name"`
+	Flags  string   `json:"flags,omitempty"`
+	Arg    []string `json:"arg,omitempty"`
+	Opt    []string `json:"opt,omitempty"`
+	Help   string   `json:"help,omitempty"`
+	Hidden bool     `json:"hidden,omitempty"`
+
+	// Groups contains the subcommands of commands having a Map.
+	Groups []helpGroup `json:"groups,omitempty"`
+}
+
+// HelpJSON writes a description of the command tree, including
+// hidden commands and the subcommands of nested Maps, to w in JSON
+// format. Commands are arranged in groups, in the same order as
+// presented by `help'. The output is also available using `help -json'.
+func (cl *CmdLine) HelpJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(cl.helpGroups(cl.cmdMap))
+}
+
+func (cl *CmdLine) helpGroups(m CmdMap) []helpGroup {
+	outmap := make(map[string][]helpCmd, 8)
+	for name, v := range m {
+		c := helpCmd{
+			Name:   name,
+			Flags:  v.Flags,
+			Arg:    v.Arg,
+			Opt:    v.Opt,
+			Help:   v.Help,
+			Hidden: v.Hidden,
+		}
+		if v.Map != nil {
+			c.Groups = cl.helpGroups(v.Map)
+		}
+		group := cl.cmdGroup(v)
+		outmap[group] = append(outmap[group], c)
+	}
+
+	gNames := make([]string, 0, len(outmap))
+	for name := range outmap {
+		gNames = append(gNames, name)
+	}
+	cl.sortGroups(gNames)
+	groups := make([]helpGroup, 0, len(gNames))
+	for _, name := range gNames {
+		list := outmap[name]
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].Name < list[j].Name
+		})
+		groups = append(groups, helpGroup{Name: groupTitle(name), Commands: list})
+	}
+	return groups
+}
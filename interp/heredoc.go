@@ -0,0 +1,36 @@
+package interp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/knieriem/text/rc"
+)
+
+// readHereDoc reads lines from the current input up to the
+// delimiter of the here-document h. Unless the delimiter has been
+// quoted, variable references are expanded.
+func (cl *CmdLine) readHereDoc(h *rc.HereDoc) (string, error) {
+	var b strings.Builder
+
+	for {
+		cl.writePrompt2()
+		if !cl.Scan() {
+			err := cl.Err()
+			if err == nil {
+				err = fmt.Errorf("here-document: unexpected EOF while looking for delimiter %q", h.Delim)
+			}
+			return "", err
+		}
+		s := cl.Text()
+		if s == h.Delim {
+			break
+		}
+		if !h.Quoted {
+			s = cl.tok.ExpandVars(s)
+		}
+		b.WriteString(s)
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
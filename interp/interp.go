@@ -10,10 +10,12 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"os/user"
 	"path"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 	"unicode"
@@ -21,6 +23,7 @@ import (
 	gioutil "github.com/knieriem/g/ioutil"
 	"github.com/knieriem/text"
 	"github.com/knieriem/text/rc"
+	"github.com/knieriem/text/stringutil"
 )
 
 const (
@@ -28,19 +31,37 @@ const (
 )
 
 type Cmd struct {
-	Map         CmdMap
-	Fn          func(_ Context, arg []string) error
-	Arg         []string
-	Opt         []string
-	Help        string
-	Hidden      bool
-	Group       string
-	Flags       string
-	InitFlags   func(f *flag.FlagSet)
+	Map    CmdMap
+	Fn     func(_ Context, arg []string) error
+	Arg    []string
+	Opt    []string
+	Help   string
+	Hidden bool
+	Group  string
+	Flags  string
+
+	// InitFlags, if set, defines the flags of the command on a
+	// new FlagSet, which is used to parse leading flags before
+	// Fn is called with the remaining arguments. As usual, "--"
+	// terminates the flags; arguments following it are passed
+	// to Fn as they are, even if they start with '-'. Invalid
+	// flags are reported as an error of the command.
+	InitFlags func(f *flag.FlagSet)
+
 	ignoreEnv   bool
 	HideFailure bool
 	weakStatus  bool
 	isCompound  bool
+	fgOnly      bool
+
+	// dryRunSafe marks built-in commands without side effects
+	// outside of the interpreter, which are run in dry-run mode.
+	dryRunSafe bool
+
+	// Timeout, if non-zero, limits the time the command may
+	// run. After it has expired, the command's context will
+	// be cancelled, and ErrTimeout will be reported.
+	Timeout time.Duration
 }
 
 type CmdMap map[string]*Cmd
@@ -51,22 +72,64 @@ type Context interface {
 	Getenv(string) string
 }
 
+// An InputContext is a Context that provides the command's input.
+// The Context passed to a command by CmdLine implements it; a command
+// may check for it using a type assertion.
+type InputContext interface {
+	Context
+
+	// Stdin returns a reader providing the command's input,
+	// like a here-document. If no input has been specified,
+	// the reader returns EOF immediately.
+	Stdin() io.Reader
+}
+
+// An ErrorContext is a Context that provides a separate writer for
+// the command's error output. The Context passed to a command by
+// CmdLine implements it.
+type ErrorContext interface {
+	Context
+
+	// Stderr returns the writer for the command's error output,
+	// which may have been redirected using "2>file", or "2>&1".
+	Stderr() io.Writer
+}
+
 type icontext struct {
 	text.Writer
 	context.Context
+	cancel context.CancelFunc
 	getenv func(string) string
+	stdin  io.Reader
+	stderr io.Writer
+
+	// flags holds the flags parsed for the command,
+	// if it has been defined using InitFlags
+	flags *flag.FlagSet
 }
 
 func (ictx *icontext) Getenv(s string) string {
 	return ictx.getenv(s)
 }
 
+func (ictx *icontext) Stdin() io.Reader {
+	if ictx.stdin == nil {
+		return strings.NewReader("")
+	}
+	return ictx.stdin
+}
+
+func (ictx *icontext) Stderr() io.Writer {
+	return ictx.stderr
+}
+
 type CmdLine struct {
 	*cmdLineReader
 	cur         stackEntry
 	inputStack  []stackEntry
 	lastOk      bool
 	savedPrompt string
+	shownPrompt string
 	tok         *rc.Tokenizer
 	env         *Env
 	tplMap      *templateMap
@@ -82,7 +145,22 @@ type CmdLine struct {
 	ExtraHelp    func()
 	DefaultGroup string
 	Prompt       string
-	WritePrompt  func(string) error
+
+	// Prompt2 is the continuation prompt, written in interactive
+	// mode before each further line of a block, or of a here-document,
+	// is read. Like Prompt, it may contain template actions.
+	Prompt2     string
+	WritePrompt func(string) error
+
+	// GroupOrder, if not empty, specifies the order in which help
+	// lists groups, which are referred to by their titles, i.e.
+	// without a sort prefix terminated by "__". Groups not named
+	// in GroupOrder follow in the default order.
+	GroupOrder []string
+
+	// HideEmptyGroups makes help omit the headings of groups
+	// that only contain hidden commands.
+	HideEmptyGroups bool
 
 	// Stdout is used for writing normal output.
 	// It is initialized with os.Stdout.
@@ -92,16 +170,37 @@ type CmdLine struct {
 	Stdout io.Writer
 	errOut io.Writer
 
+	// cmdErrOut, if not nil, receives the error output
+	// of the current command instead of errOut.
+	cmdErrOut io.Writer
+
 	Forward     io.Writer
 	printCmd    func(*rc.CmdLine)
 	handleError func(err error)
 	Open        func(filename string) (io.ReadCloser, error)
+	Stat        func(filename string) (os.FileInfo, error)
 	cmdHook     CmdHookFunc
+	envHook     EnvHookFunc
 
 	cIntr         chan struct{}
 	exitFlag      bool
+	exitStatus    int
 	OpenRedirFile func(name string, flag int, perm os.FileMode) (RedirFile, error)
 	redirFileMap  map[string]RedirFile
+	jobs          jobList
+	stripBOM      bool
+	traps         map[string]string
+
+	defaultTimeout time.Duration
+	banner         string
+	transaction    bool
+	maxStackDepth  int
+	verbosity      int
+	dryRun         bool
+	lineBuffered   bool
+	outMu          sync.Mutex
+	kv             map[string][]string
+	kvJSON         map[string]bool // keys of values stored by json-set
 }
 
 type RedirFile interface {
@@ -113,10 +212,34 @@ type RedirFile interface {
 type cmdLineReader struct {
 	text.Scanner
 	io.Closer
+	stripBOM bool
+	lineNum  int
 }
 
 func newCmdLineReader(s text.Scanner, c io.Closer) *cmdLineReader {
-	return &cmdLineReader{s, c}
+	return &cmdLineReader{Scanner: s, Closer: c}
+}
+
+func (r *cmdLineReader) Scan() bool {
+	if !r.Scanner.Scan() {
+		return false
+	}
+	r.lineNum++
+	return true
+}
+
+func (r *cmdLineReader) Text() string {
+	s := r.Scanner.Text()
+	if r.stripBOM && r.lineNum == 1 {
+		s = text.StripBOM(s)
+	}
+	return s
+}
+
+func (cl *CmdLine) newLineReader(rc io.ReadCloser) *cmdLineReader {
+	r := newCmdLineReader(bufio.NewScanner(rc), rc)
+	r.stripBOM = cl.stripBOM
+	return r
 }
 
 type Option func(cl *CmdLine)
@@ -139,8 +262,208 @@ func WithEnv(e *Env) Option {
 	}
 }
 
+// WithErrorHandler specifies a function that is called for each error
+// that occurs while processing commands, instead of printing it to
+// the error output. Errors returned by commands are passed as *FnError,
+// carrying the name of the command.
+func WithErrorHandler(f func(err error)) Option {
+	return func(cl *CmdLine) {
+		cl.handleError = f
+	}
+}
+
+// WithPrompt sets the prompt, which makes the interpreter
+// work in interactive mode. If the prompt contains template
+// actions, it is executed as a text/template each time before
+// it is written. The template may use the functions available
+// for the prefix variable, like `now', and `cwd', returning the
+// current directory. The data passed to the template is
+// a PromptData value.
+func WithPrompt(prompt string) Option {
+	return func(cl *CmdLine) {
+		cl.Prompt = prompt
+	}
+}
+
+// WithPrompt2 sets the continuation prompt, see CmdLine.Prompt2.
+func WithPrompt2(prompt string) Option {
+	return func(cl *CmdLine) {
+		cl.Prompt2 = prompt
+	}
+}
+
+// PromptData is passed to a prompt template.
+type PromptData struct {
+	// Env contains the variables visible in the current scope;
+	// lists of values are joined using spaces.
+	Env map[string]string
+
+	// Status is 0 if the last command has succeeded,
+	// and 1 otherwise.
+	Status int
+}
+
+// writePrompt renders the prompt, and writes it using WritePrompt.
+func (cl *CmdLine) writePrompt() error {
+	prompt := cl.Prompt
+	if strings.Contains(prompt, "{{") {
+		prompt = cl.renderPrompt(prompt)
+	}
+	cl.shownPrompt = prompt
+	return cl.WritePrompt(prompt)
+}
+
+// writePrompt2 writes the continuation prompt, if the interpreter
+// is in interactive mode, i.e. reading from the top-level input
+// with a prompt set.
+func (cl *CmdLine) writePrompt2() error {
+	prompt := ""
+	if cl.Prompt != "" {
+		prompt = cl.Prompt2
+		if strings.Contains(prompt, "{{") {
+			prompt = cl.renderPrompt(prompt)
+		}
+	}
+	return cl.WritePrompt(prompt)
+}
+
+func (cl *CmdLine) renderPrompt(prompt string) string {
+	t, err := cl.tplMap.Get("prompt", prompt)
+	if err != nil {
+		return "<" + err.Error() + ">"
+	}
+	data := &PromptData{Env: make(map[string]string, 16)}
+	for name, v := range cl.env.Visible() {
+		data.Env[name] = strings.Join(v, " ")
+	}
+	if !cl.lastOk {
+		data.Status = 1
+	}
+	var b strings.Builder
+	if err = t.Execute(&b, data); err != nil {
+		return "<" + err.Error() + ">"
+	}
+	return b.String()
+}
+
+// WithBanner specifies a banner that is printed when Process
+// is started in interactive mode, i.e. if a Prompt has been set.
+// The banner is a text/template that may use the functions
+// available for the prefix variable, like `now'.
+func WithBanner(banner string) Option {
+	return func(cl *CmdLine) {
+		cl.banner = banner
+	}
+}
+
+func (cl *CmdLine) writeBanner() {
+	t, err := cl.tplMap.Get("banner", cl.banner)
+	if err == nil {
+		err = t.Execute(cl.Stdout, nil)
+	}
+	if err != nil {
+		cl.setError(fmt.Errorf("banner: %w", err))
+	}
+}
+
+// WithDefaultTimeout sets a timeout that applies to
+// each command that doesn't specify a Timeout itself.
+func WithDefaultTimeout(d time.Duration) Option {
+	return func(cl *CmdLine) {
+		cl.defaultTimeout = d
+	}
+}
+
+// WithStripBOM makes the interpreter remove a UTF-8 byte order
+// mark from the first line of each input, i.e. the main input,
+// the InitRc, and files read using the `.' command.
+func WithStripBOM() Option {
+	return func(cl *CmdLine) {
+		cl.stripBOM = true
+	}
+}
+
+// WithTransaction makes Process restore the variables and
+// function definitions present when it was called, in case
+// it returns an error; changes made by a failing session
+// are discarded.
+func WithTransaction() Option {
+	return func(cl *CmdLine) {
+		cl.transaction = true
+	}
+}
+
+// WithMaxStackDepth limits the depth of the input stack, which grows
+// with each nested function call, block, or file read using the `.'
+// command, to n. Exceeding it makes the command in question fail
+// with ErrStackOverflow, and aborts the current input. This protects
+// against unbounded recursion. The default is DefaultMaxStackDepth.
+func WithMaxStackDepth(n int) Option {
+	return func(cl *CmdLine) {
+		cl.maxStackDepth = n
+	}
+}
+
+// WithHomeDir enables tilde expansion, which replaces an unquoted
+// word "~", or a leading "~/" or "~user" of unquoted words, by a
+// home directory, see rc.Tokenizer.HomeDir. The home directories
+// are determined by f; LookupHomeDir may be used to look them up
+// using the os/user package. By default, or if f is nil, tilde
+// expansion is disabled.
+func WithHomeDir(f func(user string) (string, error)) Option {
+	return func(cl *CmdLine) {
+		cl.tok.HomeDir = f
+	}
+}
+
+// LookupHomeDir returns the home directory of the named user,
+// or of the current user, if name is empty.
+func LookupHomeDir(name string) (string, error) {
+	if name == "" {
+		return os.UserHomeDir()
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", err
+	}
+	return u.HomeDir, nil
+}
+
+// WithDryRun enables a mode where commands are printed, like with
+// flag x, but not executed, except for built-in commands that
+// have no side effects, like echo, fn, and control flow commands.
+// Skipped commands are treated as if they had succeeded.
+// Redirections are checked, but files are not opened;
+// the output is discarded instead.
+func WithDryRun(enable bool) Option {
+	return func(cl *CmdLine) {
+		cl.dryRun = enable
+	}
+}
+
+// saveState records the environment, and the function definitions;
+// it returns a function that restores them.
+func (cl *CmdLine) saveState() (restore func()) {
+	stack := cl.env.stack.Snapshot()
+	exported := make(map[string]bool, len(cl.env.exported))
+	for name := range cl.env.exported {
+		exported[name] = true
+	}
+	funcMap := make(map[string]string, len(cl.funcMap))
+	for name, body := range cl.funcMap {
+		funcMap[name] = body
+	}
+	return func() {
+		cl.env.restore(stack)
+		cl.env.exported = exported
+		cl.funcMap = funcMap
+	}
+}
+
 type Env struct {
-	stack rc.EnvStack
+	stack    rc.EnvStack
+	exported map[string]bool
+	hook     EnvHookFunc
 }
 
 func NewEnv() *Env {
@@ -162,7 +485,50 @@ func (env *Env) Getenv(name string) string {
 }
 
 func (env *Env) Setenv(name, value string) {
-	env.stack.Set(name, []string{value})
+	env.set(name, []string{value})
+}
+
+// snapshot returns a deep copy of env.
+func (env *Env) snapshot() *Env {
+	snap := &Env{stack: env.stack.Clone()}
+	for name := range env.exported {
+		snap.Export(name)
+	}
+	return snap
+}
+
+// Visible returns an EnvMap containing all variables as they are
+// visible from the top of the environment stack.
+func (env *Env) Visible() rc.EnvMap {
+	m := make(rc.EnvMap, 16)
+	for _, layer := range env.stack {
+		m.Insert(layer)
+	}
+	return m
+}
+
+// Export marks a variable for inclusion into the list returned by ToSlice.
+func (env *Env) Export(name string) {
+	if env.exported == nil {
+		env.exported = make(map[string]bool, 8)
+	}
+	env.exported[name] = true
+}
+
+// ToSlice returns the exported variables in the "key=value" form
+// used by os/exec.Cmd.Env, sorted by name. Elements of list values
+// are separated by spaces.
+func (env *Env) ToSlice() []string {
+	names := make([]string, 0, len(env.exported))
+	for name := range env.exported {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := make([]string, 0, len(names))
+	for _, name := range names {
+		list = append(list, name+"="+strings.Join(env.stack.Get(name), " "))
+	}
+	return list
 }
 
 type CmdHookFunc func(Context)
@@ -187,11 +553,12 @@ func NewCmdInterp(s text.Scanner, m CmdMap, opts ...Option) (cl *CmdLine) {
 	cl.cmdMap = m
 	cl.builtin = CmdMap{
 		".": {
-			Arg: []string{"FILE"},
+			fgOnly: true,
+			Arg:    []string{"FILE"},
 			Fn: func(ctx Context, arg []string) (err error) {
 				f, err := cl.Open(arg[1])
 				if err == nil {
-					cl.pushStack(f, nil, nil, extractWriter(ctx))
+					err = cl.pushStack(f, nil, nil, extractWriter(ctx))
 				}
 				return
 			},
@@ -213,8 +580,14 @@ func NewCmdInterp(s text.Scanner, m CmdMap, opts ...Option) (cl *CmdLine) {
 			Help: "Print arguments.",
 		},
 		"cat": {
-			Arg: []string{"FILE"},
+			Opt: []string{"FILE"},
 			Fn: func(w Context, arg []string) (err error) {
+				if len(arg) == 1 {
+					if ic, ok := w.(InputContext); ok {
+						_, err = io.Copy(w, ic.Stdin())
+					}
+					return err
+				}
 				f, err := cl.Open(arg[1])
 				if err != nil {
 					return err
@@ -223,9 +596,10 @@ func NewCmdInterp(s text.Scanner, m CmdMap, opts ...Option) (cl *CmdLine) {
 				f.Close()
 				return err
 			},
-			Help: "Print the contents of FILE.",
+			Help: "Print the contents of FILE, or of the command's input.",
 		},
 		"if": {
+			fgOnly:     true,
 			isCompound: true,
 			Arg:        []string{"CMD", "..."},
 			Fn: func(ctx Context, arg []string) (err error) {
@@ -240,18 +614,21 @@ func NewCmdInterp(s text.Scanner, m CmdMap, opts ...Option) (cl *CmdLine) {
 						return
 					}
 					if !*cl.cur.cond.result {
-						cl.pushStringStack(cmd, w)
+						err = cl.pushStringStack(cmd, w)
 					}
 					return
 				}
 				cond := rc.JoinCmd(arg[1:len(arg)-1]) + "\n" + "_testcond\n"
-				cl.pushStringStack(cond, w)
+				if err = cl.pushStringStack(cond, w); err != nil {
+					return
+				}
 				cl.cur.cond.cmd = cmd
 				cl.cur.isCompound = true
 				return nil
 			},
 		},
 		"_testcond": {
+			fgOnly: true,
 			Hidden: true,
 			Fn: func(ctx Context, _ []string) (err error) {
 				cond := &cl.cur.cond
@@ -263,12 +640,24 @@ func NewCmdInterp(s text.Scanner, m CmdMap, opts ...Option) (cl *CmdLine) {
 				ok := cl.lastOk
 				cl.inputStack[len(cl.inputStack)-1].cond.result = &ok
 				if ok {
-					cl.pushStringStack(cmd, extractWriter(ctx))
+					return cl.pushStringStack(cmd, extractWriter(ctx))
 				}
 				return nil
 			},
 		},
+		"eval": {
+			fgOnly:    true,
+			ignoreEnv: true,
+			Opt:       []string{"ARG", "..."},
+			Fn: func(ctx Context, arg []string) error {
+				return cl.pushStringStack(strings.Join(arg[1:], " ")+"\n", extractWriter(ctx))
+			},
+			Help: `Join the arguments using spaces, and execute the result
+as if it had been entered as input; variable references
+are expanded again. The result may consist of multiple lines.`,
+		},
 		"!": {
+			fgOnly:      true,
 			isCompound:  true,
 			HideFailure: true,
 			Opt:         []string{"CMD", "..."},
@@ -278,12 +667,15 @@ func NewCmdInterp(s text.Scanner, m CmdMap, opts ...Option) (cl *CmdLine) {
 				}
 				cmd := rc.JoinCmd(arg[1:]) + "\n" + "_!\n"
 				cplx := cl.cur.isCompound
-				cl.pushStringStack(cmd, extractWriter(ctx))
+				if err = cl.pushStringStack(cmd, extractWriter(ctx)); err != nil {
+					return
+				}
 				cl.cur.isCompound = cplx
 				return nil
 			},
 		},
 		"_!": {
+			fgOnly:      true,
 			Hidden:      true,
 			HideFailure: true,
 			Fn: func(Context, []string) (err error) {
@@ -311,9 +703,106 @@ func NewCmdInterp(s text.Scanner, m CmdMap, opts ...Option) (cl *CmdLine) {
 			},
 			Help: `Returns success if subject matches any pattern.`,
 		},
+		"~~": {
+			fgOnly: true,
+			Arg:    []string{"SUBJECT", "REGEX", "..."},
+			Fn:     cl.regexMatchCmd,
+			Help:   regexMatchHelp,
+		},
 
+		"test": {
+			HideFailure: true,
+			Opt:         []string{"EXPR", "..."},
+			Fn:          cl.testCmd,
+			Help:        testHelp,
+		},
+		"[": {
+			Hidden:      true,
+			HideFailure: true,
+			Opt:         []string{"EXPR", "..."},
+			Fn:          cl.testCmd,
+			Help:        testHelp,
+		},
+
+		"let": {
+			Arg:  []string{"NAME", "EXPR", "..."},
+			Fn:   cl.letCmd,
+			Help: letHelp,
+		},
+		"kv": {
+			fgOnly: true,
+			Opt:    []string{"OP", "KEY", "VALUE", "..."},
+			Fn:     cl.kvCmd,
+			Help:   kvHelp,
+		},
+		"tail": {
+			Arg:       []string{"FILE"},
+			Flags:     "[-n N] [-f]",
+			InitFlags: initTailFlags,
+			Fn:        cl.tailCmd,
+			Help:      tailHelp,
+		},
+		"rand": {
+			Arg:  []string{"KIND"},
+			Opt:  []string{"ARG", "..."},
+			Fn:   randCmd,
+			Help: randHelp,
+		},
+		"json-set": {
+			fgOnly: true,
+			Arg:    []string{"KEY", "JSON"},
+			Fn:     cl.jsonSetCmd,
+			Help:   jsonSetHelp,
+		},
+		"json-get": {
+			fgOnly: true,
+			Arg:    []string{"KEY"},
+			Fn:     cl.jsonGetCmd,
+			Help:   jsonGetHelp,
+		},
+		"diff": {
+			Arg:  []string{"FILE1", "FILE2"},
+			Fn:   cl.diffCmd,
+			Help: "Compare two files line by line, and print the differences.\nReturns failure if the files differ.",
+		},
+
+		"methodchain": {
+			Arg: []string{"EXPR"},
+			Opt: []string{"SEP"},
+			Fn: func(w Context, arg []string) error {
+				sep := ", "
+				if len(arg) == 3 {
+					sep = arg[2]
+				}
+				s, err := stringutil.ConvertMethodChain(arg[1], sep)
+				if err != nil {
+					return err
+				}
+				_, err = w.Println(s)
+				return err
+			},
+			Help: `Convert (x).method(y) expressions in EXPR into method(x, y),
+and print the result. SEP separates the arguments (default: ", ").`,
+		},
+		"whatis": {
+			Arg:  []string{"NAME", "..."},
+			Fn:   cl.whatisCmd,
+			Help: "Print the usage and a short description of the commands.",
+		},
+		"which": {
+			Arg:  []string{"PATTERN", "..."},
+			Fn:   cl.whichCmd,
+			Help: "List the commands, including functions and builtins,\nwhose names match PATTERN.",
+		},
+		"verbose": {
+			fgOnly: true,
+			Opt:    []string{"LEVEL"},
+			Fn:     cl.verboseCmd,
+			Help:   verboseHelp,
+		},
 		"flag": {
-			Arg: []string{"f", "+-"},
+			fgOnly: true,
+			Arg:    []string{"f", "+-"},
 			Fn: func(ctx Context, arg []string) (err error) {
 				f := arg[1]
 				v := arg[2] == "+"
@@ -331,6 +820,7 @@ func NewCmdInterp(s text.Scanner, m CmdMap, opts ...Option) (cl *CmdLine) {
 	e	exit if a simple command (not part of an if-condition) fails`,
 		},
 		"fn": {
+			fgOnly:     true,
 			isCompound: true,
 			Opt:        []string{"NAME", "CMD", "..."},
 			Fn: func(w Context, arg []string) error {
@@ -353,8 +843,104 @@ a single command, or a block enclosed in '{' and '}':
 		cmdc
 	}`,
 		},
+		"group": {
+			fgOnly:     true,
+			isCompound: true,
+			Arg:        []string{"TITLE", "CMD"},
+			Opt:        []string{"ARG", "..."},
+			Fn: func(ctx Context, arg []string) error {
+				cmd, err := cl.ParseCmd(arg[2:])
+				if err != nil {
+					return err
+				}
+				title := arg[1]
+				w := extractWriter(ctx)
+				err = cl.pushStringStack(cmd, cl.newWriter(gioutil.NewIndentWriter(w, []byte{'\t'})))
+				if err != nil {
+					return err
+				}
+				w.Printf("== %s", title)
+				cl.cur.onPop = func() {
+					w.Printf("== end %s", title)
+				}
+				return nil
+			},
+			Help: `Run CMD, which may be a block enclosed in '{' and '}',
+printing a header containing TITLE before, and a footer after its
+output, which will be indented.`,
+		},
+		"trap": {
+			fgOnly: true,
+			Opt:    []string{"CMD", "..."},
+			Fn:     cl.trapCmd,
+			Help: `Register CMD to be run when the condition specified by the
+last argument, SIG, occurs. CMD may be a block enclosed in '{' and '}':
+	trap {
+		cmda
+	} EXIT
+SIG may be one of
+	INT	an interrupt (the default, if SIG is omitted)
+	EXIT	termination of the command line processor
+A last argument consisting of upper-case letters is regarded
+as SIG; an unknown SIG results in an error.
+A single CMD argument may contain a whole command line, as in
+	trap 'rm tmpfile'
+A trap is removed once it has been run. Without arguments, the
+registered traps are listed; if only SIG is specified, the trap
+will be removed.`,
+		},
+		"set": {
+			fgOnly: true,
+			Opt:    []string{"NAME", "VALUE", "..."},
+			Fn: func(ctx Context, arg []string) error {
+				if len(arg) > 1 {
+					cl.env.set(arg[1], append([]string(nil), arg[2:]...))
+					return nil
+				}
+				m := cl.env.Visible()
+				names := make([]string, 0, len(m))
+				for name := range m {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				w := extractWriter(ctx)
+				for _, name := range names {
+					_, err := w.Println(rc.EnvMap{name: m[name]})
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			Help: `Assign the list of VALUEs to variable NAME in the current scope.
+Without arguments, print all variables visible in the current scope.`,
+		},
+		"export": {
+			fgOnly: true,
+			Opt:    []string{"NAME", "..."},
+			Fn: func(ctx Context, arg []string) error {
+				if len(arg) == 1 {
+					_, err := extractWriter(ctx).PrintSlice(cl.env.ToSlice())
+					return err
+				}
+				for _, name := range arg[1:] {
+					cl.env.Export(name)
+				}
+				return nil
+			},
+			Help: `Mark variables for inclusion into the environment of child
+processes, see Env.ToSlice. Without arguments, print exported variables.`,
+		},
+		"local": {
+			fgOnly:    true,
+			ignoreEnv: true,
+			Arg:       []string{"NAME[=VALUE]", "..."},
+			Fn:        cl.localCmd,
+			Help:      localHelp,
+		},
 		"shift": {
-			Opt: []string{"N"},
+			fgOnly: true,
+			Opt:    []string{"N"},
 			Fn: func(_ Context, arg []string) error {
 				i := 1
 				if len(arg) == 2 {
@@ -368,13 +954,14 @@ a single command, or a block enclosed in '{' and '}':
 				if i > len(args) {
 					i = len(args)
 				}
-				cl.env.stack.Set("*", args[i:])
+				cl.env.set("*", args[i:])
 				return nil
 			},
 			Help: "Delete the first n (default: 1) elements of $*",
 		},
 		"unbind": {
-			Arg: []string{"NAME"},
+			fgOnly: true,
+			Arg:    []string{"NAME"},
 			Fn: func(_ Context, arg []string) (err error) {
 				if _, ok := cl.funcMap[arg[1]]; !ok {
 					err = errors.New("function not found")
@@ -386,14 +973,25 @@ a single command, or a block enclosed in '{' and '}':
 			Help: "Unbind a function.",
 		},
 		"repeat": {
-			Arg: []string{"{N|T}", "CMD"},
-			Opt: []string{"ARG", "..."},
+			fgOnly: true,
+			Arg:    []string{"{N|T}", "CMD"},
+			Opt:    []string{"ARG", "..."},
 			Fn: func(ctx Context, arg []string) error {
 				return cl.repeatCmd(extractWriter(ctx), arg[1:])
 			},
 			Help: "Repeat a command N times, or for a specified duration T.",
 		},
+		"poll-until-stable": {
+			fgOnly:    true,
+			Arg:       []string{"CMD"},
+			Opt:       []string{"ARG", "..."},
+			Flags:     "[-c] [-d DURATION]",
+			InitFlags: initPollFlags,
+			Fn:        cl.pollCmd,
+			Help:      pollHelp,
+		},
 		"return": {
+			fgOnly: true,
 			Fn: func(_ Context, _ []string) error {
 				return cl.returnFromFunc()
 			},
@@ -401,6 +999,7 @@ a single command, or a block enclosed in '{' and '}':
 			Help:       "Return from the current function.",
 		},
 		"break": {
+			fgOnly: true,
 			Fn: func(_ Context, _ []string) error {
 				return cl.breakLoop()
 			},
@@ -433,13 +1032,80 @@ a single command, or a block enclosed in '{' and '}':
 			Help: "Sleep for the specified duration.",
 		},
 		"exit": {
-			Fn: func(Context, []string) error {
+			fgOnly: true,
+			Opt:    []string{"N"},
+			Fn: func(_ Context, arg []string) error {
+				status := 0
+				if len(arg) == 2 {
+					u, err := strconv.ParseUint(arg[1], 10, 8)
+					if err != nil {
+						return err
+					}
+					status = int(u)
+				} else if !cl.lastOk {
+					status = 1
+				}
 				cl.exitFlag = true
+				cl.exitStatus = status
+				return nil
+			},
+			Help: `Terminate the command line processor. If N is specified,
+it will be reported by ExitStatus; otherwise the status depends
+on whether the last command succeeded.`,
+		},
+		"jobs": {
+			fgOnly: true,
+			Fn: func(ctx Context, _ []string) error {
+				cl.jobs.print(extractWriter(ctx))
 				return nil
 			},
-			Help: "Terminate the command line processor.",
+			Help: "List jobs started in background using '&'.",
+		},
+		"wait": {
+			fgOnly: true,
+			Opt:    []string{"N"},
+			Fn: func(ctx Context, arg []string) error {
+				id := 0
+				if len(arg) == 2 {
+					u, err := strconv.ParseUint(arg[1], 10, 0)
+					if err != nil {
+						return err
+					}
+					id = int(u)
+				}
+				return cl.jobs.wait(ctx, id)
+			},
+			Help: "Wait until job N, or all background jobs, have terminated.",
+		},
+		"uptime": {
+			Fn: func(ctx Context, _ []string) error {
+				d := timeNow().Sub(cl.StartTime())
+				_, err := ctx.Println(d.Round(time.Second))
+				return err
+			},
+			Help: "Print how long the session has been running.",
+		},
+		"kill": {
+			fgOnly: true,
+			Arg:    []string{"N"},
+			Fn: func(ctx Context, arg []string) error {
+				u, err := strconv.ParseUint(arg[1], 10, 0)
+				if err != nil {
+					return err
+				}
+				return cl.jobs.kill(int(u))
+			},
+			Help: "Interrupt background job N by cancelling its context.",
 		},
 	}
+	for _, name := range []string{
+		".", "echo", "if", "_testcond", "eval", "!", "_!", "~",
+		"test", "[", "let", "fn", "unbind", "group",
+		"set", "local", "shift", "flag", "verbose", "return", "break", "false",
+		"which", "whatis", "~~", "rand",
+	} {
+		cl.builtin[name].dryRunSafe = true
+	}
 	if _, ok := m["builtin"]; !ok {
 		m["builtin"] = &Cmd{
 			Map:  cl.builtin,
@@ -452,6 +1118,7 @@ a single command, or a block enclosed in '{' and '}':
 	cl.Open = func(filename string) (io.ReadCloser, error) {
 		return os.Open(filename)
 	}
+	cl.Stat = os.Stat
 	cl.OpenRedirFile = func(name string, flag int, perm os.FileMode) (RedirFile, error) {
 		return os.OpenFile(name, flag, perm)
 	}
@@ -466,10 +1133,13 @@ a single command, or a block enclosed in '{' and '}':
 		fmt.Fprintf(cl.Stdout, "%% %v\n", cmd)
 	}
 	cl.handleError = func(err error) {
-		fmt.Fprintln(cl.errOut, err)
+		fmt.Fprintln(cl.errWriter(), err)
 	}
 	cl.cIntr = make(chan struct{})
 	cl.tok = new(rc.Tokenizer)
+	cl.tok.CmdPrefixes = []string{"if", "not", "!"}
+	cl.maxStackDepth = DefaultMaxStackDepth
+	cl.verbosity = VerbosityNormal
 
 	for _, option := range opts {
 		option(cl)
@@ -477,6 +1147,11 @@ a single command, or a block enclosed in '{' and '}':
 	if cl.env == nil {
 		cl.env = NewEnv()
 	}
+	if cl.envHook != nil {
+		cl.env.hook = cl.envHook
+	}
+	cl.lockOutput()
+	cl.cmdLineReader.stripBOM = cl.stripBOM
 	cl.tok.Getenv = func(key string) []string {
 		return cl.env.stack.Get(key)
 	}
@@ -488,12 +1163,67 @@ func extractWriter(ctx Context) text.Writer {
 	return ctx.(*icontext).Writer
 }
 
+// flagValue returns the value of a flag of a built-in command
+// defined using InitFlags, as parsed for the current invocation.
+func flagValue(ctx Context, name string) interface{} {
+	return ctx.(*icontext).flags.Lookup(name).Value.(flag.Getter).Get()
+}
+
 func (cl *CmdLine) cleanup() {
+	cl.jobs.cancelAll()
 	for _, file := range cl.redirFileMap {
 		file.Close()
 	}
 }
 
+func (cl *CmdLine) errWriter() io.Writer {
+	if cl.cmdErrOut != nil {
+		return cl.cmdErrOut
+	}
+	return cl.errOut
+}
+
+// applyRedirections processes the redirections of a command line
+// from left to right, and returns the resulting writers for normal
+// and error output.
+func (cl *CmdLine) applyRedirections(redir []rc.Redirection, w text.Writer) (text.Writer, io.Writer, error) {
+	ew := cl.errOut
+	for _, r := range redir {
+		var target io.Writer
+		if r.Type == ">&" {
+			switch r.Filename {
+			case "1":
+				target = w
+			case "2":
+				target = ew
+			default:
+				return nil, nil, errBadFd
+			}
+		} else {
+			f, err := cl.redirect(r.Type, r.Filename)
+			if err != nil {
+				return nil, nil, err
+			}
+			target = f
+		}
+		switch r.Fd {
+		case 1:
+			if tw, ok := target.(text.Writer); ok {
+				w = tw
+			} else {
+				w = cl.newWriter(target)
+			}
+		case 2:
+			ew = target
+		default:
+			return nil, nil, errBadFd
+		}
+	}
+	return w, ew, nil
+}
+
+var errBadFd = errors.New("redirection: bad file descriptor")
+
 func (cl *CmdLine) redirect(op string, filename string) (text.Writer, error) {
 	var err error
 
@@ -518,6 +1248,9 @@ func (cl *CmdLine) redirect(op string, filename string) (text.Writer, error) {
 	default:
 		return nil, errors.New("redirection type not supported")
 	}
+	if cl.dryRun {
+		return cl.newWriter(ioutil.Discard), nil
+	}
 	file, err = cl.OpenRedirFile(filename, owflags, 0644)
 	if err != nil {
 		return nil, err
@@ -546,11 +1279,17 @@ type stackEntry struct {
 	rewind     func() io.ReadCloser
 
 	w          text.Writer
+	onPop      func()
 	popEnv     bool
 	savedArgs  []string
 	isFunc     bool
 	isCompound bool
-	cond       struct {
+
+	// locals holds the previous values of variables
+	// declared local within a function
+	locals map[string]savedVar
+
+	cond struct {
 		cmd    string
 		result *bool
 	}
@@ -560,10 +1299,17 @@ func (stk *stackEntry) isLoop() bool {
 	return stk.repetition != nil
 }
 
-func (cl *CmdLine) pushStack(rc io.ReadCloser, rpt *repetition, rewind func() io.ReadCloser, w text.Writer) {
+// pushStack makes rc the current input. If the maximum depth of the
+// input stack has been reached, rc is closed, and ErrStackOverflow
+// is returned.
+func (cl *CmdLine) pushStack(rc io.ReadCloser, rpt *repetition, rewind func() io.ReadCloser, w text.Writer) error {
+	if len(cl.inputStack) >= cl.maxStackDepth {
+		rc.Close()
+		return ErrStackOverflow
+	}
 	cl.inputStack = append(cl.inputStack, cl.cur)
 	cl.cur = stackEntry{
-		lineReader: newCmdLineReader(bufio.NewScanner(rc), rc),
+		lineReader: cl.newLineReader(rc),
 		repetition: rpt,
 		rewind:     rewind,
 		w:          w,
@@ -573,18 +1319,25 @@ func (cl *CmdLine) pushStack(rc io.ReadCloser, rpt *repetition, rewind func() io
 		cl.savedPrompt = cl.Prompt
 		cl.Prompt = ""
 	}
+	return nil
 }
 
-func (cl *CmdLine) pushStringStack(cmds string, w text.Writer) {
-	cl.pushStack(ioutil.NopCloser(strings.NewReader(cmds)), nil, nil, w)
+func (cl *CmdLine) pushStringStack(cmds string, w text.Writer) error {
+	return cl.pushStack(ioutil.NopCloser(strings.NewReader(cmds)), nil, nil, w)
 }
 
 func (cl *CmdLine) popStack() {
+	if f := cl.cur.onPop; f != nil {
+		f()
+	}
+	if l := cl.cur.locals; l != nil {
+		cl.restoreLocals(l)
+	}
 	if cl.cur.popEnv {
-		cl.env.stack.Pop()
+		cl.env.pop()
 	}
 	if a := cl.cur.savedArgs; a != nil {
-		cl.env.stack.Set("*", a)
+		cl.env.set("*", a)
 	}
 	sz := len(cl.inputStack)
 	sz--
@@ -637,10 +1390,17 @@ func (cl *CmdLine) returnFromFunc() error {
 }
 
 var ErrInterrupt = errors.New("interrupted")
+var ErrTimeout = errors.New("timeout")
 var ErrLastCmdFailed = errors.New("last command failed")
 
 var ErrWrongNArg = errors.New("wrong number of arguments")
 var ErrNotFound = errors.New("no such command")
+var ErrStackOverflow = errors.New("maximum input stack depth exceeded")
+
+// DefaultMaxStackDepth is the default limit of the depth of the
+// input stack, which grows with each nested function call, block,
+// or file read using the `.' command.
+const DefaultMaxStackDepth = 256
 
 type FnError struct {
 	Fn  string
@@ -672,25 +1432,94 @@ func (cl *CmdLine) setFnError(fnName string, err error) {
 	}
 }
 
-func (cl *CmdLine) Process() error {
-	var line string
-
+func (cl *CmdLine) Process() (err error) {
 	cl.tplMap = newTemplateMap(16)
 	cl.cur.w = cl.newWriter(cl.Stdout)
-	ready := make(chan bool)
 
+	if cl.transaction {
+		restore := cl.saveState()
+		defer func() {
+			if err != nil {
+				restore()
+			}
+		}()
+	}
 	defer cl.cleanup()
+	defer cl.runTrap("EXIT", cl.cur.w)
+
+	if cl.banner != "" && cl.Prompt != "" {
+		cl.writeBanner()
+	}
 
 	if cl.InitRc != nil {
-		cl.pushStack(cl.InitRc, nil, nil, cl.cur.w)
+		if err := cl.pushStack(cl.InitRc, nil, nil, cl.cur.w); err != nil {
+			return err
+		}
 	}
+	return cl.run()
+}
+
+// RunString executes the commands contained in script,
+// like RunReader.
+func (cl *CmdLine) RunString(script string) error {
+	return cl.RunReader(strings.NewReader(script))
+}
+
+// RunReader executes the commands read from r to completion, using
+// the state of the interpreter, like variables and functions, left
+// by previous calls or by Process. ErrLastCmdFailed is returned if
+// the last command has failed, or, if flag e is set, as soon as
+// a command fails. If the `exit' command is called, the remaining
+// commands are skipped, and ExitStatus reports the status.
+// RunReader must not be called while Process is running.
+func (cl *CmdLine) RunReader(r io.Reader) error {
+	if cl.tplMap == nil {
+		cl.tplMap = newTemplateMap(16)
+	}
+	if cl.cur.w == nil {
+		cl.cur.w = cl.newWriter(cl.Stdout)
+	}
+	cur, stk := cl.cur, cl.inputStack
+	prompt := cl.Prompt
+	cl.cur = stackEntry{
+		lineReader: cl.newLineReader(ioutil.NopCloser(r)),
+		w:          cur.w,
+	}
+	cl.cmdLineReader = cl.cur.lineReader
+	cl.inputStack = nil
+	cl.Prompt = ""
+	cl.exitFlag = false
+
+	err := cl.run()
+	cl.popStackAll()
+
+	cl.cur, cl.inputStack = cur, stk
+	cl.cmdLineReader = cl.cur.lineReader
+	cl.Prompt = prompt
+	return err
+}
+
+// run reads and executes commands from the current input
+// until the end of the top-level input is reached,
+// or the `exit' command has been called.
+func (cl *CmdLine) run() error {
+	var line string
+
+	ready := make(chan bool)
 
 	var ictx *icontext
+	defer func() {
+		if ictx != nil {
+			ictx.cancel()
+		}
+		cl.cmdErrOut = nil
+	}()
 	for {
+		cl.cmdErrOut = nil
 		if cl.exitFlag {
 			break
 		}
-		cl.WritePrompt(cl.Prompt)
+		cl.writePrompt()
 		go func() {
 			ready <- cl.Scan()
 		}()
@@ -700,22 +1529,33 @@ func (cl *CmdLine) Process() error {
 			ctx := context.Background()
 			ctx, cancel := context.WithCancel(ctx)
 			go func() {
-				<-cl.cIntr
-				cancel()
+				for {
+					select {
+					case <-cl.cIntr:
+						if cl.jobs.interrupt() {
+							continue
+						}
+						cancel()
+					case <-ctx.Done():
+					}
+					return
+				}
 			}()
 			ictx = new(icontext)
 			ictx.Context = ctx
+			ictx.cancel = cancel
 			ictx.getenv = cl.env.Getenv
 		}
 		select {
 		case <-ictx.Done():
 			ictx = nil
+			cl.runTrap("INT", cl.cur.w)
 			if len(cl.inputStack) == 0 {
 				return ErrInterrupt
 			} else {
 				cl.setError(ErrInterrupt)
 				cl.popStackAll()
-				cl.WritePrompt(cl.Prompt)
+				cl.writePrompt()
 				goto selAgain
 			}
 		default:
@@ -723,12 +1563,13 @@ func (cl *CmdLine) Process() error {
 		select {
 		case <-ictx.Done():
 			ictx = nil
+			cl.runTrap("INT", cl.cur.w)
 			if len(cl.inputStack) == 0 {
 				return ErrInterrupt
 			} else {
 				cl.setError(ErrInterrupt)
 				cl.popStackAll()
-				cl.WritePrompt(cl.Prompt)
+				cl.writePrompt()
 				goto selAgain
 			}
 		case scanOk = <-ready:
@@ -740,7 +1581,7 @@ func (cl *CmdLine) Process() error {
 				if sz := len(cl.inputStack); sz != 0 {
 					if !cl.cur.repetition.done() {
 						rc := cl.cur.rewind()
-						cl.cur.lineReader = newCmdLineReader(bufio.NewScanner(rc), rc)
+						cl.cur.lineReader = cl.newLineReader(rc)
 						cl.cmdLineReader = cl.cur.lineReader
 						continue
 					}
@@ -754,10 +1595,10 @@ func (cl *CmdLine) Process() error {
 			return err
 		}
 		line = cl.Text()
-		if cl.Prompt != "" {
+		if prompt := cl.shownPrompt; prompt != "" && cl.Prompt != "" {
 		again:
-			if strings.HasPrefix(line, cl.Prompt) {
-				line = line[len(cl.Prompt):]
+			if strings.HasPrefix(line, prompt) {
+				line = line[len(prompt):]
 				goto again
 			}
 		}
@@ -767,20 +1608,33 @@ func (cl *CmdLine) Process() error {
 			cl.setFnError("", err)
 			continue
 		}
-		if c.Redir.Type != "" {
-			w, err = cl.redirect(c.Redir.Type, c.Redir.Filename)
+		var stdin io.Reader
+		if h := c.HereDoc; h != nil {
+			doc, err := cl.readHereDoc(h)
 			if err != nil {
 				cl.setFnError("", err)
 				continue
 			}
+			stdin = strings.NewReader(doc)
+		}
+		ew := cl.errOut
+		if len(c.Redir) != 0 {
+			w, ew, err = cl.applyRedirections(c.Redir, w)
+			if err != nil {
+				cl.setFnError("", err)
+				continue
+			}
+			if ew != cl.errOut {
+				cl.cmdErrOut = ew
+			}
 		}
 		args := c.Fields
 		if len(args) == 0 {
 			if a := c.Assignments; len(a) != 0 {
-				if cl.flags.x {
+				if cl.tracing() {
 					cl.printCmd(c)
 				}
-				cl.env.stack.Insert(a)
+				cl.env.insert(a)
 				continue
 			}
 			if cl.Forward != nil {
@@ -795,23 +1649,37 @@ func (cl *CmdLine) Process() error {
 
 		name := args[0]
 		if body, ok := cl.funcMap[name]; ok {
-			if privEnv {
-				cl.env.stack.Push(c.Assignments)
+			if c.Background {
+				cl.setFnError(name, ErrNoBackground)
+				continue
+			}
+			if err := cl.pushStringStack(body, w); err != nil {
+				cl.setFnError(name, err)
+				cl.popStackAll()
+				continue
 			}
-			cl.pushStringStack(body, w)
 			if privEnv {
+				cl.env.push(c.Assignments)
 				cl.cur.popEnv = true
 			} else {
-				cl.cur.savedArgs = cl.env.stack.Get("*")
+				// Save a copy, which is non-nil even if $* is empty,
+				// so that it will be restored by popStack.
+				cl.cur.savedArgs = append([]string{}, cl.env.stack.Get("*")...)
 			}
-			cl.env.stack.Set("*", args[1:])
+			cl.env.set("*", args[1:])
 			cl.cur.isFunc = true
-			if cl.flags.x {
+			if cl.tracing() {
 				cl.printCmd(c)
 			}
 			continue
 		}
 		if name == "help" {
+			if len(args) == 2 && args[1] == "-json" {
+				if err := cl.HelpJSON(cl.Stdout); err != nil {
+					cl.setFnError(name, err)
+				}
+				continue
+			}
 			cl.help(cl.Stdout, args[1:])
 			if cl.Forward != nil {
 				cl.fwd([]byte("help\n"))
@@ -819,26 +1687,8 @@ func (cl *CmdLine) Process() error {
 			continue
 		}
 
-		m := cl.cmdMap
-		isRoot := true
-		cmdName := name
-
-	retry:
-		cmd, ok := m[cmdName]
-		if !ok && isRoot {
-			cmd, ok = cl.builtin[cmdName]
-		}
+		cmd, ok := cl.lookupCmd(name)
 		if !ok {
-			if iDot := strings.Index(cmdName, "."); iDot != -1 {
-				if cmd, ok = m[cmdName[:iDot]]; ok {
-					m = cmd.Map
-					if m != nil {
-						cmdName = cmdName[iDot+1:]
-						isRoot = false
-						goto retry
-					}
-				}
-			}
 			if cl.Forward != nil {
 				cl.fwd([]byte(rc.JoinCmd(args) + "\n"))
 			} else {
@@ -852,48 +1702,95 @@ func (cl *CmdLine) Process() error {
 				continue
 			}
 		}
+		var flags *flag.FlagSet
 		if cmd.InitFlags != nil {
-			f := flag.NewFlagSet("", flag.ExitOnError)
-			cmd.InitFlags(f)
-			f.Parse(args[1:])
-			args = append(args[:1], f.Args()...)
+			flags = flag.NewFlagSet(name, flag.ContinueOnError)
+			flags.SetOutput(ioutil.Discard)
+			cmd.InitFlags(flags)
+			if err := flags.Parse(args[1:]); err != nil {
+				cl.setFnError(name, err)
+				continue
+			}
+			args = append(args[:1], flags.Args()...)
 		}
 		n := len(args) - 1
 
-		nmin := 0
-		narg := len(cmd.Arg)
-		nopt := len(cmd.Opt)
-		if narg > 0 && cmd.Arg[narg-1] == "..." {
-			nmin = narg - 1
-			goto checkNMin
-		}
-		if nopt > 1 && cmd.Opt[nopt-1] == "..." {
-			nmin = narg
-			goto checkNMin
-		}
-		nmin = narg
-		if n > narg+nopt {
+		if !argCountOk(cmd, n) {
 			cl.setFnError(name, ErrWrongNArg)
 			continue
 		}
-	checkNMin:
-		if n < nmin {
-			cl.setFnError(name, ErrWrongNArg)
+		if c.Background && cmd.fgOnly {
+			cl.setFnError(name, ErrNoBackground)
 			continue
 		}
 		if privEnv {
 			if !cmd.ignoreEnv {
-				cl.env.stack.Push(c.Assignments)
+				cl.env.push(c.Assignments)
+			}
+		}
+		if c.Background {
+			if cl.tracing() && !cmd.Hidden {
+				cl.printCmd(c)
+			}
+			if !cl.dryRun {
+				cl.startJob(cmd, c, args, flags, w, ew, stdin)
+			}
+			if privEnv {
+				cl.env.pop()
 			}
+			cl.lastOk = true
+			cl.cur.cond.result = nil
+			continue
+		}
+		if cl.dryRun && !cmd.dryRunSafe {
+			if !cmd.Hidden {
+				cl.printCmd(c)
+			}
+			if privEnv {
+				cl.env.pop()
+			}
+			cl.lastOk = true
+			cl.cur.cond.result = nil
+			continue
+		}
+		var lb *lineBuffer
+		if tw, ok := w.(*writer); ok && !cmd.fgOnly {
+			ictx.Writer, lb = cl.bufferLines(tw)
+		} else {
+			ictx.Writer = w
+		}
+		ictx.stdin = stdin
+		ictx.stderr = ew
+		ictx.flags = flags
+		fnCtx := ictx
+		timeout := cmd.Timeout
+		if timeout == 0 {
+			timeout = cl.defaultTimeout
+		}
+		if timeout > 0 {
+			fnCtx = new(icontext)
+			*fnCtx = *ictx
+			fnCtx.Context, fnCtx.cancel = context.WithTimeout(ictx.Context, timeout)
 		}
-		ictx.Writer = w
 		if cl.cmdHook != nil {
-			cl.cmdHook(ictx)
+			cl.cmdHook(fnCtx)
 		}
-		if cl.flags.x && !cmd.Hidden && !cmd.isCompound {
+		if cl.tracing() && !cmd.Hidden && !cmd.isCompound {
 			cl.printCmd(c)
 		}
-		err = cmd.Fn(ictx, args)
+		cl.jobs.setForeground(true)
+		err = cmd.Fn(fnCtx, args)
+		cl.jobs.setForeground(false)
+		if lb != nil {
+			if ferr := lb.Flush(); err == nil {
+				err = ferr
+			}
+		}
+		timedOut := false
+		if fnCtx != ictx {
+			timedOut = fnCtx.Err() == context.DeadlineExceeded
+			fnCtx.cancel()
+		}
 		select {
 		case <-ictx.Done():
 			if err == nil {
@@ -901,20 +1798,28 @@ func (cl *CmdLine) Process() error {
 			}
 			ictx = nil
 		default:
+			// commands may report the end of their context
+			// like an interrupt, which is not the case here
+			if timedOut && (err == nil || err == ErrInterrupt || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+				err = ErrTimeout
+			}
 		}
 		if !cmd.weakStatus {
 			cl.lastOk = err == nil
 		}
 		cl.cur.cond.result = nil
-		if cmd.HideFailure {
+		if cmd.HideFailure || err == errFalse {
 			err = nil
 		}
 		if privEnv {
-			cl.env.stack.Pop()
+			cl.env.pop()
 		}
 		if err != nil {
 			if errors.Is(err, context.Canceled) || err == ErrInterrupt {
 				err = ErrInterrupt
+				cl.runTrap("INT", w)
+				cl.popStackAll()
+			} else if err == ErrTimeout || err == ErrStackOverflow {
 				cl.popStackAll()
 			}
 			cl.setFnError(name, err)
@@ -928,6 +1833,66 @@ func (cl *CmdLine) Process() error {
 	return nil
 }
 
+// argCountOk reports whether n arguments match
+// the Arg and Opt specification of cmd.
+func argCountOk(cmd *Cmd, n int) bool {
+	narg := len(cmd.Arg)
+	nopt := len(cmd.Opt)
+	if narg > 0 && cmd.Arg[narg-1] == "..." {
+		return n >= narg-1
+	}
+	if nopt > 1 && cmd.Opt[nopt-1] == "..." {
+		return n >= narg
+	}
+	return n >= narg && n <= narg+nopt
+}
+
+// tracing reports whether commands shall be printed before they
+// are executed, as requested by flag x, the verbosity level,
+// or by dry-run mode.
+func (cl *CmdLine) tracing() bool {
+	return cl.flags.x || cl.verbosity >= VerbosityTrace || cl.dryRun
+}
+
+// osExit may be replaced by tests.
+var osExit = os.Exit
+
+// timeNow may be replaced by tests.
+var timeNow = time.Now
+
+// StartTime returns the time Process has been started.
+func (cl *CmdLine) StartTime() time.Time {
+	if cl.tplMap == nil {
+		return time.Time{}
+	}
+	return cl.tplMap.t0
+}
+
+// ExitStatus maps the error returned by Process to an exit status
+// suitable for os.Exit: If the `exit' command has been called,
+// its status is returned. Otherwise, 0 is returned if err is nil,
+// and 1 in any other case, like ErrLastCmdFailed.
+func (cl *CmdLine) ExitStatus(err error) int {
+	if cl.exitFlag {
+		return cl.exitStatus
+	}
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+// ProcessAndExit runs Process, and terminates the program
+// with the exit status determined by ExitStatus. Errors other than
+// ErrLastCmdFailed are reported before.
+func (cl *CmdLine) ProcessAndExit() {
+	err := cl.Process()
+	if err != nil && err != ErrLastCmdFailed {
+		cl.setError(err)
+	}
+	osExit(cl.ExitStatus(err))
+}
+
 func (cl *CmdLine) fwd(line []byte) {
 	_, err := cl.Forward.Write(line)
 	if err != nil {
@@ -937,8 +1902,17 @@ func (cl *CmdLine) fwd(line []byte) {
 }
 
 func (cl *CmdLine) scanBlock() (block string, err error) {
+	block, _, err = cl.scanBlockTail(false)
+	return
+}
+
+// scanBlockTail reads the lines of a block up to a line
+// containing the closing '}'. If allowTail is true, the closing
+// line may contain additional text after the '}', separated
+// by white-space, which is returned as tail.
+func (cl *CmdLine) scanBlockTail(allowTail bool) (block, tail string, err error) {
 	for {
-		cl.WritePrompt("")
+		cl.writePrompt2()
 		if !cl.Scan() {
 			err = cl.Err()
 			if err == nil {
@@ -950,6 +1924,12 @@ func (cl *CmdLine) scanBlock() (block string, err error) {
 		if s == "}" {
 			break
 		}
+		if allowTail && strings.HasPrefix(s, "}") {
+			if t := strings.TrimLeftFunc(s[1:], unicode.IsSpace); len(t) < len(s)-1 {
+				tail = t
+				break
+			}
+		}
 		s = strings.TrimPrefix(s, "\t")
 		block += s + "\n"
 	}
@@ -989,14 +1969,18 @@ func (cl *CmdLine) ParseCmd(f []string) (cmd string, err error) {
 }
 
 type repetition struct {
-	n   int
-	end time.Time
+	n    int
+	end  time.Time
+	poll *pollState
 }
 
 func (r *repetition) done() bool {
 	if r == nil {
 		return true
 	}
+	if r.poll != nil {
+		return r.poll.done()
+	}
 	if r.n > 1 {
 		r.n--
 		return false
@@ -1034,11 +2018,79 @@ func (cl *CmdLine) repeatCmd(w text.Writer, arg []string) (err error) {
 		n:   int(i),
 		end: time.Now().Add(d),
 	}
-	cl.pushStack(rewind(), r, rewind, w)
+	err = cl.pushStack(rewind(), r, rewind, w)
 	return
 
 }
 
+// lookupCmd finds the command specified by name, which may be
+// a dotted path into nested command maps, like "cmd.subcmd".
+// Builtins are considered on the topmost level.
+func (cl *CmdLine) lookupCmd(name string) (*Cmd, bool) {
+	m := cl.cmdMap
+	isRoot := true
+	for {
+		cmd, ok := m[name]
+		if !ok && isRoot {
+			cmd, ok = cl.builtin[name]
+		}
+		if ok {
+			return cmd, true
+		}
+		iDot := strings.Index(name, ".")
+		if iDot == -1 {
+			return nil, false
+		}
+		if cmd, ok = m[name[:iDot]]; !ok || cmd.Map == nil {
+			return nil, false
+		}
+		m = cmd.Map
+		name = name[iDot+1:]
+		isRoot = false
+	}
+}
+
+// cmdGroup returns the name of the group v is listed in.
+func (cl *CmdLine) cmdGroup(v *Cmd) string {
+	if v.Group != "" {
+		return v.Group
+	}
+	if cl.DefaultGroup != "" {
+		return cl.DefaultGroup
+	}
+	return defaultGroup
+}
+
+// sortGroups sorts the group names according to cl.GroupOrder;
+// groups not contained in GroupOrder are sorted by name.
+func (cl *CmdLine) sortGroups(names []string) {
+	sort.Strings(names)
+	if len(cl.GroupOrder) == 0 {
+		return
+	}
+	rank := func(g string) int {
+		g = groupTitle(g)
+		for i, name := range cl.GroupOrder {
+			if name == g {
+				return i
+			}
+		}
+		return len(cl.GroupOrder)
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return rank(names[i]) < rank(names[j])
+	})
+}
+
+// groupTitle strips a sort prefix terminated by "__"
+// from the group name g.
+func groupTitle(g string) string {
+	if i := strings.Index(g, "__"); i != -1 {
+		return g[i+2:]
+	}
+	return g
+}
+
 func (cl *CmdLine) help(w io.Writer, args []string) {
 	outmap := make(map[string]CmdMap, 8)
 	hasWritten := false
@@ -1087,14 +2139,7 @@ retry:
 				name = pfx + name
 			}
 		}
-		group := v.Group
-		if group == "" {
-			if cl.DefaultGroup == "" {
-				group = defaultGroup
-			} else {
-				group = cl.DefaultGroup
-			}
-		}
+		group := cl.cmdGroup(v)
 		gm, ok := outmap[group]
 		if !ok {
 			gm = make(CmdMap, 8)
@@ -1104,15 +2149,16 @@ retry:
 	}
 
 	gNames := make([]string, 0, len(outmap))
-	for name := range outmap {
+	for name, gm := range outmap {
+		if isDir && cl.HideEmptyGroups && allHidden(gm) {
+			continue
+		}
 		gNames = append(gNames, name)
 	}
-	sort.Strings(gNames)
+	cl.sortGroups(gNames)
 	for _, gmName := range gNames {
 		gm := outmap[gmName]
-		if i := strings.Index(gmName, "__"); i != -1 {
-			gmName = gmName[i+2:]
-		}
+		gmName = groupTitle(gmName)
 		if len(gNames) != 1 {
 			fmt.Fprintln(w, "["+gmName+"]\n")
 		}
@@ -1128,11 +2174,7 @@ retry:
 			if v.Hidden && isDir {
 				continue
 			}
-			flags := v.Flags
-			if flags != "" {
-				flags = " " + flags
-			}
-			fmt.Fprintln(w, "\t"+name+flags+argString(" ", v.Arg, "")+argString(" [", v.Opt, "]"))
+			fmt.Fprintln(w, "\t"+usage(name, v))
 			if v.Help != "" {
 				for _, s := range strings.Split(v.Help, "\n") {
 					fmt.Fprintln(w, "\t\t"+s)
@@ -1153,6 +2195,57 @@ retry:
 	}
 }
 
+func allHidden(m CmdMap) bool {
+	for _, v := range m {
+		if !v.Hidden {
+			return false
+		}
+	}
+	return true
+}
+
+// Synopsis returns the usage of the command specified by name,
+// which may be a dotted path into nested command maps, as shown
+// by `help', and the first line of its help text. If the command
+// does not exist, ok is false.
+func (cl *CmdLine) Synopsis(name string) (usageStr, firstLine string, ok bool) {
+	v, ok := cl.lookupCmd(name)
+	if !ok {
+		return "", "", false
+	}
+	firstLine = v.Help
+	if i := strings.IndexByte(firstLine, '\n'); i != -1 {
+		firstLine = firstLine[:i]
+	}
+	return usage(name, v), firstLine, true
+}
+
+func (cl *CmdLine) whatisCmd(ctx Context, arg []string) error {
+	for _, name := range arg[1:] {
+		u, help, ok := cl.Synopsis(name)
+		if !ok {
+			return fmt.Errorf("%s: %w", name, ErrNotFound)
+		}
+		if help != "" {
+			u += " - " + help
+		}
+		if _, err := ctx.Println(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// usage returns the name of a command followed by
+// its flags, arguments, and optional arguments.
+func usage(name string, v *Cmd) string {
+	flags := v.Flags
+	if flags != "" {
+		flags = " " + flags
+	}
+	return name + flags + argString(" ", v.Arg, "") + argString(" [", v.Opt, "]")
+}
+
 func argString(pfx string, args []string, sfx string) string {
 	if len(args) == 0 {
 		return ""
@@ -1167,13 +2260,19 @@ type writer struct {
 }
 
 func (cl *CmdLine) newWriter(w io.Writer) *writer {
+	return cl.newEnvWriter(w, cl.env)
+}
+
+func (cl *CmdLine) newEnvWriter(w io.Writer, env *Env) *writer {
 	var b bytes.Buffer
+
+	// The values of OFS and prefix may contain escape
+	// sequences as in Go string literals, like "\t".
 	get := func(name string) string {
-		q := cl.env.Getenv(name)
-		q = strings.Replace(q, `"`, `\"`, -1)
+		q := strings.Replace(env.Getenv(name), `"`, `\"`, -1)
 		s, err := strconv.Unquote(`"` + q + `"`)
 		if err != nil {
-			return "getenv: unquote: err.Error()"
+			return "<getenv: unquote: " + err.Error() + ">"
 		}
 		return s
 	}
@@ -1215,6 +2314,7 @@ func (w *writer) print(s string) (n int, err error) {
 }
 
 type templateMap struct {
+	sync.Mutex
 	t0   time.Time
 	m    map[string]*template.Template
 	nMax int
@@ -1222,13 +2322,15 @@ type templateMap struct {
 
 func newTemplateMap(nMax int) *templateMap {
 	return &templateMap{
-		t0:   time.Now(),
+		t0:   timeNow(),
 		m:    make(map[string]*template.Template, nMax),
 		nMax: nMax,
 	}
 }
 
 func (tm *templateMap) Get(name, def string) (*template.Template, error) {
+	tm.Lock()
+	defer tm.Unlock()
 	t, ok := tm.m[def]
 	if ok {
 		return t, nil
@@ -1244,6 +2346,13 @@ func (tm *templateMap) Get(name, def string) (*template.Template, error) {
 		"t0": func() time.Time {
 			return tm.t0
 		},
+		"cwd": func() string {
+			dir, err := os.Getwd()
+			if err != nil {
+				return "<" + err.Error() + ">"
+			}
+			return dir
+		},
 	})
 	t, err := t.Parse(def)
 	if err != nil {
@@ -0,0 +1,1581 @@
+package interp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func runScript(t *testing.T, script string, m CmdMap, opts ...Option) (stdout, stderr string, err error) {
+	t.Helper()
+	var bOut, bErr bytes.Buffer
+	if m == nil {
+		m = CmdMap{}
+	}
+	opts = append([]Option{WithStdout(&bOut), WithStderr(&bErr)}, opts...)
+	cl := NewCmdInterp(bufio.NewScanner(strings.NewReader(script)), m, opts...)
+	err = cl.Process()
+	return bOut.String(), bErr.String(), err
+}
+
+func TestBackgroundJob(t *testing.T) {
+	release := make(chan struct{})
+	m := CmdMap{
+		"show": {
+			Fn: func(ctx Context, _ []string) error {
+				<-release
+				_, err := ctx.Println(ctx.Getenv("x"))
+				return err
+			},
+		},
+		"release": {
+			Fn: func(Context, []string) error {
+				close(release)
+				return nil
+			},
+		},
+	}
+	script := `x=1
+show &
+x=2
+release
+wait 1
+echo $x
+`
+	out, _, err := runScript(t, script, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "1\n2\n" {
+		t.Errorf("unexpected output: %q", out)
+	}
+}
+
+func TestWaitJobFinishedEarlier(t *testing.T) {
+	second := make(chan struct{})
+	m := CmdMap{
+		"first": {
+			Fn: func(ctx Context, _ []string) error {
+				<-second
+				return nil
+			},
+		},
+		"second": {
+			Fn: func(Context, []string) error {
+				close(second)
+				return nil
+			},
+		},
+	}
+
+	// job 2 terminates before job 1; waiting for job 1 must not
+	// discard job 2 before it has been waited for
+	_, stderr, err := runScript(t, "first &\nsecond &\nwait 1\nwait 2\n", m)
+	if err != nil {
+		t.Fatal(err, stderr)
+	}
+}
+
+func TestLineBufferedOutput(t *testing.T) {
+	const nLines = 200
+	m := CmdMap{
+		"spell": {
+			Arg: []string{"WORD"},
+			Fn: func(ctx Context, arg []string) error {
+				for i := 0; i < nLines; i++ {
+					for _, c := range arg[1] + "\n" {
+						if _, err := io.WriteString(ctx, string(c)); err != nil {
+							return err
+						}
+						runtime.Gosched()
+					}
+				}
+				return nil
+			},
+		},
+	}
+	script := `spell abcdef &
+spell uvwxyz &
+wait 1
+wait 2
+`
+	out, _, err := runScript(t, script, m, WithLineBufferedOutput())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) != 2*nLines {
+		t.Fatalf("got %d lines, want %d", len(lines), 2*nLines)
+	}
+	for _, l := range lines {
+		if l != "abcdef" && l != "uvwxyz" {
+			t.Fatalf("line has been split: %q", l)
+		}
+	}
+}
+
+// An overlapWriter records whether Write has been
+// called while another call was still in progress.
+type overlapWriter struct {
+	bytes.Buffer
+	active   int32
+	overlap  int32
+	inJobOut chan struct{}
+}
+
+func (w *overlapWriter) Write(p []byte) (int, error) {
+	if atomic.AddInt32(&w.active, 1) > 1 {
+		atomic.StoreInt32(&w.overlap, 1)
+		atomic.AddInt32(&w.active, -1)
+		return len(p), nil
+	}
+	defer atomic.AddInt32(&w.active, -1)
+	if string(p) == "job\n" {
+		// give other writers a chance to interfere
+		close(w.inJobOut)
+		time.Sleep(20 * time.Millisecond)
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestLineBufferedForeground(t *testing.T) {
+	w := &overlapWriter{inJobOut: make(chan struct{})}
+	m := CmdMap{
+		"job": {
+			Fn: func(ctx Context, _ []string) error {
+				_, err := ctx.Println("job")
+				return err
+			},
+		},
+		"fg": {
+			fgOnly: true,
+			Fn: func(ctx Context, _ []string) error {
+				<-w.inJobOut
+				_, err := ctx.Println("fg")
+				return err
+			},
+		},
+	}
+	script := "job &\nfg\nflag x +\nwait 1\n"
+	cl := NewCmdInterp(bufio.NewScanner(strings.NewReader(script)), m, WithStdout(w), WithLineBufferedOutput())
+	if err := cl.Process(); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&w.overlap) != 0 {
+		t.Errorf("output of a foreground command overlaps output of a job")
+	}
+	if out := w.String(); out != "job\nfg\n% wait 1\n" {
+		t.Errorf("unexpected output: %q", out)
+	}
+}
+
+func TestKillJob(t *testing.T) {
+	m := CmdMap{
+		"block": {
+			Fn: func(ctx Context, _ []string) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		},
+	}
+	script := `block &
+block &
+kill 1
+wait 1
+echo killed
+kill 3
+`
+	out, stderr, err := runScript(t, script, m)
+	if err != ErrLastCmdFailed {
+		t.Errorf("got %v, want %v", err, ErrLastCmdFailed)
+	}
+	if out != "killed\n" {
+		t.Errorf("unexpected output: %q", out)
+	}
+	if !strings.Contains(stderr, "context canceled") || !strings.Contains(stderr, ErrNoSuchJob.Error()) {
+		t.Errorf("unexpected error output: %q", stderr)
+	}
+}
+
+func TestBackgroundRejected(t *testing.T) {
+	_, stderr, _ := runScript(t, "shift &\n", nil)
+	if !strings.Contains(stderr, ErrNoBackground.Error()) {
+		t.Errorf("unexpected error output: %q", stderr)
+	}
+}
+
+func TestStripBOM(t *testing.T) {
+	script := "\uFEFFecho hello\n"
+	out, _, err := runScript(t, script, nil, WithStripBOM())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "hello\n" {
+		t.Errorf("unexpected output: %q", out)
+	}
+	_, _, err = runScript(t, script, nil)
+	if err != ErrLastCmdFailed {
+		t.Errorf("expected failure without WithStripBOM, got %v", err)
+	}
+}
+
+func TestProcessAndExit(t *testing.T) {
+	defer func() { osExit = os.Exit }()
+
+	tests := []struct {
+		script string
+		status int
+	}{
+		{"echo ok\n", 0},
+		{"false\n", 1},
+		{"echo ok\nexit 3\necho not reached\n", 3},
+		{"false\nexit\n", 1},
+	}
+	for i, test := range tests {
+		status := -1
+		osExit = func(code int) {
+			status = code
+		}
+		var out bytes.Buffer
+		cl := NewCmdInterp(bufio.NewScanner(strings.NewReader(test.script)), CmdMap{}, WithStdout(&out), WithStderr(&out))
+		cl.ProcessAndExit()
+		if status != test.status {
+			t.Errorf("[%d] exit status: %d != %d", i, status, test.status)
+		}
+		if strings.Contains(out.String(), "not reached") {
+			t.Errorf("[%d] processing continued after exit", i)
+		}
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	var errs []error
+	h := func(err error) {
+		errs = append(errs, err)
+	}
+	_, stderr, _ := runScript(t, "nonexistent a b\necho ok\n", nil, WithErrorHandler(h))
+	if stderr != "" {
+		t.Errorf("unexpected error output: %q", stderr)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("unexpected number of errors: %d", len(errs))
+	}
+	var fe *FnError
+	if !errors.As(errs[0], &fe) {
+		t.Fatalf("error is not an *FnError: %v", errs[0])
+	}
+	if fe.Fn != "nonexistent" || !errors.Is(fe, ErrNotFound) {
+		t.Errorf("unexpected error: %v", fe)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	script := `group Build {
+	echo a
+	echo b
+}
+echo c
+`
+	out, _, err := runScript(t, script, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "== Build\n\ta\n\tb\n== end Build\nc\n"; out != want {
+		t.Errorf("unexpected output: %q, want %q", out, want)
+	}
+}
+
+func TestMethodChain(t *testing.T) {
+	out, _, err := runScript(t, "methodchain '1*foo(sin(x).round(0.1)).bar(2, 3)'\n", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "1*bar(foo(round(sin(x), 0.1)), 2, 3)\n"; out != want {
+		t.Errorf("unexpected output: %q, want %q", out, want)
+	}
+
+	out, stderr, err := runScript(t, "methodchain 'sin(x)+1).round(0.5)'\n", nil)
+	if err != ErrLastCmdFailed {
+		t.Errorf("expected failure, got %v", err)
+	}
+	if out != "" || !strings.Contains(stderr, "missing opening brace") {
+		t.Errorf("unexpected output: %q, %q", out, stderr)
+	}
+}
+
+func TestTrap(t *testing.T) {
+	script := `trap {
+	echo bye
+} EXIT
+trap { echo caught } INT
+selfintr
+echo after
+`
+	var out, errOut bytes.Buffer
+	m := CmdMap{}
+	cl := NewCmdInterp(bufio.NewScanner(strings.NewReader(script)), m, WithStdout(&out), WithStderr(&errOut))
+	m["selfintr"] = &Cmd{
+		Fn: func(ctx Context, _ []string) error {
+			if !cl.Interrupt(time.Second) {
+				return errors.New("interrupt failed")
+			}
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}
+	err := cl.Process()
+	if err != nil {
+		t.Errorf("unexpected result: %v", err)
+	}
+	if want := "caught\nafter\nbye\n"; out.String() != want {
+		t.Errorf("unexpected output: %q, want %q", out.String(), want)
+	}
+	if !strings.Contains(errOut.String(), ErrInterrupt.Error()) {
+		t.Errorf("unexpected error output: %q", errOut.String())
+	}
+}
+
+func TestTrapDefaultSignal(t *testing.T) {
+	script := `trap 'echo caught'
+selfintr
+trap {
+	selfintr
+	echo not reached
+}
+selfintr
+echo after
+`
+	var out, errOut bytes.Buffer
+	m := CmdMap{}
+	cl := NewCmdInterp(bufio.NewScanner(strings.NewReader(script)), m, WithStdout(&out), WithStderr(&errOut))
+	m["selfintr"] = &Cmd{
+		Fn: func(ctx Context, _ []string) error {
+			if !cl.Interrupt(time.Second) {
+				return errors.New("interrupt failed")
+			}
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}
+	done := make(chan error)
+	go func() {
+		done <- cl.Process()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected result: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+	if want := "caught\nafter\n"; out.String() != want {
+		t.Errorf("unexpected output: %q, want %q", out.String(), want)
+	}
+}
+
+func TestTrapUnknownSignal(t *testing.T) {
+	for _, script := range []string{
+		"trap 'echo x' BADSIG\n",
+		"trap echo x BADSIG\n",
+		"trap { echo x } BADSIG\n",
+		"trap BADSIG\n",
+	} {
+		_, stderr, err := runScript(t, script, nil)
+		if err != ErrLastCmdFailed || !strings.Contains(stderr, ErrUnknownSignal.Error()) {
+			t.Errorf("%q: unexpected result: %v, %q", script, err, stderr)
+		}
+	}
+}
+
+func TestRedirectStderr(t *testing.T) {
+	dir := t.TempDir()
+	m := CmdMap{
+		"fail": {
+			Fn: func(ctx Context, _ []string) error {
+				ctx.Println("out")
+				io.WriteString(ctx.(ErrorContext).Stderr(), "warning\n")
+				return errors.New("failed")
+			},
+		},
+	}
+	script := "fail >" + dir + "/a 2>" + dir + "/b\n" +
+		"fail >" + dir + "/c 2>&1\n" +
+		"fail 2>>" + dir + "/b\n" +
+		"echo done\n"
+	stdout, stderr, err := runScript(t, script, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout != "out\ndone\n" || stderr != "" {
+		t.Errorf("unexpected output: %q, %q", stdout, stderr)
+	}
+	for _, f := range []struct{ name, content string }{
+		{"a", "out\n"},
+		{"b", "warning\nfail: failed\nwarning\nfail: failed\n"},
+		{"c", "out\nwarning\nfail: failed\n"},
+	} {
+		b, err := ioutil.ReadFile(dir + "/" + f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != f.content {
+			t.Errorf("%s: unexpected content: %q", f.name, b)
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	dir := t.TempDir()
+	called := false
+	m := CmdMap{
+		"touch": {
+			Arg: []string{"FILE"},
+			Fn: func(Context, []string) error {
+				called = true
+				return nil
+			},
+		},
+	}
+	script := `fn greet {
+	echo hello $*
+}
+x=1
+greet world
+if test $x = 1 {
+	touch a
+}
+sleep 1h
+echo out >` + dir + `/f
+`
+	stdout, stderr, err := runScript(t, script, m, WithDryRun(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if called {
+		t.Error("command has been executed")
+	}
+	if _, err := os.Stat(dir + "/f"); !os.IsNotExist(err) {
+		t.Errorf("redirection file has been created: %v", err)
+	}
+	want := "% x=1\n% greet world\n% echo hello world\nhello world\n% test 1 = 1\n% touch a\n% sleep 1h\n% echo out >" + dir + "/f\n"
+	if stdout != want || stderr != "" {
+		t.Errorf("unexpected output: %q, %q", stdout, stderr)
+	}
+}
+
+func TestAssignmentWithComment(t *testing.T) {
+	out, _, err := runScript(t, "x=1 # set x\ny=2# set y\necho $x $y\n", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "1 2\n" {
+		t.Errorf("unexpected output: %q", out)
+	}
+}
+
+func TestHereDoc(t *testing.T) {
+	script := `x=world
+l=(a b c)
+cat <<EOF
+hello $x
+$#l: $l(2) $l, '$y' costs 100$
+EOF
+cat <<'EOF'
+hello $x
+EOF
+`
+	out, _, err := runScript(t, script, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "hello world\n3: b a b c, '' costs 100$\nhello $x\n"; out != want {
+		t.Errorf("unexpected output: %q, want %q", out, want)
+	}
+
+	out, stderr, err := runScript(t, "cat <<END\nfoo\n", nil)
+	if err != ErrLastCmdFailed {
+		t.Errorf("expected failure, got %v", err)
+	}
+	if out != "" || !strings.Contains(stderr, "unexpected EOF") {
+		t.Errorf("unexpected output: %q, %q", out, stderr)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	m := CmdMap{
+		"block": {
+			Fn: func(ctx Context, _ []string) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		},
+		"quick": {
+			Fn: func(ctx Context, _ []string) error {
+				return nil
+			},
+			Timeout: time.Hour,
+		},
+	}
+	var errs []error
+	h := func(err error) {
+		errs = append(errs, err)
+	}
+	script := "fn f {\n\tblock\n\techo not reached\n}\nf\nquick\necho done\n"
+	out, _, err := runScript(t, script, m, WithDefaultTimeout(10*time.Millisecond), WithErrorHandler(h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "done\n" {
+		t.Errorf("unexpected output: %q", out)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], ErrTimeout) {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
+
+func TestTimeoutSleep(t *testing.T) {
+	var errs []error
+	h := func(err error) {
+		errs = append(errs, err)
+	}
+	script := "trap 'echo trapped'\nsleep 1s\necho done\n"
+	out, _, err := runScript(t, script, nil, WithDefaultTimeout(50*time.Millisecond), WithErrorHandler(h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "done\n" {
+		t.Errorf("unexpected output: %q", out)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], ErrTimeout) {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
+
+type closeRecorder struct {
+	*os.File
+	closed *bool
+}
+
+func (f closeRecorder) Close() error {
+	*f.closed = true
+	return f.File.Close()
+}
+
+func TestTimeoutRedirect(t *testing.T) {
+	m := CmdMap{
+		"block": {
+			Fn: func(ctx Context, _ []string) error {
+				ctx.Println("started")
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			Timeout: 10 * time.Millisecond,
+		},
+
+		// like the built-in sleep, report the end of the context
+		// as an interrupt
+		"blockintr": {
+			Fn: func(ctx Context, _ []string) error {
+				ctx.Println("started")
+				<-ctx.Done()
+				return ErrInterrupt
+			},
+			Timeout: 10 * time.Millisecond,
+		},
+	}
+	for _, cmd := range []string{"block", "blockintr"} {
+		name := filepath.Join(t.TempDir(), "out")
+		script := "trap 'echo trapped'\nfn f {\n\t" + cmd + " >" + name + "\n\techo not reached\n}\nf\necho done\n"
+		var out bytes.Buffer
+		var errs []error
+		h := func(err error) {
+			errs = append(errs, err)
+		}
+		cl := NewCmdInterp(bufio.NewScanner(strings.NewReader(script)), m, WithStdout(&out), WithStderr(ioutil.Discard), WithErrorHandler(h))
+		closed := false
+		cl.OpenRedirFile = func(name string, flag int, perm os.FileMode) (RedirFile, error) {
+			f, err := os.OpenFile(name, flag, perm)
+			if err != nil {
+				return nil, err
+			}
+			return closeRecorder{File: f, closed: &closed}, nil
+		}
+		if err := cl.Process(); err != nil {
+			t.Fatal(cmd, err)
+		}
+		if out.String() != "done\n" {
+			t.Errorf("%s: unexpected output: %q", cmd, out.String())
+		}
+		if len(errs) != 1 || !errors.Is(errs[0], ErrTimeout) {
+			t.Errorf("%s: unexpected errors: %v", cmd, errs)
+		}
+		if !closed {
+			t.Errorf("%s: redirection file not closed", cmd)
+		}
+		if b, err := ioutil.ReadFile(name); err != nil || string(b) != "started\n" {
+			t.Errorf("%s: unexpected file contents: %q, %v", cmd, b, err)
+		}
+	}
+}
+
+func TestBanner(t *testing.T) {
+	banner := WithBanner("Test shell{{if now}}, started{{end}}\n")
+	var out bytes.Buffer
+	cl := NewCmdInterp(bufio.NewScanner(strings.NewReader("echo hi\n")), CmdMap{}, WithStdout(&out), banner)
+	cl.Prompt = "> "
+	err := cl.Process()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Test shell, started\n> hi\n> "; out.String() != want {
+		t.Errorf("unexpected output: %q, want %q", out.String(), want)
+	}
+
+	stdout, _, err := runScript(t, "echo hi\n", nil, banner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout != "hi\n" {
+		t.Errorf("unexpected output: %q", stdout)
+	}
+}
+
+func TestPrompt(t *testing.T) {
+	stdout, _, err := runScript(t, "x=a\nfalse\necho hi\n", nil, WithPrompt("{{.Env.x}}:{{.Status}}> "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<no value>:0> a:0> a:1> hi\na:0> "; stdout != want {
+		t.Errorf("unexpected output: %q, want %q", stdout, want)
+	}
+
+	stdout, _, err = runScript(t, "echo hi\n", nil, WithPrompt("% "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "% hi\n% "; stdout != want {
+		t.Errorf("unexpected output: %q, want %q", stdout, want)
+	}
+}
+
+func TestPrompt2(t *testing.T) {
+	script := `fn f {
+	if ~ a a {
+		echo x
+	}
+}
+f
+`
+	opts := []Option{WithPrompt("> "), WithPrompt2("{{.Env.depth}}.. ")}
+	stdout, _, err := runScript(t, "depth=1\n"+script, nil, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "> > 1.. 1.. 1.. 1.. > x\n> "; stdout != want {
+		t.Errorf("unexpected output: %q, want %q", stdout, want)
+	}
+
+	// without a primary prompt, i.e. in non-interactive mode,
+	// the continuation prompt is not shown either
+	stdout, _, err = runScript(t, "fn g {\n\tif ~ a a {\n\t\techo y\n\t}\n}\ng\n", nil, WithPrompt2("... "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout != "y\n" {
+		t.Errorf("unexpected output: %q", stdout)
+	}
+}
+
+func TestSetExport(t *testing.T) {
+	script := `set a x y
+set b
+fn f {
+	set c z
+	set
+}
+d=w f
+set
+export a
+export
+`
+	stdout, _, err := runScript(t, script, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `'*'=()
+0=rc
+OFS=' '
+a=(x y)
+b=()
+c=z
+d=w
+prefix=()
+0=rc
+OFS=' '
+a=(x y)
+b=()
+prefix=()
+a=x y
+`
+	if stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+func TestListAssignment(t *testing.T) {
+	m := CmdMap{
+		"n": {
+			Fn: func(ctx Context, args []string) error {
+				_, err := ctx.Println(len(args)-1, strings.Join(args[1:], "|"))
+				return err
+			},
+			Opt: []string{"arg", "..."},
+		},
+	}
+	script := `a=(x 'y z')
+b=()
+c=(w
+n $a
+n $b
+`
+	stdout, stderr, err := runScript(t, script, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout != "2 x|y z\n0 \n" {
+		t.Errorf("unexpected output: %q", stdout)
+	}
+	if stderr != "list: missing ')'\n" {
+		t.Errorf("unexpected error output: %q", stderr)
+	}
+
+	// the output of set can be read again
+	stdout, _, err = runScript(t, "a=(x 'y z')\nb=()\nset\n", m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, _, err = runScript(t, stdout+"n $a\nn $b\n", m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "2 x|y z\n0 \n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+
+	// list variables assigned, and concatenated
+	script = `a=(1 2)
+b=$a
+c=x$a
+n $b
+n $c
+l=(p q)
+n $l^$a
+`
+	stdout, stderr, err = runScript(t, script, m)
+	if err != nil {
+		t.Fatal(err, stderr)
+	}
+	if want := "2 1|2\n2 x1|x2\n2 p1|q2\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+func TestWriterEnvEscapes(t *testing.T) {
+	script := `prefix='it''s "x": '
+OFS='\t'
+echo a b
+`
+	stdout, stderr, err := runScript(t, script, nil)
+	if err != nil {
+		t.Fatal(err, stderr)
+	}
+	if want := "it's \"x\": a\tb\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+func TestTestCmd(t *testing.T) {
+	dir := t.TempDir()
+	f := dir + "/file"
+	if err := ioutil.WriteFile(f, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	script := `if test -n x {
+	echo 1
+}
+if test -z x {
+	echo 2
+}
+if [ a = a ] {
+	echo 3
+}
+if [ a != a ] {
+	echo 4
+}
+if [ -f ` + f + ` ] {
+	echo 5
+}
+if [ -d ` + f + ` ] {
+	echo 6
+}
+if [ -d ` + dir + ` ] {
+	echo 7
+}
+if [ -e ` + dir + `/none ] {
+	echo 8
+}
+if test '' {
+	echo 9
+}
+if [ x ] {
+	echo 10
+}
+`
+	stdout, _, err := runScript(t, script, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "1\n3\n5\n7\n10\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a": "1\n2\n3\n4\n",
+		"b": "1\n2\n3\n4\n",
+		"c": "1\nx\n3\n4\n5\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(dir+"/"+name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stdout, _, err := runScript(t, "diff "+dir+"/a "+dir+"/b\n", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout != "" {
+		t.Errorf("identical files: unexpected output %q", stdout)
+	}
+
+	stdout, stderr, err := runScript(t, "diff "+dir+"/a "+dir+"/c\n", nil)
+	if err != ErrLastCmdFailed {
+		t.Errorf("got error %v, want %v", err, ErrLastCmdFailed)
+	}
+	if !strings.Contains(stderr, errFilesDiffer.Error()) {
+		t.Errorf("stderr: %q", stderr)
+	}
+	want := "--- " + dir + "/a\n+++ " + dir + "/c\n" +
+		"@@ -2 +2 @@\n-2\n+x\n" +
+		"@@ -4,0 +5 @@\n+5\n"
+	if stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	var bOut bytes.Buffer
+	env := NewEnv()
+	newInterp := func(script string) *CmdLine {
+		return NewCmdInterp(bufio.NewScanner(strings.NewReader(script)), CmdMap{},
+			WithStdout(&bOut), WithStderr(&bOut), WithEnv(env), WithTransaction())
+	}
+
+	cl := newInterp("a=1\nfn f {\n\techo f\n}\n")
+	if err := cl.Process(); err != nil {
+		t.Fatal(err)
+	}
+	if env.Getenv("a") != "1" || cl.funcMap["f"] == "" {
+		t.Fatal("changes of a successful session have been discarded")
+	}
+
+	cl = newInterp("a=2\nb=3\nfn g {\n\techo g\n}\nfail\n")
+	cl.funcMap["f"] = "\techo f\n"
+	if err := cl.Process(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if a, b := env.Getenv("a"), env.Getenv("b"); a != "1" || b != "" {
+		t.Errorf("variables not restored: a=%q b=%q", a, b)
+	}
+	if _, ok := cl.funcMap["g"]; ok {
+		t.Error("function definition not discarded")
+	}
+	if _, ok := cl.funcMap["f"]; !ok {
+		t.Error("previous function definition lost")
+	}
+
+	// the rollback is reported to the environment hook
+	var events []string
+	hook := func(op, name string, value []string) {
+		events = append(events, op+" "+name+"="+strings.Join(value, ","))
+	}
+	cl = NewCmdInterp(bufio.NewScanner(strings.NewReader("a=2\nb=3\nfail\n")), CmdMap{},
+		WithStdout(&bOut), WithStderr(&bOut), WithEnv(env), WithTransaction(), WithEnvHook(hook))
+	if err := cl.Process(); err == nil {
+		t.Fatal("expected an error")
+	}
+	want := "insert a=2\ninsert b=3\nrestore a=1\nrestore b="
+	if got := strings.Join(events, "\n"); got != want {
+		t.Errorf("unexpected events:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHelpJSON(t *testing.T) {
+	noop := func(Context, []string) error { return nil }
+	m := CmdMap{
+		"run": {Fn: noop, Arg: []string{"FILE"}, Help: "Run FILE.", Group: "A__Main"},
+		"dbg": {Fn: noop, Hidden: true},
+		"net": {Map: CmdMap{"up": {Fn: noop, Opt: []string{"IF"}}}},
+	}
+	stdout, _, err := runScript(t, "help -json\n", m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var groups []helpGroup
+	if err := json.Unmarshal([]byte(stdout), &groups); err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 || groups[0].Name != "Main" || groups[1].Name != "Other commands" {
+		t.Fatalf("unexpected groups: %+v", groups)
+	}
+	if c := groups[0].Commands; len(c) != 1 || c[0].Name != "run" || c[0].Arg[0] != "FILE" || c[0].Help != "Run FILE." {
+		t.Errorf("unexpected commands: %+v", c)
+	}
+	cmds := map[string]helpCmd{}
+	for _, c := range groups[1].Commands {
+		cmds[c.Name] = c
+	}
+	if !cmds["dbg"].Hidden {
+		t.Error("hidden command not marked")
+	}
+	if g := cmds["net"].Groups; len(g) != 1 || g[0].Commands[0].Name != "up" {
+		t.Errorf("unexpected subcommands: %+v", g)
+	}
+	if g := cmds["builtin"].Groups; len(g) == 0 {
+		t.Error("builtins missing")
+	}
+}
+
+func TestKV(t *testing.T) {
+	script := `kv set a 1 2 3
+kv set b x
+kv get a
+kv
+kv del a
+kv get a
+kv get b
+kv del a
+`
+	stdout, stderr, err := runScript(t, script, nil)
+	if err != ErrLastCmdFailed {
+		t.Errorf("got %v, want %v", err, ErrLastCmdFailed)
+	}
+	if want := "1 2 3\na\nb\nx\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+	if n := strings.Count(stderr, ErrNoSuchKey.Error()); n != 2 {
+		t.Errorf("unexpected error output: %q", stderr)
+	}
+}
+
+func TestJSONKV(t *testing.T) {
+	script := `json-set obj '{"b": [1, "x"], "a": true}'
+kv get obj
+json-get obj
+kv set list 1 2
+json-get list
+kv set n 42
+json-get n
+json-set n 42
+json-get n
+kv set obj '{}'
+json-get obj
+json-set bad '{'
+`
+	stdout, stderr, err := runScript(t, script, nil)
+	if err != ErrLastCmdFailed {
+		t.Errorf("got %v, want %v", err, ErrLastCmdFailed)
+	}
+	want := `{"a":true,"b":[1,"x"]}
+{
+	"a": true,
+	"b": [
+		1,
+		"x"
+	]
+}
+[
+	"1",
+	"2"
+]
+[
+	"42"
+]
+42
+[
+	"{}"
+]
+`
+	if stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+	if !strings.Contains(stderr, "json-set") {
+		t.Errorf("unexpected error output: %q", stderr)
+	}
+}
+
+func TestEval(t *testing.T) {
+	script := `cmd=echo
+x=1
+eval $cmd '$x' hello
+eval 'y=2
+echo $y'
+echo $y
+fn loop {
+	eval loop
+}
+loop
+`
+	stdout, stderr, err := runScript(t, script, nil)
+	if err != ErrLastCmdFailed {
+		t.Errorf("got %v, want %v", err, ErrLastCmdFailed)
+	}
+	if want := "1 hello\n2\n2\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+	if !strings.Contains(stderr, ErrStackOverflow.Error()) {
+		t.Errorf("unexpected error output: %q", stderr)
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	script := `fn down {
+	let n $n - 1
+	if ~ $n 0 {
+		echo bottom
+	}
+	if not {
+		down
+	}
+}
+n=3
+down
+fn f {
+	f
+}
+f
+echo after
+`
+	stdout, stderr, err := runScript(t, script, nil, WithMaxStackDepth(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "bottom\nafter\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+	if want := "f: " + ErrStackOverflow.Error() + "\n"; stderr != want {
+		t.Errorf("got %q, want %q", stderr, want)
+	}
+}
+
+func TestVerbose(t *testing.T) {
+	script := `echo a
+verbose 2
+echo b
+verbose
+verbose 1
+echo c
+verbose 0
+echo d &
+wait 1
+`
+	stdout, stderr, err := runScript(t, script, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "a\n% echo b\nb\n% verbose\n2\n% verbose 1\nc\nd\n"
+	if stdout != want || stderr != "" {
+		t.Errorf("unexpected output: %q, %q", stdout, stderr)
+	}
+
+	// the verbosity level does not affect flag x
+	script = `flag x +
+verbose 2
+verbose 1
+echo a
+flag x -
+verbose 2
+verbose 1
+echo b
+`
+	stdout, _, err = runScript(t, script, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "% verbose 2\n% verbose 1\n% echo a\na\n% flag x -\n% verbose 1\nb\n"
+	if stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	script := `x=global
+fn f {
+	local x=inner y
+	echo $x $#y
+	g
+	echo $x
+	x=changed
+	z=set
+}
+fn g {
+	local x=g
+	echo $x
+}
+f
+echo $x $#y $z
+local z
+`
+	stdout, stderr, err := runScript(t, script, nil)
+	if err != ErrLastCmdFailed {
+		t.Errorf("got %v, want %v", err, ErrLastCmdFailed)
+	}
+	if want := "inner 0\ng\ninner\nglobal 0 set\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+	if !strings.Contains(stderr, "local: not within a function") {
+		t.Errorf("unexpected error output: %q", stderr)
+	}
+}
+
+func TestTildeExpansion(t *testing.T) {
+	home := func(user string) (string, error) {
+		if user == "" {
+			return "/home/me", nil
+		}
+		return "", errors.New("unknown user")
+	}
+	script := "echo ~ ~/notes '~/x' ~nobody/y\nif ~ a a {\n\techo match\n}\nif ! ~ ~ x {\n\techo no match\n}\n"
+	stdout, _, err := runScript(t, script, nil, WithHomeDir(home))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/home/me /home/me/notes ~/x ~nobody/y\nmatch\nno match\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+
+	// disabled by default
+	stdout, _, err = runScript(t, "echo ~ ~/notes\n", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "~ ~/notes\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+func TestUptime(t *testing.T) {
+	defer func() { timeNow = time.Now }()
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := t0
+	timeNow = func() time.Time { return now }
+	m := CmdMap{
+		"advance": {
+			Fn: func(Context, []string) error {
+				now = now.Add(90*time.Minute + 5*time.Second)
+				return nil
+			},
+		},
+	}
+	stdout, _, err := runScript(t, "uptime\nadvance\nuptime\n", m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "0s\n1h30m5s\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+func TestRegexMatch(t *testing.T) {
+	script := `if ~~ key=value '^(\w+)=(\w*)$' {
+	echo $match(1) $match(2)
+}
+if ! ~~ abc '^x' {
+	echo no match
+}
+fn f {
+	~~ $1 '(\d+)'
+	echo $match $1
+	~~ $2 '(\d+)'
+	echo $match $1 $2
+}
+f abc42 x7
+~ a.b 'a.*'
+`
+	stdout, _, err := runScript(t, script, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "key value\nno match\n42 abc42\n7 abc42 x7\n"; stdout != want {
+		t.Errorf("unexpected output: %q, want %q", stdout, want)
+	}
+
+	_, stderr, err := runScript(t, "~~ a '('\n", nil)
+	if err == nil || !strings.Contains(stderr, "invalid regular expression") {
+		t.Errorf("invalid regex not reported: %v, %q", err, stderr)
+	}
+}
+
+func TestPollUntilStable(t *testing.T) {
+	var outputs []string
+	n := 0
+	m := CmdMap{
+		"probe": {
+			Fn: func(ctx Context, _ []string) error {
+				_, err := ctx.Println(outputs[n])
+				n++
+				return err
+			},
+		},
+	}
+	outputs = []string{"1", "2", "3", "3", "x"}
+	stdout, _, err := runScript(t, "poll-until-stable -d 1ms probe\necho done\n", m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "1\n2\n3\ndone\n"; stdout != want || n != 4 {
+		t.Errorf("unexpected output after %d runs: %q, want %q", n, stdout, want)
+	}
+
+	outputs = []string{"a", "a", "a", "b", "x"}
+	n = 0
+	stdout, _, err = runScript(t, "poll-until-stable -c -d 1ms probe\n", m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "a\nb\n"; stdout != want || n != 4 {
+		t.Errorf("-c: unexpected output after %d runs: %q, want %q", n, stdout, want)
+	}
+
+	for _, script := range []string{
+		"poll-until-stable -d 0s probe\n",
+		"poll-until-stable -d -1s probe\n",
+		"poll-until-stable -x probe\n",
+		"poll-until-stable -d 1ms\n",
+	} {
+		n = 0
+		_, stderr, err := runScript(t, script, m)
+		if err != ErrLastCmdFailed || n != 0 {
+			t.Errorf("%q: unexpected result after %d runs: %v, %q", script, n, err, stderr)
+		}
+	}
+}
+
+func TestFuncArgsRestored(t *testing.T) {
+	script := `fn f {
+	shift
+	echo $*
+}
+f a b c
+echo n=$#*
+fn g {
+	f x y
+	echo $*
+}
+g 1 2
+`
+	stdout, _, err := runScript(t, script, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "b c\nn=0\ny\n1 2\n"; stdout != want {
+		t.Errorf("unexpected output: %q, want %q", stdout, want)
+	}
+}
+
+func TestRand(t *testing.T) {
+	script := `repeat 50 rand int -2 3
+rand hex 5
+rand hex 0
+rand uuid
+`
+	stdout, _, err := runScript(t, script, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(stdout, "\n"), "\n")
+	if len(lines) != 53 {
+		t.Fatalf("unexpected number of lines: %d", len(lines))
+	}
+	for _, s := range lines[:50] {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < -2 || n > 3 {
+			t.Errorf("value out of range: %q", s)
+		}
+	}
+	if s := lines[50]; len(s) != 10 || strings.Trim(s, "0123456789abcdef") != "" {
+		t.Errorf("unexpected hex value: %q", s)
+	}
+	if s := lines[51]; s != "" {
+		t.Errorf("unexpected hex value: %q", s)
+	}
+	uuidRE := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if s := lines[52]; !uuidRE.MatchString(s) {
+		t.Errorf("unexpected uuid: %q", s)
+	}
+
+	for _, cmd := range []string{"rand int 3 1", "rand int 1", "rand foo", "rand hex x"} {
+		if _, _, err := runScript(t, cmd+"\n", nil); err == nil {
+			t.Errorf("%s: error expected", cmd)
+		}
+	}
+}
+
+func TestHelpGroupOrder(t *testing.T) {
+	noop := func(Context, []string) error { return nil }
+	m := CmdMap{
+		"run":  {Fn: noop, Group: "A__Main"},
+		"up":   {Fn: noop, Group: "Net"},
+		"dbg":  {Fn: noop, Group: "Debug", Hidden: true},
+		"misc": {Fn: noop},
+	}
+	headingRE := regexp.MustCompile(`(?m)^\[(.*)\]$`)
+	headings := func(opts ...Option) string {
+		t.Helper()
+		stdout, _, err := runScript(t, "help\n", m, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var list []string
+		for _, sm := range headingRE.FindAllStringSubmatch(stdout, -1) {
+			list = append(list, sm[1])
+		}
+		return strings.Join(list, ",")
+	}
+	if s := headings(); s != "Main,Debug,Net,Other commands" {
+		t.Errorf("default order: %s", s)
+	}
+	order := func(cl *CmdLine) {
+		cl.GroupOrder = []string{"Other commands", "Net"}
+		cl.HideEmptyGroups = true
+	}
+	if s := headings(order); s != "Other commands,Net,Main" {
+		t.Errorf("GroupOrder: %s", s)
+	}
+}
+
+func TestInitFlagsEndOfOptions(t *testing.T) {
+	var verbose bool
+	m := CmdMap{
+		"show": {
+			Opt: []string{"ARG", "..."},
+			InitFlags: func(f *flag.FlagSet) {
+				f.BoolVar(&verbose, "v", false, "verbose")
+			},
+			Fn: func(ctx Context, arg []string) error {
+				_, err := ctx.Println(verbose, strings.Join(arg[1:], ","))
+				return err
+			},
+		},
+	}
+	stdout, _, err := runScript(t, "show -v a\nshow -- -notaflag b\nshow -v -- -v\n", m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "true a\nfalse -notaflag,b\ntrue -v\n"; stdout != want {
+		t.Errorf("unexpected output: %q, want %q", stdout, want)
+	}
+}
+
+func TestRunString(t *testing.T) {
+	var out bytes.Buffer
+	m := CmdMap{
+		"fail": {Fn: func(Context, []string) error { return errors.New("failed") }},
+	}
+	cl := NewCmdInterp(bufio.NewScanner(strings.NewReader("")), m, WithStdout(&out), WithStderr(ioutil.Discard))
+	if err := cl.RunString("x=1\nfn f {\n\techo f $x\n}\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.RunReader(strings.NewReader("f\necho $#*\n")); err != nil {
+		t.Fatal(err)
+	}
+	if want := "f 1\n0\n"; out.String() != want {
+		t.Errorf("unexpected output: %q, want %q", out.String(), want)
+	}
+
+	out.Reset()
+	if err := cl.RunString("false\necho a\n"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := cl.RunString("echo b\nfalse\n"); err != ErrLastCmdFailed {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := cl.RunString("flag e +\nfail\necho c\n"); err != ErrLastCmdFailed {
+		t.Errorf("flag e: unexpected error: %v", err)
+	}
+	if want := "a\nb\n"; out.String() != want {
+		t.Errorf("unexpected output: %q, want %q", out.String(), want)
+	}
+
+	if err := cl.RunString("flag e -\nexit 3\necho d\n"); err != nil || cl.ExitStatus(err) != 3 {
+		t.Errorf("exit: unexpected result: %v, %d", err, cl.ExitStatus(err))
+	}
+}
+
+func TestSynopsis(t *testing.T) {
+	noop := func(Context, []string) error { return nil }
+	m := CmdMap{
+		"net": {Map: CmdMap{
+			"up": {Fn: noop, Flags: "[-v]", Arg: []string{"IF"}, Opt: []string{"ADDR"}, Help: "Bring up an interface.\nMore details."},
+		}},
+	}
+	cl := NewCmdInterp(bufio.NewScanner(strings.NewReader("")), m)
+	u, help, ok := cl.Synopsis("net.up")
+	if !ok || u != "net.up [-v] IF [ADDR]" || help != "Bring up an interface." {
+		t.Errorf("unexpected synopsis: %q, %q, %v", u, help, ok)
+	}
+	if _, _, ok := cl.Synopsis("net.down"); ok {
+		t.Error("unknown command found")
+	}
+
+	stdout, _, err := runScript(t, "whatis net.up sleep\n", m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "net.up [-v] IF [ADDR] - Bring up an interface.\nsleep DURATION - Sleep for the specified duration.\n"; stdout != want {
+		t.Errorf("unexpected output: %q, want %q", stdout, want)
+	}
+}
+
+func TestEnvHook(t *testing.T) {
+	var events []string
+	hook := func(op, name string, value []string) {
+		events = append(events, op+" "+name+"="+strings.Join(value, ","))
+	}
+	script := `x=1
+fn f {
+	shift
+}
+f a b
+y=2 echo
+`
+	_, _, err := runScript(t, script, nil, WithEnvHook(hook))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"insert x=1",
+		"set *=a,b",
+		"set *=b",
+		"set *=",
+		"push y=2",
+		"pop y=2",
+	}
+	if got := strings.Join(events, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("unexpected events:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+}
+
+// growingFile returns its chunks one after another,
+// each followed by io.EOF.
+type growingFile struct {
+	chunks []string
+	atEOF  bool
+}
+
+func (f *growingFile) Read(p []byte) (int, error) {
+	if len(f.chunks) == 0 || f.atEOF {
+		f.atEOF = false
+		return 0, io.EOF
+	}
+	n := copy(p, f.chunks[0])
+	if f.chunks[0] = f.chunks[0][n:]; f.chunks[0] == "" {
+		f.chunks = f.chunks[1:]
+		f.atEOF = true
+	}
+	return n, nil
+}
+
+func (f *growingFile) Close() error {
+	return nil
+}
+
+func TestTail(t *testing.T) {
+	defer func(d time.Duration) { tailPollInterval = d }(tailPollInterval)
+	tailPollInterval = time.Millisecond
+
+	open := func(cl *CmdLine) {
+		cl.Open = func(name string) (io.ReadCloser, error) {
+			switch name {
+			case "small":
+				return ioutil.NopCloser(strings.NewReader("1\n2\n3\n4\n5")), nil
+			case "log":
+				return &growingFile{chunks: []string{"a\nb\n", "c\nd", "\ne\n"}}, nil
+			}
+			return nil, os.ErrNotExist
+		}
+	}
+	stdout, _, err := runScript(t, "tail -n 2 small\ntail small\ntail -n 0 small\n", nil, open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "4\n5\n1\n2\n3\n4\n5\n"; stdout != want {
+		t.Errorf("unexpected output: %q, want %q", stdout, want)
+	}
+
+	var errs []error
+	h := func(err error) {
+		errs = append(errs, err)
+	}
+	stdout, _, err = runScript(t, "tail -n 1 -f log\necho done\n", nil, open, WithDefaultTimeout(50*time.Millisecond), WithErrorHandler(h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "b\nc\nd\ne\ndone\n"; stdout != want {
+		t.Errorf("unexpected output: %q, want %q", stdout, want)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], ErrTimeout) {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+
+	stdout, _, err = runScript(t, "tail -n 1 small &\nwait\n", nil, open, WithVerbosity(VerbosityQuiet))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout != "5\n" {
+		t.Errorf("unexpected output of background job: %q", stdout)
+	}
+
+	// invalid flags and arguments are reported, but
+	// don't terminate the interpreter
+	_, stderr, err := runScript(t, "tail -n\ntail -n 2\ntail -x small\ntail -n -1 small\necho done\n", nil, open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(stderr, "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("unexpected error output: %q", stderr)
+	}
+	for i, s := range []string{"flag needs an argument", ErrWrongNArg.Error(), "not defined: -x", "invalid value"} {
+		if !strings.HasPrefix(lines[i], "tail: ") || !strings.Contains(lines[i], s) {
+			t.Errorf("[%d] unexpected error: %q", i, lines[i])
+		}
+	}
+}
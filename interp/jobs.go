@@ -0,0 +1,242 @@
+package interp
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/knieriem/text"
+	"github.com/knieriem/text/rc"
+)
+
+var ErrNoBackground = errors.New("cannot be run in background")
+var ErrNoSuchJob = errors.New("no such job")
+
+// A job is a command that has been started in background
+// by terminating its command line with '&'.
+type job struct {
+	id      int
+	cmdLine string
+	cancel  context.CancelFunc
+	done    chan struct{}
+	err     error
+}
+
+func (j *job) finished() bool {
+	select {
+	case <-j.done:
+		return true
+	default:
+	}
+	return false
+}
+
+func (j *job) status() string {
+	if !j.finished() {
+		return "Running"
+	}
+	if j.err != nil {
+		return "Exit: " + j.err.Error()
+	}
+	return "Done"
+}
+
+type jobList struct {
+	sync.Mutex
+	list   []*job
+	lastID int
+
+	// fgBusy is true while a command is executed in foreground
+	fgBusy bool
+}
+
+// start runs fn in a separate goroutine, and adds
+// a corresponding job to the list.
+func (jl *jobList) start(ctx *icontext, cancel context.CancelFunc, c *rc.CmdLine, fn func(Context) error) *job {
+	jl.Lock()
+	jl.lastID++
+	j := &job{
+		id:      jl.lastID,
+		cmdLine: c.String(),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	jl.list = append(jl.list, j)
+	jl.Unlock()
+
+	go func() {
+		j.err = fn(ctx)
+		cancel()
+		close(j.done)
+	}()
+	return j
+}
+
+func (jl *jobList) setForeground(busy bool) {
+	jl.Lock()
+	jl.fgBusy = busy
+	jl.Unlock()
+}
+
+// interrupt cancels the most recent job that is still running,
+// unless a command is running in foreground. It returns false
+// if no job has been cancelled.
+func (jl *jobList) interrupt() bool {
+	jl.Lock()
+	defer jl.Unlock()
+	if jl.fgBusy {
+		return false
+	}
+	for i := len(jl.list) - 1; i >= 0; i-- {
+		if j := jl.list[i]; !j.finished() {
+			j.cancel()
+			return true
+		}
+	}
+	return false
+}
+
+func (jl *jobList) lookup(id int) *job {
+	jl.Lock()
+	defer jl.Unlock()
+	for _, j := range jl.list {
+		if j.id == id {
+			return j
+		}
+	}
+	return nil
+}
+
+// kill cancels the context of the specified job.
+func (jl *jobList) kill(id int) error {
+	j := jl.lookup(id)
+	if j == nil {
+		return ErrNoSuchJob
+	}
+	j.cancel()
+	return nil
+}
+
+// reap removes finished jobs from the list.
+func (jl *jobList) reap() {
+	jl.remove(func(j *job) bool {
+		return j.finished()
+	})
+}
+
+// remove removes the jobs from the list for which fn returns true.
+func (jl *jobList) remove(fn func(*job) bool) {
+	jl.Lock()
+	defer jl.Unlock()
+	list := jl.list[:0]
+	for _, j := range jl.list {
+		if !fn(j) {
+			list = append(list, j)
+		}
+	}
+	for i := len(list); i < len(jl.list); i++ {
+		jl.list[i] = nil
+	}
+	jl.list = list
+}
+
+func (jl *jobList) snapshot() []*job {
+	jl.Lock()
+	defer jl.Unlock()
+	return append([]*job(nil), jl.list...)
+}
+
+// cancelAll cancels all jobs, and waits until they have terminated.
+func (jl *jobList) cancelAll() {
+	for _, j := range jl.snapshot() {
+		j.cancel()
+		<-j.done
+	}
+	jl.reap()
+}
+
+func (jl *jobList) print(w text.Writer) {
+	for _, j := range jl.snapshot() {
+		w.Printf("[%d] %s\t%s", j.id, j.status(), j.cmdLine)
+	}
+	jl.reap()
+}
+
+// wait blocks until the specified job, or, if id is zero,
+// all jobs have terminated.
+func (jl *jobList) wait(ctx context.Context, id int) error {
+	var list []*job
+	if id == 0 {
+		list = jl.snapshot()
+	} else {
+		j := jl.lookup(id)
+		if j == nil {
+			return ErrNoSuchJob
+		}
+		list = []*job{j}
+	}
+	var err error
+	for _, j := range list {
+		select {
+		case <-j.done:
+			if j.err != nil {
+				// The job's error is not wrapped, so that a
+				// cancelled job is not mistaken for an interrupt
+				// of the foreground command.
+				err = fmt.Errorf("[%d]: %v", j.id, j.err)
+			}
+		case <-ctx.Done():
+			return ErrInterrupt
+		}
+	}
+
+	// Only remove the jobs waited for; other jobs that have
+	// finished in the meantime remain available to wait and jobs.
+	jl.remove(func(j *job) bool {
+		for _, w := range list {
+			if j == w {
+				return true
+			}
+		}
+		return false
+	})
+	return err
+}
+
+// startJob launches cmd in background. The job will see a snapshot
+// of the environment taken at launch time, so that later changes
+// won't affect it.
+func (cl *CmdLine) startJob(cmd *Cmd, c *rc.CmdLine, args []string, flags *flag.FlagSet, w text.Writer, ew io.Writer, stdin io.Reader) {
+	env := cl.env.snapshot()
+	var lb *lineBuffer
+	if tw, ok := w.(*writer); ok {
+		w, lb = cl.bufferLines(cl.newEnvWriter(tw.Writer, env))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ictx := &icontext{
+		Writer:  w,
+		Context: ctx,
+		getenv:  env.Getenv,
+		stdin:   stdin,
+		stderr:  ew,
+		flags:   flags,
+	}
+	if cl.cmdHook != nil {
+		cl.cmdHook(ictx)
+	}
+	j := cl.jobs.start(ictx, cancel, c, func(ctx Context) error {
+		err := cmd.Fn(ctx, args)
+		if lb != nil {
+			if ferr := lb.Flush(); err == nil {
+				err = ferr
+			}
+		}
+		return err
+	})
+	if cl.verbosity > VerbosityQuiet {
+		fmt.Fprintf(cl.errOut, "[%d]\n", j.id)
+	}
+}
@@ -0,0 +1,107 @@
+package interp
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+var ErrNoSuchKey = errors.New("no such key")
+
+const kvHelp = `Manage a key/value store, that persists during the session:
+	kv set KEY VALUE...	assign the list of VALUEs to KEY
+	kv get KEY	print the values of KEY
+	kv del KEY	remove KEY
+	kv	list all keys`
+
+func (cl *CmdLine) kvCmd(ctx Context, arg []string) error {
+	if len(arg) == 1 {
+		return cl.kvList(ctx)
+	}
+	op, arg := arg[1], arg[2:]
+	if op == "set" {
+		if len(arg) == 0 {
+			return ErrWrongNArg
+		}
+		if cl.kv == nil {
+			cl.kv = make(map[string][]string, 8)
+		}
+		cl.kv[arg[0]] = append([]string(nil), arg[1:]...)
+		delete(cl.kvJSON, arg[0])
+		return nil
+	}
+	if op != "get" && op != "del" {
+		return errors.New("unknown operation: " + op)
+	}
+	if len(arg) != 1 {
+		return ErrWrongNArg
+	}
+	key := arg[0]
+	val, ok := cl.kv[key]
+	if !ok {
+		return ErrNoSuchKey
+	}
+	if op == "get" {
+		_, err := ctx.PrintSlice(val)
+		return err
+	}
+	delete(cl.kv, key)
+	delete(cl.kvJSON, key)
+	return nil
+}
+
+func (cl *CmdLine) kvList(ctx Context) error {
+	keys := make([]string, 0, len(cl.kv))
+	for k := range cl.kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if _, err := ctx.Println(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const jsonSetHelp = "Parse JSON and store it in the key/value store under KEY."
+
+const jsonGetHelp = `Print the value of KEY as indented JSON.
+A value not stored by json-set is printed as an array of strings.`
+
+func (cl *CmdLine) jsonSetCmd(_ Context, arg []string) error {
+	var v interface{}
+	if err := json.Unmarshal([]byte(arg[2]), &v); err != nil {
+		return err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if cl.kv == nil {
+		cl.kv = make(map[string][]string, 8)
+	}
+	if cl.kvJSON == nil {
+		cl.kvJSON = make(map[string]bool, 8)
+	}
+	cl.kv[arg[1]] = []string{string(b)}
+	cl.kvJSON[arg[1]] = true
+	return nil
+}
+
+func (cl *CmdLine) jsonGetCmd(ctx Context, arg []string) error {
+	val, ok := cl.kv[arg[1]]
+	if !ok {
+		return ErrNoSuchKey
+	}
+	var v interface{} = val
+	if cl.kvJSON[arg[1]] {
+		v = json.RawMessage(val[0])
+	}
+	b, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = ctx.Println(string(b))
+	return err
+}
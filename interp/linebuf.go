@@ -0,0 +1,99 @@
+package interp
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// WithLineBufferedOutput makes the interpreter buffer the output of
+// each command, and pass it on in units of complete lines, so that
+// lines written by concurrently running commands, like background
+// jobs, do not get mixed up. A trailing incomplete line is written
+// when the command has finished. Output of built-in commands that
+// are restricted to the foreground, and other output written by
+// the interpreter itself, is not buffered, but is serialized with
+// the lines written by commands.
+func WithLineBufferedOutput() Option {
+	return func(cl *CmdLine) {
+		cl.lineBuffered = true
+	}
+}
+
+// A lineBuffer collects output, and writes complete lines to
+// its destination.
+type lineBuffer struct {
+	dest io.Writer
+	buf  []byte
+}
+
+func (b *lineBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	i := bytes.LastIndexByte(b.buf, '\n')
+	if i == -1 {
+		return len(p), nil
+	}
+	err := b.write(b.buf[:i+1])
+	b.buf = b.buf[:copy(b.buf, b.buf[i+1:])]
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush writes any remaining data, even if the last
+// line is incomplete.
+func (b *lineBuffer) Flush() error {
+	if len(b.buf) == 0 {
+		return nil
+	}
+	err := b.write(b.buf)
+	b.buf = b.buf[:0]
+	return err
+}
+
+func (b *lineBuffer) write(p []byte) error {
+	_, err := b.dest.Write(p)
+	return err
+}
+
+// A lockedWriter serializes writes to w using mu, which is
+// shared by all writers to output destinations of an interpreter.
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (lw *lockedWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.w.Write(p)
+}
+
+// lockOutput makes writes to the interpreter's standard and error
+// output pass the lock also used by lineBuffers, if line buffering
+// has been enabled.
+func (cl *CmdLine) lockOutput() {
+	if cl.lineBuffered {
+		cl.Stdout = &lockedWriter{mu: &cl.outMu, w: cl.Stdout}
+		cl.errOut = &lockedWriter{mu: &cl.outMu, w: cl.errOut}
+	}
+}
+
+// bufferLines returns a copy of w that writes through a lineBuffer,
+// if line buffering has been enabled. The lineBuffer is nil
+// if output is not buffered.
+func (cl *CmdLine) bufferLines(w *writer) (*writer, *lineBuffer) {
+	if !cl.lineBuffered {
+		return w, nil
+	}
+	dest := w.Writer
+	if _, ok := dest.(*lockedWriter); !ok {
+		// e.g. a file that the output has been redirected to
+		dest = &lockedWriter{mu: &cl.outMu, w: dest}
+	}
+	lb := &lineBuffer{dest: dest}
+	bw := *w
+	bw.Writer = lb
+	return &bw, lb
+}
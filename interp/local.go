@@ -0,0 +1,75 @@
+package interp
+
+import (
+	"errors"
+	"strings"
+)
+
+const localHelp = `Declare variables local to the current function. After the
+function has returned, the previous values are restored. A NAME
+without a value is set to the empty list, hiding an outer variable.`
+
+// A savedVar records the value a variable had in the topmost
+// scope of the environment before it has been declared local.
+type savedVar struct {
+	value []string
+	ok    bool
+}
+
+func (cl *CmdLine) localCmd(_ Context, arg []string) error {
+	f := cl.funcFrame()
+	if f == nil {
+		return errors.New("not within a function")
+	}
+	stk := cl.env.stack
+	top := stk[len(stk)-1]
+	for _, a := range arg[1:] {
+		name := a
+		var value []string
+		if i := strings.IndexByte(a, '='); i != -1 {
+			name, value = a[:i], []string{a[i+1:]}
+		}
+		if name == "" {
+			return errors.New("missing variable name")
+		}
+		if f.locals == nil {
+			f.locals = make(map[string]savedVar, 4)
+		}
+		if _, ok := f.locals[name]; !ok {
+			v, ok := top[name]
+			f.locals[name] = savedVar{value: v, ok: ok}
+		}
+		top[name] = value
+		cl.env.notify("local", name, value)
+	}
+	return nil
+}
+
+// funcFrame returns the input stack entry of
+// the innermost function call, or nil.
+func (cl *CmdLine) funcFrame() *stackEntry {
+	if cl.cur.isFunc {
+		return &cl.cur
+	}
+	for i := len(cl.inputStack) - 1; i >= 0; i-- {
+		if cl.inputStack[i].isFunc {
+			return &cl.inputStack[i]
+		}
+	}
+	return nil
+}
+
+// restoreLocals reinstates the values variables had
+// before they have been declared local.
+func (cl *CmdLine) restoreLocals(locals map[string]savedVar) {
+	stk := cl.env.stack
+	top := stk[len(stk)-1]
+	for name, v := range locals {
+		if v.ok {
+			top[name] = v.value
+		} else {
+			delete(top, name)
+		}
+		cl.env.notify("restore", name, v.value)
+	}
+}
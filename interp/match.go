@@ -0,0 +1,29 @@
+package interp
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const regexMatchHelp = `Returns success if subject matches any of the regular
+expressions. On a match, the variable match is set to the
+capture groups of the matching expression, so that they can
+be referred to as $match(1), $match(2), ...; the arguments
+$* are left unchanged.`
+
+func (cl *CmdLine) regexMatchCmd(_ Context, arg []string) error {
+	subject := arg[1]
+	for _, expr := range arg[2:] {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("invalid regular expression: %w", err)
+		}
+		m := re.FindStringSubmatch(subject)
+		if m == nil {
+			continue
+		}
+		cl.env.set("match", m[1:])
+		return nil
+	}
+	return errFalse
+}
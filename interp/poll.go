@@ -0,0 +1,71 @@
+package interp
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/knieriem/text"
+)
+
+const pollHelp = `Run CMD repeatedly, waiting for the duration specified
+by -d (default: 1s) between runs, until its output is the same
+for two consecutive runs. If -c is specified, run CMD until its
+output changes instead. The output of a run is written if it
+differs from the output of the previous run.`
+
+// pollState captures the output of the runs of a command
+// polled by poll-until-stable.
+type pollState struct {
+	w           text.Writer
+	buf         bytes.Buffer
+	prev        []byte
+	n           int
+	untilChange bool
+}
+
+// done compares the output of the latest run with the
+// output of the previous one, and reports whether polling
+// should stop.
+func (p *pollState) done() bool {
+	out := p.buf.Bytes()
+	same := p.n > 0 && bytes.Equal(out, p.prev)
+	if !same {
+		p.w.Write(out)
+	}
+	p.prev = append(p.prev[:0], out...)
+	p.buf.Reset()
+	p.n++
+	if p.n == 1 {
+		return false
+	}
+	return same != p.untilChange
+}
+
+func initPollFlags(f *flag.FlagSet) {
+	f.Bool("c", false, "run until the output changes")
+	f.Duration("d", time.Second, "pause between runs")
+}
+
+func (cl *CmdLine) pollCmd(ctx Context, arg []string) error {
+	d := flagValue(ctx, "d").(time.Duration)
+	if d <= 0 {
+		return errors.New("-d: duration must be positive")
+	}
+	p := &pollState{w: extractWriter(ctx)}
+	p.untilChange = flagValue(ctx, "c").(bool)
+	cmd, err := cl.ParseCmd(arg[1:])
+	if err != nil {
+		return err
+	}
+	pause := "sleep " + d.String() + "\n"
+	rewind := func() io.ReadCloser {
+		return ioutil.NopCloser(strings.NewReader(pause + cmd))
+	}
+	r := &repetition{poll: p}
+	return cl.pushStack(ioutil.NopCloser(strings.NewReader(cmd)), r, rewind, cl.newWriter(&p.buf))
+}
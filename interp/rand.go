@@ -0,0 +1,70 @@
+package interp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"strconv"
+)
+
+const randHelp = `Print a random value:
+	int MIN MAX	an integer in the range [MIN, MAX]
+	hex N	N random bytes, hex encoded
+	uuid	a version 4 UUID`
+
+var errRandUsage = errors.New("usage: rand int MIN MAX | hex N | uuid")
+
+func randCmd(ctx Context, arg []string) error {
+	switch arg[1] {
+	case "int":
+		if len(arg) != 4 {
+			return errRandUsage
+		}
+		min, err := strconv.ParseInt(arg[2], 0, 64)
+		if err != nil {
+			return err
+		}
+		max, err := strconv.ParseInt(arg[3], 0, 64)
+		if err != nil {
+			return err
+		}
+		if max < min {
+			return errors.New("MAX is less than MIN")
+		}
+		n := new(big.Int).Sub(big.NewInt(max), big.NewInt(min))
+		n, err = rand.Int(rand.Reader, n.Add(n, big.NewInt(1)))
+		if err != nil {
+			return err
+		}
+		_, err = ctx.Println(n.Add(n, big.NewInt(min)).String())
+		return err
+	case "hex":
+		if len(arg) != 3 {
+			return errRandUsage
+		}
+		n, err := strconv.ParseUint(arg[2], 10, 16)
+		if err != nil {
+			return err
+		}
+		b := make([]byte, n)
+		if _, err = rand.Read(b); err != nil {
+			return err
+		}
+		_, err = ctx.Println(hex.EncodeToString(b))
+		return err
+	case "uuid":
+		if len(arg) != 2 {
+			return errRandUsage
+		}
+		var u [16]byte
+		if _, err := rand.Read(u[:]); err != nil {
+			return err
+		}
+		u[6] = u[6]&0x0f | 0x40 // version 4
+		u[8] = u[8]&0x3f | 0x80 // RFC 4122 variant
+		_, err := ctx.Printf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+		return err
+	}
+	return errRandUsage
+}
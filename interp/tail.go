@@ -0,0 +1,89 @@
+package interp
+
+import (
+	"bufio"
+	"flag"
+	"io"
+	"time"
+)
+
+const tailHelp = `Print the last N (default: 10) lines of FILE. If -f is
+specified, wait for further lines appended to the file, and print
+them, until interrupted.`
+
+// tailPollInterval is the time tail -f waits before trying
+// to read further content, after the end of the file has
+// been reached.
+var tailPollInterval = 250 * time.Millisecond
+
+func initTailFlags(f *flag.FlagSet) {
+	f.Uint("n", 10, "number of lines")
+	f.Bool("f", false, "follow")
+}
+
+func (cl *CmdLine) tailCmd(ctx Context, arg []string) error {
+	n := int(flagValue(ctx, "n").(uint))
+	follow := flagValue(ctx, "f").(bool)
+	f, err := cl.Open(arg[1])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var lines []string
+	partial := ""
+	for {
+		s, err := r.ReadString('\n')
+		if err == io.EOF {
+			partial = s
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			if len(lines) == n {
+				lines = lines[1:]
+			}
+			lines = append(lines, s[:len(s)-1])
+		}
+	}
+	if !follow && partial != "" && n > 0 {
+		if len(lines) == n {
+			lines = lines[1:]
+		}
+		lines = append(lines, partial)
+	}
+	for _, s := range lines {
+		if _, err := ctx.Println(s); err != nil {
+			return err
+		}
+	}
+	if !follow {
+		return nil
+	}
+
+	t := time.NewTicker(tailPollInterval)
+	defer t.Stop()
+	for {
+		s, err := r.ReadString('\n')
+		partial += s
+		switch err {
+		case nil:
+			if _, err := ctx.Println(partial[:len(partial)-1]); err != nil {
+				return err
+			}
+			partial = ""
+			continue
+		case io.EOF:
+		default:
+			return err
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package interp
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	gioutil "github.com/knieriem/g/ioutil"
+	"github.com/knieriem/text"
+	"github.com/knieriem/text/rc"
+)
+
+var ErrUnknownSignal = errors.New("unknown signal")
+
+// trapSignals contains the names of conditions a trap
+// may be registered for.
+var trapSignals = map[string]bool{
+	"INT":  true, // an interrupt
+	"EXIT": true, // termination of Process
+}
+
+func (cl *CmdLine) trapCmd(ctx Context, arg []string) error {
+	switch len(arg) {
+	case 1:
+		cl.dumpTraps(extractWriter(ctx))
+		return nil
+	case 2:
+		if isSignalName(arg[1]) {
+			if !trapSignals[arg[1]] {
+				return ErrUnknownSignal
+			}
+			delete(cl.traps, arg[1])
+			return nil
+		}
+		if arg[1] != "{" {
+			return cl.setTrap(defaultTrapSignal, trapCmdText(arg[1:]))
+		}
+		block, sig, err := cl.scanBlockTail(true)
+		if err != nil {
+			return errors.New("error while parsing trap block: " + err.Error())
+		}
+		if sig == "" {
+			sig = defaultTrapSignal
+		}
+		return cl.setTrap(sig, block)
+	}
+	sig := arg[len(arg)-1]
+	f := arg[1 : len(arg)-1]
+	if !isSignalName(sig) {
+		sig = defaultTrapSignal
+		f = arg[1:]
+	}
+	if n := len(f); n > 1 && f[0] == "{" && f[n-1] == "}" {
+		f = f[1 : n-1]
+	}
+	if len(f) == 0 {
+		return ErrWrongNArg
+	}
+	return cl.setTrap(sig, trapCmdText(f))
+}
+
+// defaultTrapSignal is used if no signal is specified.
+const defaultTrapSignal = "INT"
+
+// isSignalName reports whether s looks like the name of a
+// signal, i.e. consists of upper-case letters, regardless of
+// whether it is known.
+func isSignalName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < 'A' || s[i] > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// trapCmdText converts the arguments of trap into command text.
+// A single argument is used verbatim, so that a quoted
+// string may contain a complete command line.
+func trapCmdText(f []string) string {
+	if len(f) == 1 {
+		return "\t" + f[0] + "\n"
+	}
+	return "\t" + rc.JoinCmd(f) + "\n"
+}
+
+func (cl *CmdLine) setTrap(sig, cmds string) error {
+	if !trapSignals[sig] {
+		return ErrUnknownSignal
+	}
+	if cl.traps == nil {
+		cl.traps = make(map[string]string, len(trapSignals))
+	}
+	cl.traps[sig] = cmds
+	return nil
+}
+
+func (cl *CmdLine) dumpTraps(w text.Writer) {
+	sigs := make([]string, 0, len(cl.traps))
+	for sig := range cl.traps {
+		sigs = append(sigs, sig)
+	}
+	sort.Strings(sigs)
+	for _, sig := range sigs {
+		fmt.Fprintln(w, "trap {")
+		inw := gioutil.NewIndentWriter(w, []byte{'\t'})
+		fmt.Fprint(inw, cl.traps[sig])
+		fmt.Fprintln(w, "}", sig)
+	}
+}
+
+// runTrap executes the commands registered for sig, if any,
+// using a fresh context, and the writer w. The trap is removed
+// before, so it needs to be registered again by the commands
+// if it shall stay in effect. The state of the input stack is
+// saved, and restored afterwards.
+func (cl *CmdLine) runTrap(sig string, w text.Writer) {
+	cmds, ok := cl.traps[sig]
+	if !ok {
+		return
+	}
+	delete(cl.traps, sig)
+
+	cur, stk := cl.cur, cl.inputStack
+	prompt, lastOk, exit := cl.Prompt, cl.lastOk, cl.exitFlag
+
+	// The commands are pushed on top of an empty input, so that
+	// an interrupt, which unwinds the input stack, aborts the trap.
+	cl.cur = stackEntry{
+		lineReader: cl.newLineReader(ioutil.NopCloser(strings.NewReader(""))),
+		w:          w,
+	}
+	cl.cmdLineReader = cl.cur.lineReader
+	cl.inputStack = nil
+	cl.Prompt = ""
+	cl.exitFlag = false
+	cl.pushStringStack(cmds, w)
+
+	err := cl.run()
+	if err != nil && err != ErrLastCmdFailed {
+		cl.setError(&FnError{Fn: "trap " + sig, err: err})
+	}
+	cl.popStackAll()
+
+	cl.cur, cl.inputStack = cur, stk
+	cl.cmdLineReader = cl.cur.lineReader
+	cl.Prompt, cl.lastOk = prompt, lastOk
+	cl.exitFlag = cl.exitFlag || exit
+}
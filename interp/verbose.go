@@ -0,0 +1,58 @@
+package interp
+
+import (
+	"strconv"
+)
+
+// Verbosity levels, as set using WithVerbosity,
+// or the `verbose' command.
+const (
+	// VerbosityQuiet suppresses informational messages,
+	// like the job number printed when a job is started.
+	VerbosityQuiet = 0
+
+	// VerbosityNormal is the default level.
+	VerbosityNormal = 1
+
+	// VerbosityTrace additionally prints each command before
+	// it is executed, like flag x, which is not modified though.
+	VerbosityTrace = 2
+)
+
+const verboseHelp = `Set the verbosity level, or print it if LEVEL is omitted:
+	0	quiet: do not print informational messages
+	1	normal
+	2	trace: print commands before executing them, like flag x`
+
+// WithVerbosity sets the initial verbosity level.
+func WithVerbosity(level int) Option {
+	return func(cl *CmdLine) {
+		cl.setVerbosity(level)
+	}
+}
+
+// Verbosity returns the current verbosity level, which
+// commands may consult to decide how much to report.
+func (cl *CmdLine) Verbosity() int {
+	return cl.verbosity
+}
+
+func (cl *CmdLine) setVerbosity(level int) {
+	cl.verbosity = level
+}
+
+func (cl *CmdLine) verboseCmd(ctx Context, arg []string) error {
+	if len(arg) == 1 {
+		_, err := ctx.Println(cl.verbosity)
+		return err
+	}
+	level, err := strconv.Atoi(arg[1])
+	if err != nil {
+		return err
+	}
+	if level < VerbosityQuiet {
+		level = VerbosityQuiet
+	}
+	cl.setVerbosity(level)
+	return nil
+}
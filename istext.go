@@ -1,20 +1,105 @@
 package text
 
 import (
+	"io"
 	"unicode/utf8"
 )
 
+// IsTextOptions specifies which control characters, in addition
+// to '\n', '\r', '\t', and '\f', are accepted as text.
+type IsTextOptions struct {
+	// AllowC1 accepts the characters from 0x7F to 0x9F,
+	// i.e. DEL and the C1 control characters.
+	AllowC1 bool
+
+	// AllowEscape accepts ESC (0x1B), as used by ANSI
+	// escape sequences.
+	AllowEscape bool
+
+	// ExtraChars lists further C0 control characters to accept.
+	ExtraChars []rune
+}
+
 // IsText returns true if bytes in b form valid UTF-8 characters, and
 // if b doesn't contain any unprintable ASCII or Unicode characters.
 func IsText(b []byte, extraChars []rune) bool {
-	for len(b) > 0 && utf8.FullRune(b) {
-		r, size := utf8.DecodeRune(b)
+	ok, _ := IsTextAt(b, extraChars)
+	return ok
+}
+
+// IsTextWithOptions is like IsText, but the control characters
+// accepted are specified by opts.
+func IsTextWithOptions(b []byte, opts IsTextOptions) bool {
+	ok, _ := isTextPrefix(b, &opts)
+	return ok
+}
+
+// IsTextAt is like IsText, but additionally returns the byte offset
+// of the first character that is not allowed, or -1 if b is text.
+func IsTextAt(b []byte, extraChars []rune) (ok bool, offset int) {
+	ok, n := isTextPrefix(b, &IsTextOptions{ExtraChars: extraChars})
+	if ok {
+		return true, -1
+	}
+	return false, n
+}
+
+// IsTextReader is like IsText, but reads its input from r, examining
+// at most maxBytes bytes. If maxBytes is zero or negative, r is read
+// until EOF. A character split across reads is decoded as a whole.
+// The error returned is the first read error other than io.EOF;
+// if r repeatedly returns no data and no error, io.ErrNoProgress
+// is returned.
+func IsTextReader(r io.Reader, extraChars []rune, maxBytes int) (bool, error) {
+	opts := &IsTextOptions{ExtraChars: extraChars}
+	buf := make([]byte, 4096)
+	n := 0 // number of pending bytes at the start of buf
+	total := 0
+	empty := 0
+	for maxBytes <= 0 || total < maxBytes {
+		p := buf[n:]
+		if maxBytes > 0 && len(p) > maxBytes-total {
+			p = p[:maxBytes-total]
+		}
+		nr, err := r.Read(p)
+		total += nr
+		n += nr
+		ok, used := isTextPrefix(buf[:n], opts)
+		if !ok {
+			return false, nil
+		}
+		n = copy(buf, buf[used:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false, err
+		}
+		if nr > 0 {
+			empty = 0
+		} else if empty++; empty == maxConsecutiveEmptyReads {
+			return false, io.ErrNoProgress
+		}
+	}
+	return true, nil
+}
+
+// maxConsecutiveEmptyReads limits the number of reads in a row
+// returning neither data nor an error, like in package bufio.
+const maxConsecutiveEmptyReads = 100
+
+// isTextPrefix checks the complete characters at the start of b,
+// and returns the number of bytes examined. An incomplete character
+// at the end of b is left for a later call.
+func isTextPrefix(b []byte, opts *IsTextOptions) (ok bool, n int) {
+	for len(b[n:]) > 0 && utf8.FullRune(b[n:]) {
+		r, size := utf8.DecodeRune(b[n:])
 		if size == 1 && r == utf8.RuneError {
 			// decoding error
-			return false
+			return false, n
 		}
-		if 0x7F <= r && r <= 0x9F {
-			return false
+		if 0x7F <= r && r <= 0x9F && !opts.AllowC1 {
+			return false, n
 		}
 		if r < ' ' {
 		S:
@@ -22,16 +107,19 @@ func IsText(b []byte, extraChars []rune) bool {
 			case '\n', '\r', '\t', '\f':
 				// okay
 			default:
-				for _, c := range extraChars {
+				if r == 0x1B && opts.AllowEscape {
+					break S
+				}
+				for _, c := range opts.ExtraChars {
 					if r == c {
 						break S
 					}
 				}
 				// binary garbage
-				return false
+				return false, n
 			}
 		}
-		b = b[size:]
+		n += size
 	}
-	return true
+	return true, n
 }
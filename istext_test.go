@@ -0,0 +1,108 @@
+package text
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+// emptyReader returns neither data nor an error.
+type emptyReader struct {
+	n int
+}
+
+func (r *emptyReader) Read([]byte) (int, error) {
+	r.n++
+	return 0, nil
+}
+
+func TestIsTextAt(t *testing.T) {
+	tests := []struct {
+		input  string
+		extra  []rune
+		offset int
+	}{
+		{"plain text\n", nil, -1},
+		{"", nil, -1},
+		{"ä\x00", nil, 2},
+		{"ab\x07c", nil, 2},
+		{"ab\x07c", []rune{7}, -1},
+		{"x\xffy", nil, 1},
+		{"€\u0085", nil, 3},
+		{"a\xe2\x82", nil, -1}, // incomplete character at the end
+	}
+	for i, test := range tests {
+		ok, offset := IsTextAt([]byte(test.input), test.extra)
+		if ok != (test.offset == -1) || offset != test.offset {
+			t.Errorf("[%d] %q: got %v, %d, want offset %d", i, test.input, ok, offset, test.offset)
+		}
+		if IsText([]byte(test.input), test.extra) != ok {
+			t.Errorf("[%d] %q: IsText differs from IsTextAt", i, test.input)
+		}
+	}
+}
+
+func TestIsTextWithOptions(t *testing.T) {
+	tests := []struct {
+		input string
+		opts  IsTextOptions
+		ok    bool
+	}{
+		{"a\u0085b", IsTextOptions{}, false},
+		{"a\u0085b", IsTextOptions{AllowC1: true}, true},
+		{"a\x7fb", IsTextOptions{AllowC1: true}, true},
+		{"\x1b[1mbold", IsTextOptions{}, false},
+		{"\x1b[1mbold", IsTextOptions{AllowEscape: true}, true},
+		{"\x1b[1mbold", IsTextOptions{ExtraChars: []rune{0x1B}}, true},
+		{"\x1b[1m\x07", IsTextOptions{AllowEscape: true}, false},
+		{"\x1b[1m\x07", IsTextOptions{AllowEscape: true, ExtraChars: []rune{7}}, true},
+		{"a\xffb", IsTextOptions{AllowC1: true, AllowEscape: true}, false},
+		{"a\x00", IsTextOptions{AllowC1: true, AllowEscape: true}, false},
+	}
+	for i, test := range tests {
+		if ok := IsTextWithOptions([]byte(test.input), test.opts); ok != test.ok {
+			t.Errorf("[%d] %q: got %v, want %v", i, test.input, ok, test.ok)
+		}
+	}
+	if IsTextWithOptions([]byte("a\x07"), IsTextOptions{ExtraChars: []rune{7}}) != IsText([]byte("a\x07"), []rune{7}) {
+		t.Error("IsTextWithOptions differs from IsText")
+	}
+}
+
+func TestIsTextReader(t *testing.T) {
+	text := []byte("größer\tals\r\n")
+	for _, r := range []io.Reader{
+		bytes.NewReader(text),
+		iotest.OneByteReader(bytes.NewReader(text)),
+		iotest.DataErrReader(bytes.NewReader(text)),
+	} {
+		if ok, err := IsTextReader(r, nil, 0); !ok || err != nil {
+			t.Errorf("text not detected: %v, %v", ok, err)
+		}
+	}
+
+	bin := append(append([]byte{}, text...), 0)
+	if ok, err := IsTextReader(iotest.OneByteReader(bytes.NewReader(bin)), nil, 0); ok || err != nil {
+		t.Errorf("binary data not detected: %v, %v", ok, err)
+	}
+	if ok, err := IsTextReader(bytes.NewReader(bin), nil, len(text)); !ok || err != nil {
+		t.Errorf("maxBytes not respected: %v, %v", ok, err)
+	}
+
+	r := iotest.TimeoutReader(bytes.NewReader(text))
+	if _, err := IsTextReader(iotest.OneByteReader(r), nil, 0); err != iotest.ErrTimeout {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestIsTextReaderNoProgress(t *testing.T) {
+	r := new(emptyReader)
+	ok, err := IsTextReader(r, nil, 0)
+	if ok || err != io.ErrNoProgress {
+		t.Errorf("unexpected result: %v, %v", ok, err)
+	}
+	if r.n != maxConsecutiveEmptyReads {
+		t.Errorf("unexpected number of reads: %d", r.n)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"io"
 
+	"github.com/knieriem/text"
 	"github.com/knieriem/text/line"
 )
 
@@ -22,11 +23,37 @@ type File struct {
 	UnassociatedErrors []error
 }
 
-func ReadLines(r io.Reader) (af *File, err error) {
+// A ReadOption modifies the behaviour of ReadLines.
+type ReadOption func(*readConfig)
+
+type readConfig struct {
+	stripBOM bool
+}
+
+// StripUtf8BOM makes ReadLines remove a UTF-8 byte order mark
+// at the start of the first line, like the corresponding field
+// of tidata.Reader.
+func StripUtf8BOM() ReadOption {
+	return func(c *readConfig) {
+		c.stripBOM = true
+	}
+}
+
+// ReadLines reads the lines of r into a new File. By default,
+// the lines are stored as they are; see StripUtf8BOM.
+func ReadLines(r io.Reader, opts ...ReadOption) (af *File, err error) {
+	var conf readConfig
+	for _, o := range opts {
+		o(&conf)
+	}
 	af = new(File)
-	s := bufio.NewScanner(r)
+	s := text.NewLineScanner(bufio.NewScanner(r))
 	for s.Scan() {
-		af.Lines = append(af.Lines, Line{Text: s.Text()})
+		t := s.Text()
+		if s.LineNum() == 1 && conf.stripBOM {
+			t = text.StripBOM(t)
+		}
+		af.Lines = append(af.Lines, Line{Text: t})
 	}
 	af.Start = 1
 	err = s.Err()
@@ -51,6 +78,20 @@ func (af *File) AssociateErrors(list []error) {
 	}
 }
 
+// ErrorsAt returns the errors associated with the line
+// specified by the 1-based lineNum. It returns nil if lineNum
+// is out of range.
+func (af *File) ErrorsAt(lineNum int) []line.Error {
+	i := lineNum - af.Start
+	if i < 0 || i >= len(af.Lines) {
+		return nil
+	}
+	return af.Lines[i].Errors
+}
+
+// Chunks returns the sections of the file containing lines with
+// errors, each surrounded by up to nContext lines of context.
+// Sections that would overlap or abut are merged.
 func (af *File) Chunks(nContext int) (chunks []Chunk) {
 	iErrPrev := -1
 	i0 := 0
@@ -65,7 +106,7 @@ func (af *File) Chunks(nContext int) (chunks []Chunk) {
 			}
 			iErrPrev = i
 		} else if iErrPrev == -1 {
-			if i-i0+1 == nContext {
+			if i-i0 == nContext {
 				i0++
 			}
 		}
@@ -77,5 +118,29 @@ func (af *File) Chunks(nContext int) (chunks []Chunk) {
 		}
 		chunks = append(chunks, Chunk{Start: af.Start + i0, Lines: af.Lines[i0:end]})
 	}
-	return
+	return MergeChunks(chunks)
+}
+
+// MergeChunks merges chunks whose line ranges overlap or abut,
+// so that no line is contained in more than one chunk.
+// The chunks must be sorted by Start.
+func MergeChunks(chunks []Chunk) []Chunk {
+	if len(chunks) < 2 {
+		return chunks
+	}
+	merged := make([]Chunk, 1, len(chunks))
+	merged[0] = chunks[0]
+	for _, c := range chunks[1:] {
+		last := &merged[len(merged)-1]
+		end := last.Start + len(last.Lines)
+		if c.Start > end {
+			merged = append(merged, c)
+			continue
+		}
+		if n := c.Start + len(c.Lines) - end; n > 0 {
+			lines := last.Lines[:len(last.Lines):len(last.Lines)]
+			last.Lines = append(lines, c.Lines[len(c.Lines)-n:]...)
+		}
+	}
+	return merged
 }
@@ -0,0 +1,178 @@
+package annotated
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/knieriem/text/line"
+)
+
+func TestReadLinesBOM(t *testing.T) {
+	src := "\uFEFFkey:\tvalue\n\uFEFFsecond\n"
+	af, err := ReadLines(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := af.Lines[0].Text; s != "\uFEFFkey:\tvalue" {
+		t.Errorf("BOM stripped by default: %q", s)
+	}
+
+	af, err = ReadLines(strings.NewReader(src), StripUtf8BOM())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(af.Lines) != 2 {
+		t.Fatalf("unexpected number of lines: %d", len(af.Lines))
+	}
+	if s := af.Lines[0].Text; s != "key:\tvalue" {
+		t.Errorf("BOM not stripped: %q", s)
+	}
+	if s := af.Lines[1].Text; s != "\uFEFFsecond" {
+		t.Errorf("BOM unexpectedly stripped from second line: %q", s)
+	}
+}
+
+func TestErrorsAt(t *testing.T) {
+	af, err := ReadLines(strings.NewReader("a\nb\nc\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	af.AssociateErrors([]error{
+		line.NewMsg(2, "first"),
+		line.NewMsg(2, "second"),
+		line.NewMsg(3, "third"),
+	})
+	list := af.ErrorsAt(2)
+	if len(list) != 2 {
+		t.Fatalf("unexpected number of errors at line 2: %d", len(list))
+	}
+	if list[0].Error() != "first" || list[1].Error() != "second" {
+		t.Errorf("unexpected errors: %v", list)
+	}
+	if list := af.ErrorsAt(1); len(list) != 0 {
+		t.Errorf("unexpected errors at line 1: %v", list)
+	}
+	for _, n := range []int{0, 4, -1} {
+		if list := af.ErrorsAt(n); list != nil {
+			t.Errorf("line %d: expected nil, got %v", n, list)
+		}
+	}
+}
+
+func TestChunks(t *testing.T) {
+	af, err := ReadLines(strings.NewReader("1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	af.AssociateErrors([]error{
+		line.NewMsg(4, "a"),
+		line.NewMsg(7, "b"),
+		line.NewMsg(13, "c"),
+	})
+	chunks := af.Chunks(2)
+	if len(chunks) != 2 {
+		t.Fatalf("unexpected number of chunks: %d", len(chunks))
+	}
+	for i, want := range []struct{ start, n int }{{2, 8}, {11, 4}} {
+		if c := chunks[i]; c.Start != want.start || len(c.Lines) != want.n {
+			t.Errorf("[%d] got start %d, %d lines; want %d, %d", i, c.Start, len(c.Lines), want.start, want.n)
+		}
+	}
+}
+
+// TestChunksLeadingContext checks that a chunk starts exactly
+// nContext lines before the first error, if available.
+func TestChunksLeadingContext(t *testing.T) {
+	af, err := ReadLines(strings.NewReader("1\n2\n3\n4\n5\n6\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		errLine, nContext int
+		start, n          int
+	}{
+		{5, 2, 3, 4},
+		{5, 0, 5, 1},
+		{5, 1, 4, 3},
+		{3, 2, 1, 5},
+		{2, 3, 1, 5},
+		{1, 2, 1, 3},
+	} {
+		for i := range af.Lines {
+			af.Lines[i].Errors = nil
+		}
+		af.AssociateErrors([]error{line.NewMsg(test.errLine, "e")})
+		chunks := af.Chunks(test.nContext)
+		if len(chunks) != 1 {
+			t.Errorf("line %d, context %d: got %d chunks", test.errLine, test.nContext, len(chunks))
+			continue
+		}
+		if c := chunks[0]; c.Start != test.start || len(c.Lines) != test.n {
+			t.Errorf("line %d, context %d: got start %d, %d lines; want %d, %d",
+				test.errLine, test.nContext, c.Start, len(c.Lines), test.start, test.n)
+		}
+	}
+}
+
+func TestMergeChunks(t *testing.T) {
+	af, err := ReadLines(strings.NewReader("1\n2\n3\n4\n5\n6\n7\n8\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk := func(start, end int) Chunk {
+		return Chunk{Start: start, Lines: af.Lines[start-1 : end]}
+	}
+	chunks := MergeChunks([]Chunk{chunk(1, 3), chunk(2, 4), chunk(5, 5), chunk(7, 8)})
+	if len(chunks) != 2 {
+		t.Fatalf("unexpected number of chunks: %d", len(chunks))
+	}
+	c := chunks[0]
+	if c.Start != 1 || len(c.Lines) != 5 || c.Lines[4].Text != "5" {
+		t.Errorf("unexpected first chunk: %+v", c)
+	}
+	if c := chunks[1]; c.Start != 7 || len(c.Lines) != 2 {
+		t.Errorf("unexpected second chunk: %+v", c)
+	}
+	if af.Lines[3].Text != "4" {
+		t.Errorf("lines of the file have been modified")
+	}
+}
+
+func TestWriteChunks(t *testing.T) {
+	af, err := ReadLines(strings.NewReader("a\nb\n\tc\nd\ne\nf\ng\nh\ni\nj\nk\nl\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	af.AssociateErrors([]error{
+		line.NewMsgCol(3, 2, "bad c"),
+		line.NewWarning(11, "odd k"),
+		errors.New("general"),
+	})
+	var b strings.Builder
+	if err := af.WriteChunks(&b, 1); err != nil {
+		t.Fatal(err)
+	}
+	want := `  2  b
+> 3  	c
+     	^ error: bad c
+  4  d
+...
+ 10  j
+>11  k
+     warning: odd k
+ 12  l
+error: general
+`
+	if b.String() != want {
+		t.Errorf("unexpected output:\n%s\nwant:\n%s", b.String(), want)
+	}
+
+	b.Reset()
+	if err := af.ColorWriteChunks(&b, 0, true); err != nil {
+		t.Fatal(err)
+	}
+	if s := b.String(); !strings.HasPrefix(s, "\x1b[31m>"+" 3  \tc\x1b[0m\n") || !strings.Contains(s, "\x1b[33m>11  k") {
+		t.Errorf("unexpected colored output: %q", s)
+	}
+}
@@ -0,0 +1,134 @@
+package annotated
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/knieriem/text/line"
+)
+
+// ANSI escape sequences used by ColorWriteChunks.
+const (
+	ansiReset  = "\x1b[0m"
+	ansiDim    = "\x1b[2m"
+	ansiRed    = "\x1b[31m"
+	ansiYellow = "\x1b[33m"
+	ansiBold   = "\x1b[1m"
+)
+
+// DefaultColor reports whether colored output should be used by
+// default. It returns false if the NO_COLOR environment variable
+// is set to a non-empty value, see https://no-color.org.
+func DefaultColor() bool {
+	return os.Getenv("NO_COLOR") == ""
+}
+
+// WriteChunks writes the chunks returned by Chunks(nContext) to w.
+// Each line is prefixed by its number; lines with errors are
+// marked by '>', and followed by their error messages. If an error
+// knows its column, a caret points to it. Chunks are separated
+// by a line containing "...". Unassociated errors are written
+// after the chunks.
+func (af *File) WriteChunks(w io.Writer, nContext int) error {
+	return af.ColorWriteChunks(w, nContext, false)
+}
+
+// ColorWriteChunks is like WriteChunks, but if color is true,
+// ANSI escape sequences are used to highlight lines with errors
+// in red (yellow, if they only have warnings), and to dim
+// context lines.
+func (af *File) ColorWriteChunks(w io.Writer, nContext int, color bool) error {
+	var b bytes.Buffer
+	esc := func(seq string) {
+		if color {
+			b.WriteString(seq)
+		}
+	}
+	chunks := af.Chunks(nContext)
+	width := 1
+	if n := len(chunks); n != 0 {
+		last := chunks[n-1]
+		width = len(strconv.Itoa(last.Start + len(last.Lines) - 1))
+	}
+	indent := strings.Repeat(" ", width+3)
+	for i, c := range chunks {
+		if i != 0 {
+			b.WriteString("...\n")
+		}
+		for j, l := range c.Lines {
+			if len(l.Errors) == 0 {
+				esc(ansiDim)
+				fmt.Fprintf(&b, " %*d  %s", width, c.Start+j, l.Text)
+				esc(ansiReset)
+				b.WriteByte('\n')
+				continue
+			}
+			esc(lineColor(l.Errors))
+			fmt.Fprintf(&b, ">%*d  %s", width, c.Start+j, l.Text)
+			esc(ansiReset)
+			b.WriteByte('\n')
+			for _, e := range l.Errors {
+				b.WriteString(indent)
+				if ce, ok := e.(line.ColumnError); ok && ce.Column() > 0 {
+					b.WriteString(caretIndent(l.Text, ce.Column()))
+					b.WriteString("^ ")
+				}
+				sev := line.SeverityOf(e)
+				esc(ansiBold + sevColor(sev))
+				b.WriteString(sev.String())
+				b.WriteString(": ")
+				b.WriteString(e.Error())
+				esc(ansiReset)
+				b.WriteByte('\n')
+			}
+		}
+	}
+	for _, e := range af.UnassociatedErrors {
+		sev := line.SeverityOf(e)
+		esc(ansiBold + sevColor(sev))
+		b.WriteString(sev.String())
+		b.WriteString(": ")
+		b.WriteString(e.Error())
+		esc(ansiReset)
+		b.WriteByte('\n')
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// lineColor returns the color for a line with the specified errors:
+// yellow, if all of them are warnings, red otherwise.
+func lineColor(list []line.Error) string {
+	for _, e := range list {
+		if line.SeverityOf(e) != line.SeverityWarning {
+			return ansiRed
+		}
+	}
+	return ansiYellow
+}
+
+func sevColor(sev line.Severity) string {
+	if sev == line.SeverityWarning {
+		return ansiYellow
+	}
+	return ansiRed
+}
+
+// caretIndent returns the white space needed to place a caret
+// below the byte at the 1-based column col of text.
+// Tabs are retained so that the caret lines up in the output.
+func caretIndent(text string, col int) string {
+	if col > len(text)+1 {
+		col = len(text) + 1
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '\t' {
+			return r
+		}
+		return ' '
+	}, text[:col-1])
+}
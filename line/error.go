@@ -1,8 +1,10 @@
 package line
 
 import (
+	"encoding/json"
 	"fmt"
 	"sort"
+	"strconv"
 )
 
 type Error interface {
@@ -10,6 +12,43 @@ type Error interface {
 	Line() int
 }
 
+// A ColumnError is an Error that additionally knows the column
+// it refers to. Columns are byte offsets into the line, counting
+// from 1; Column returns 0 if the column is unknown.
+type ColumnError interface {
+	Error
+	Column() int
+}
+
+// Severity distinguishes real errors from warnings, which
+// do not prevent processing from succeeding.
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	}
+	return "severity(" + strconv.Itoa(int(s)) + ")"
+}
+
+// SeverityOf returns the severity of err, as reported by its
+// Severity method. Errors without such a method are considered
+// to have SeverityError.
+func SeverityOf(err error) Severity {
+	if e, ok := err.(interface{ Severity() Severity }); ok {
+		return e.Severity()
+	}
+	return SeverityError
+}
+
 type ErrorList struct {
 	Filename string
 	List     []error
@@ -27,6 +66,74 @@ func (el *ErrorList) Error() (s string) {
 	return
 }
 
+// Messages returns the messages of all errors in the list,
+// formatted like the result of Error, and sorted by line.
+// The order of the list itself is not changed.
+func (el *ErrorList) Messages() []string {
+	list := el.sorted()
+	msgs := make([]string, len(list))
+	for i, err := range list {
+		if e, ok := err.(Error); ok {
+			msgs[i] = fmt.Sprintf("%d: %s", e.Line(), e.Error())
+		} else {
+			msgs[i] = err.Error()
+		}
+	}
+	return msgs
+}
+
+// sorted returns a copy of the list, stably sorted by line.
+func (el *ErrorList) sorted() []error {
+	list := make([]error, len(el.List))
+	copy(list, el.List)
+	sort.SliceStable(list, func(i, j int) bool {
+		return line(list[i]) < line(list[j])
+	})
+	return list
+}
+
+// Filter returns a new list containing those errors
+// for which keep returns true.
+func (el *ErrorList) Filter(keep func(error) bool) *ErrorList {
+	f := &ErrorList{Filename: el.Filename}
+	for _, err := range el.List {
+		if keep(err) {
+			f.List = append(f.List, err)
+		}
+	}
+	return f
+}
+
+type jsonError struct {
+	Filename string `json:"filename"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// MarshalJSON encodes the list as an array of objects containing
+// the filename, line, column, severity, and message of each error,
+// sorted by line. The column is omitted if unknown. The severity
+// is determined by SeverityOf, and encoded using Severity.String.
+func (el *ErrorList) MarshalJSON() ([]byte, error) {
+	errs := el.sorted()
+	list := make([]jsonError, len(errs))
+	for i, err := range errs {
+		je := &list[i]
+		je.Message = err.Error()
+		je.Filename = el.Filename
+		if e, ok := err.(Error); ok {
+			je.Line = e.Line()
+		}
+		if e, ok := err.(ColumnError); ok {
+			je.Column = e.Column()
+		}
+		je.Severity = SeverityOf(err).String()
+	}
+	return json.Marshal(list)
+}
+
 func (e *ErrorList) Add(err error) {
 	e.List = append(e.List, err)
 }
@@ -35,6 +142,22 @@ func (e *ErrorList) AddMsg(line int, msg string) {
 	e.List = append(e.List, &message{msg: msg, line: line})
 }
 
+// AddWarning adds a message with SeverityWarning.
+func (e *ErrorList) AddWarning(line int, msg string) {
+	e.List = append(e.List, NewWarning(line, msg))
+}
+
+// HasErrors reports whether the list contains errors
+// other than warnings.
+func (e *ErrorList) HasErrors() bool {
+	for _, err := range e.List {
+		if SeverityOf(err) != SeverityWarning {
+			return true
+		}
+	}
+	return false
+}
+
 func (e *ErrorList) AddError(line int, err error) {
 	e.List = append(e.List, &lineError{error: err, line: line})
 }
@@ -53,12 +176,24 @@ func (list *ErrorList) Sort() {
 type message struct {
 	msg  string
 	line int
+	col  int
+	sev  Severity
 }
 
 func NewMsg(lineNum int, m string) *message {
 	return &message{msg: m, line: lineNum}
 }
 
+func NewMsgCol(lineNum, col int, m string) *message {
+	return &message{msg: m, line: lineNum, col: col}
+}
+
+// NewWarning is like NewMsg, but the message
+// has SeverityWarning.
+func NewWarning(lineNum int, m string) *message {
+	return &message{msg: m, line: lineNum, sev: SeverityWarning}
+}
+
 func (m *message) Error() string {
 	return m.msg
 }
@@ -67,19 +202,36 @@ func (m *message) Line() int {
 	return m.line
 }
 
+func (m *message) Column() int {
+	return m.col
+}
+
+func (m *message) Severity() Severity {
+	return m.sev
+}
+
 type lineError struct {
 	error
 	line int
+	col  int
 }
 
 func NewError(lineNum int, err error) *lineError {
 	return &lineError{error: err, line: lineNum}
 }
 
+func NewErrorCol(lineNum, col int, err error) *lineError {
+	return &lineError{error: err, line: lineNum, col: col}
+}
+
 func (e *lineError) Line() int {
 	return e.line
 }
 
+func (e *lineError) Column() int {
+	return e.col
+}
+
 // implementation of sort.Interface
 func (e *ErrorList) Len() int {
 	return len(e.List)
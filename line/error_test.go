@@ -0,0 +1,76 @@
+package line
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestErrorListMessages(t *testing.T) {
+	el := &ErrorList{Filename: "a.conf"}
+	el.AddMsg(5, "second")
+	el.Add(NewMsgCol(2, 3, "first"))
+	el.Add(errors.New("no line"))
+
+	msgs := el.Messages()
+	want := []string{"no line", "2: first", "5: second"}
+	if len(msgs) != len(want) {
+		t.Fatalf("unexpected messages: %q", msgs)
+	}
+	for i := range want {
+		if msgs[i] != want[i] {
+			t.Errorf("[%d] %q != %q", i, msgs[i], want[i])
+		}
+	}
+	if s := el.Error(); s != "5: second" {
+		t.Errorf("Error changed: %q", s)
+	}
+
+	f := el.Filter(func(err error) bool {
+		_, ok := err.(Error)
+		return ok
+	})
+	if len(f.List) != 2 || f.Filename != "a.conf" {
+		t.Errorf("unexpected filter result: %v", f.List)
+	}
+
+	b, err := json.Marshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := `[{"filename":"a.conf","line":2,"column":3,"severity":"error","message":"first"},{"filename":"a.conf","line":5,"severity":"error","message":"second"}]`
+	if string(b) != s {
+		t.Errorf("unexpected JSON: %s", b)
+	}
+}
+
+func TestErrorListJSONFilename(t *testing.T) {
+	el := ErrInsertFilename(NewError(3, errors.New("bad value")), "b.conf")
+	b, err := json.Marshal(el)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := `[{"filename":"b.conf","line":3,"severity":"error","message":"bad value"}]`
+	if string(b) != s {
+		t.Errorf("unexpected JSON: %s", b)
+	}
+}
+
+func TestErrorListSeverity(t *testing.T) {
+	el := new(ErrorList)
+	el.AddWarning(1, "unused")
+	if el.HasErrors() {
+		t.Error("warning counted as error")
+	}
+	b, err := json.Marshal(el)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := `[{"filename":"","line":1,"severity":"warning","message":"unused"}]`; string(b) != s {
+		t.Errorf("unexpected JSON: %s", b)
+	}
+	el.Add(errors.New("failed"))
+	if !el.HasErrors() {
+		t.Error("error not detected")
+	}
+}
@@ -0,0 +1,146 @@
+package rc
+
+import (
+	"strings"
+)
+
+// expandBraces performs brace expansion on the last element of fields,
+// which has been parsed from word: a word like "file.{c,h}" is replaced
+// by the words "file.c file.h", which are tokenized separately. Multiple
+// brace groups within a word produce the cartesian product of their
+// alternatives. A brace group without a comma at its top level, and
+// unbalanced braces are left as they are.
+func (tok *Tokenizer) expandBraces(fields groupToken, word string) groupToken {
+	if strings.IndexByte(word, '{') == -1 {
+		return fields
+	}
+	words := expandWord(word)
+	if len(words) == 1 && words[0] == word {
+		return fields
+	}
+	sub := *tok
+	sub.inList = true
+	var list groupToken
+	for _, w := range words {
+		t, _, err := sub.do(w, true)
+		if err != nil {
+			return fields
+		}
+		list = append(list, t...)
+	}
+	return append(fields[:len(fields)-1], list...)
+}
+
+// isRedirOp reports whether t is a redirection operator
+// that is followed by a separate word naming its target.
+func isRedirOp(t token) bool {
+	r, ok := t.(*redirToken)
+	return ok && r.target == ""
+}
+
+// mapWords replaces each word of a command line by the result of fn.
+// Words are separated by unquoted white-space; they are passed to fn
+// including any quotes. Processing stops at the start of a comment.
+func mapWords(s string, fn func(word string) string) string {
+	var b strings.Builder
+	quoting := false
+	i0 := -1
+	flush := func(i int) {
+		if i0 != -1 {
+			b.WriteString(fn(s[i0:i]))
+			i0 = -1
+		}
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\'' {
+			quoting = !quoting
+		} else if !quoting {
+			switch c {
+			case ' ', '\t', '\r', '\n':
+				flush(i)
+				b.WriteByte(c)
+				continue
+			case '#':
+				if i == 0 || s[i-1] != '$' {
+					flush(i)
+					b.WriteString(s[i:])
+					return b.String()
+				}
+			}
+		}
+		if i0 == -1 {
+			i0 = i
+		}
+	}
+	flush(len(s))
+	return b.String()
+}
+
+// expandWord expands the first suitable brace group of w,
+// and recursively the words resulting from it.
+func expandWord(w string) []string {
+	quoting := false
+	for i := 0; i < len(w); i++ {
+		switch w[i] {
+		case '\'':
+			quoting = !quoting
+		case '{':
+			if quoting {
+				continue
+			}
+			alts, end := braceAlternatives(w[i:])
+			if alts == nil {
+				continue
+			}
+			prefix, suffix := w[:i], w[i+end:]
+			var list []string
+			for _, alt := range alts {
+				for _, e := range expandWord(prefix + alt + suffix) {
+					if e != "" {
+						list = append(list, e)
+					}
+				}
+			}
+			return list
+		}
+	}
+	return []string{w}
+}
+
+// braceAlternatives splits the brace group at the start of s
+// at its top-level commas. It returns the alternatives, and the
+// length of the group, or nil, if the group is unbalanced,
+// or doesn't contain a comma at its top level.
+func braceAlternatives(s string) (alts []string, n int) {
+	depth := 0
+	quoting := false
+	i0 := 1
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\'' {
+			quoting = !quoting
+		}
+		if quoting {
+			continue
+		}
+		switch c {
+		case '{':
+			depth++
+		case ',':
+			if depth == 1 {
+				alts = append(alts, s[i0:i])
+				i0 = i + 1
+			}
+		case '}':
+			depth--
+			if depth == 0 {
+				if alts == nil {
+					return nil, 0
+				}
+				return append(alts, s[i0:i]), i + 1
+			}
+		}
+	}
+	return nil, 0
+}
@@ -2,6 +2,7 @@ package rc
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"sort"
@@ -17,8 +18,89 @@ func (m EnvMap) Insert(src EnvMap) {
 	}
 }
 
+// Clone returns a deep copy of m.
+func (m EnvMap) Clone() EnvMap {
+	if m == nil {
+		return nil
+	}
+	c := make(EnvMap, len(m))
+	for name, val := range m {
+		if val != nil {
+			val = append(make([]string, 0, len(val)), val...)
+		}
+		c[name] = val
+	}
+	return c
+}
+
+// Diff compares m with other, which is considered the newer
+// version of the map. It returns the variables only present in
+// other, those present in both maps, but with different values,
+// holding the values from other, and those missing in other,
+// holding the values from m. The returned maps share no value
+// slices with m or other.
+func (m EnvMap) Diff(other EnvMap) (added, changed, removed EnvMap) {
+	added = make(EnvMap)
+	changed = make(EnvMap)
+	removed = make(EnvMap)
+	for name, val := range other {
+		old, ok := m[name]
+		switch {
+		case !ok:
+			added[name] = val
+		case !equalValues(old, val):
+			changed[name] = val
+		}
+	}
+	for name, val := range m {
+		if _, ok := other[name]; !ok {
+			removed[name] = val
+		}
+	}
+	return added.Clone(), changed.Clone(), removed.Clone()
+}
+
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 type EnvStack []EnvMap
 
+// Clone returns a deep copy of s, which may be modified
+// without affecting s.
+func (s EnvStack) Clone() EnvStack {
+	if s == nil {
+		return nil
+	}
+	c := make(EnvStack, len(s))
+	for i, m := range s {
+		c[i] = m.Clone()
+	}
+	return c
+}
+
+// Snapshot captures the current state of s. It returns a deep copy,
+// that won't be affected by later modifications of s, and that
+// can be reinstated using Restore.
+func (s EnvStack) Snapshot() EnvStack {
+	return s.Clone()
+}
+
+// Restore replaces the contents of s by a copy of snap, which has
+// been created by Snapshot. Since snap is copied, it may be used
+// to restore the same state multiple times.
+func (s *EnvStack) Restore(snap EnvStack) {
+	*s = snap.Clone()
+}
+
 // Get the value of a variable from the topmost EnvMap of s.
 func (s EnvStack) Get(name string) (value []string) {
 	for i := s.iLast(); i >= 0; i-- {
@@ -67,6 +149,28 @@ func (s EnvStack) Insert(m EnvMap) {
 	}
 }
 
+// ParseEnv parses a string of assignments, as written by WriteTo,
+// into an EnvMap. Values may be quoted, and may be lists, like
+// "a=(x 'y z')"; variable references are not expanded. A '#' starts
+// a comment. If s contains anything other than assignments,
+// an error is returned.
+func ParseEnv(s string) (EnvMap, error) {
+	tok := new(Tokenizer)
+	tokens, nAssign, err := tok.do(s, true)
+	if err != nil {
+		return nil, err
+	}
+	if nAssign != len(tokens) {
+		return nil, errors.New("not an assignment")
+	}
+	m := make(EnvMap, nAssign)
+	for _, t := range tokens {
+		name, values := tok.assignment(t)
+		m[name] = values
+	}
+	return m, nil
+}
+
 // String returns the EnvMap formatted as a string of
 // assignments in alphabetically sorted order.
 func (m EnvMap) String() string {
@@ -79,7 +183,9 @@ func (m EnvMap) String() string {
 }
 
 // WriteTo writes the EnvMap formatted as a string of
-// assignments in alphabetically sorted order.
+// assignments in alphabetically sorted order. The values of
+// a variable that has not exactly one value are written as a
+// list, like "name=(a 'b c')", or "name=()" for an empty list.
 func (m EnvMap) WriteTo(w io.Writer) (n int64, err error) {
 	if len(m) == 0 {
 		return 0, nil
@@ -93,12 +199,13 @@ func (m EnvMap) WriteTo(w io.Writer) (n int64, err error) {
 	nw := int64(0)
 	sep := ""
 	for _, name := range varNames {
-		values := m[name]
-		val := ""
-		if len(values) != 0 {
-			val = values[0]
+		value := ""
+		if values := m[name]; len(values) == 1 {
+			value = Quote(values[0])
+		} else {
+			value = "(" + Join(values) + ")"
 		}
-		n, err := fmt.Fprintf(w, "%s%s=%s", sep, Quote(name), Quote(val))
+		n, err := fmt.Fprintf(w, "%s%s=%s", sep, Quote(name), value)
 		if err != nil {
 			return nw, err
 		}
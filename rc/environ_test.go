@@ -0,0 +1,126 @@
+package rc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEnvStackClone(t *testing.T) {
+	var s EnvStack
+	s.Push(EnvMap{"a": {"1", "2"}, "b": nil})
+	s.Push(EnvMap{"c": {"3"}})
+
+	c := s.Clone()
+	c.Set("c", []string{"changed"})
+	c[0]["a"][0] = "changed"
+	c[0]["d"] = []string{"new"}
+	c.Push(EnvMap{"a": {"shadowed"}})
+
+	compareStringSlices(t, []string{"1", "2"}, s.Get("a"), "original a", 0)
+	compareStringSlices(t, []string{"3"}, s.Get("c"), "original c", 0)
+	if _, ok := s[0]["d"]; ok {
+		t.Errorf("new variable visible in original")
+	}
+	if len(s) != 2 {
+		t.Errorf("original stack size changed: %d", len(s))
+	}
+	compareStringSlices(t, []string{"shadowed"}, c.Get("a"), "cloned a", 0)
+	if v, ok := c[0]["b"]; !ok || v != nil {
+		t.Errorf("nil value not preserved: %v, %v", v, ok)
+	}
+}
+
+func TestEnvMapDiff(t *testing.T) {
+	old := EnvMap{"a": {"1"}, "b": {"x", "y"}, "c": nil, "d": {"gone"}}
+	cur := EnvMap{"a": {"1"}, "b": {"x", "z"}, "c": {}, "e": {"new"}}
+	added, changed, removed := old.Diff(cur)
+	if s := added.String(); s != "e=new" {
+		t.Errorf("added: %s", s)
+	}
+	// nil and an empty list are regarded as equal
+	if s := changed.String(); s != "b=(x z)" {
+		t.Errorf("changed: %s", s)
+	}
+	if s := removed.String(); s != "d=gone" {
+		t.Errorf("removed: %s", s)
+	}
+	changed["b"][0] = "modified"
+	compareStringSlices(t, []string{"x", "z"}, cur["b"], "cur b", 0)
+}
+
+func TestParseEnv(t *testing.T) {
+	m, err := ParseEnv(`a=1 b='x y' c= # comment`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := m.String(); s != "a=1 b='x y' c=" {
+		t.Errorf("unexpected result: %s", s)
+	}
+	m2, err := ParseEnv(m.String())
+	if err != nil || m2.String() != m.String() {
+		t.Errorf("round trip failed: %v, %v", m2, err)
+	}
+	if _, err := ParseEnv("a=1 cmd"); err == nil {
+		t.Error("command not rejected")
+	}
+	if _, err := ParseEnv("a=1 >file"); err == nil {
+		t.Error("redirection not rejected")
+	}
+}
+
+func TestParseEnvRoundTrip(t *testing.T) {
+	m := EnvMap{
+		"list":  {"x y", "", "it's", "z"},
+		"one":   {"a b#c"},
+		"empty": {""},
+		"none":  {},
+		"paren": {"()"},
+	}
+	s := m.String()
+	if want := "empty= list=('x y' '' 'it''s' z) none=() one='a b#c' paren='()'"; s != want {
+		t.Errorf("unexpected string: %s, want %s", s, want)
+	}
+	m2, err := ParseEnv(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m2, m) {
+		t.Errorf("round trip failed: %q", m2)
+	}
+
+	// a nil value is read back as an empty list, which is not a change
+	m["none"] = nil
+	m2, err = ParseEnv(m.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added, changed, removed := m.Diff(m2); len(added)+len(changed)+len(removed) != 0 {
+		t.Errorf("unexpected difference: %v %v %v", added, changed, removed)
+	}
+
+	for _, s := range []string{"a=(x", "a=(x)y", "a=(x (y))", "a=(x >f)"} {
+		if _, err := ParseEnv(s); err == nil {
+			t.Errorf("%s: error not detected", s)
+		}
+	}
+}
+
+func TestEnvStackSnapshot(t *testing.T) {
+	var s EnvStack
+	s.Push(EnvMap{"a": {"1"}})
+
+	snap := s.Snapshot()
+	s.Set("a", []string{"2"})
+	s.Push(EnvMap{"b": {"3"}})
+	compareStringSlices(t, []string{"1"}, snap.Get("a"), "snapshot a", 0)
+
+	for i := 0; i < 2; i++ {
+		s.Restore(snap)
+		if len(s) != 1 {
+			t.Fatalf("restored stack has size %d, want 1", len(s))
+		}
+		compareStringSlices(t, []string{"1"}, s.Get("a"), "restored a", i)
+		s.Set("a", []string{"changed"})
+	}
+	compareStringSlices(t, []string{"1"}, snap.Get("a"), "snapshot a after restore", 0)
+}
@@ -1,6 +1,7 @@
 package rc
 
 import (
+	"errors"
 	"strings"
 	"unicode/utf8"
 )
@@ -63,17 +64,94 @@ func quote(s, unquoted string) string {
 	return q
 }
 
+// Unquote interprets s as a single token quoted in the style of rc,
+// and returns the value it represents: Quoted sections are enclosed
+// in single quotes, within which two consecutive single quotes
+// represent one quote. Unquote is the inverse of Quote and QuoteCmd.
+func Unquote(s string) (string, error) {
+	var b strings.Builder
+
+	quoting := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\'' {
+			b.WriteByte(c)
+			continue
+		}
+		if !quoting {
+			quoting = true
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == '\'' {
+			b.WriteByte(c)
+			i++
+			continue
+		}
+		quoting = false
+	}
+	if quoting {
+		return "", errors.New("unterminated quoted string")
+	}
+	return b.String(), nil
+}
+
+// Join quotes the elements of list using Quote, and joins them,
+// separated by spaces. Empty elements are represented by a pair of
+// quotes, so that Split is able to restore the original list.
 func Join(list []string) string {
 	if len(list) == 0 {
 		return ""
 	}
 	js := ""
 	for _, s := range list {
+		if s == "" {
+			js += " ''"
+			continue
+		}
 		js += " " + Quote(s)
 	}
 	return js[1:]
 }
 
+// Split is the inverse of Join: It splits s at unquoted white-space
+// and removes the quoting of each part as Unquote does. In contrast
+// to Tokenize, characters having a special meaning in rc, like '^'
+// or '$', are not interpreted. An unterminated quoted section
+// extends to the end of s.
+func Split(s string) []string {
+	var list []string
+	var b strings.Builder
+
+	inWord := false
+	quoting := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\'':
+			if quoting && i+1 < len(s) && s[i+1] == '\'' {
+				b.WriteByte(c)
+				i++
+			} else {
+				quoting = !quoting
+			}
+			inWord = true
+		case !quoting && strings.IndexByte(" \t\r\n", c) != -1:
+			if inWord {
+				list = append(list, b.String())
+				b.Reset()
+				inWord = false
+			}
+		default:
+			b.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		list = append(list, b.String())
+	}
+	return list
+}
+
 func JoinCmd(list []string) string {
 	if len(list) == 0 {
 		return ""
@@ -1,7 +1,10 @@
 package rc
 
 import (
+	"math/rand"
+	"reflect"
 	"testing"
+	"testing/quick"
 )
 
 type quoteTestSpec struct {
@@ -69,6 +72,25 @@ func TestQuote(t *testing.T) {
 	}
 }
 
+func TestUnquote(t *testing.T) {
+	for i := range quoteTests {
+		test := &quoteTests[i]
+		for _, q := range []string{test.quoted, test.quotedCmd} {
+			s, err := Unquote(q)
+			if err != nil {
+				t.Errorf("[%d] %q: %v", i, q, err)
+				continue
+			}
+			if s != test.src {
+				t.Errorf("[%d] mismatch: %q != %q", i, s, test.src)
+			}
+		}
+	}
+	if _, err := Unquote("'a''"); err == nil {
+		t.Errorf("unterminated quoted string not detected")
+	}
+}
+
 func TestQuoteCmd(t *testing.T) {
 	for i := range quoteTests {
 		test := &quoteTests[i]
@@ -79,3 +101,61 @@ func TestQuoteCmd(t *testing.T) {
 		}
 	}
 }
+
+func TestSplit(t *testing.T) {
+	for i, test := range []struct {
+		src  string
+		want []string
+	}{
+		{"", nil},
+		{"  a\tb  ", []string{"a", "b"}},
+		{`'a b' '' x=$y^z 'it''s'`, []string{"a b", "", "x=$y^z", "it's"}},
+		{`''''=''''`, []string{"'='"}},
+	} {
+		if got := Split(test.src); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("[%d] %q: got %q, want %q", i, test.src, got, test.want)
+		}
+	}
+}
+
+func TestJoinSplit(t *testing.T) {
+	roundTrip := func(list []string) bool {
+		got := Split(Join(list))
+		if len(list) == 0 {
+			return len(got) == 0
+		}
+		return reflect.DeepEqual(got, list)
+	}
+	if err := quick.Check(roundTrip, &quick.Config{MaxCount: 1000}); err != nil {
+		t.Error(err)
+	}
+
+	// lists built from characters that need special care
+	const alphabet = "a' $=^#\t\n"
+	conf := &quick.Config{
+		MaxCount: 1000,
+		Values: func(v []reflect.Value, r *rand.Rand) {
+			list := make([]string, r.Intn(5))
+			for i := range list {
+				b := make([]byte, r.Intn(6))
+				for j := range b {
+					b[j] = alphabet[r.Intn(len(alphabet))]
+				}
+				list[i] = string(b)
+			}
+			v[0] = reflect.ValueOf(list)
+		},
+	}
+	if err := quick.Check(roundTrip, conf); err != nil {
+		t.Error(err)
+	}
+	for _, list := range [][]string{
+		{"a b", "$x", "y=z", "''", " ", "\t\n"},
+		{"", "", "^"},
+		{"\xff\xfe", "ä ö"},
+	} {
+		if !roundTrip(list) {
+			t.Errorf("round trip failed: %q -> %q", list, Split(Join(list)))
+		}
+	}
+}
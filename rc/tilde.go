@@ -0,0 +1,57 @@
+package rc
+
+import (
+	"strings"
+)
+
+// expandTilde replaces unquoted words "~", and a leading "~/" or
+// "~user" of unquoted words, by the corresponding home directory,
+// as determined by tok.HomeDir. A word consisting of "~" only is
+// left as it is if it is in command position, i.e. the first word,
+// apart from assignments, or one following a word contained in
+// tok.CmdPrefixes. Words for which HomeDir returns an error are not
+// changed either.
+func (tok *Tokenizer) expandTilde(s string) string {
+	if strings.IndexByte(s, '~') == -1 {
+		return s
+	}
+	assignOp := tok.assignOp()
+	cmdPos := true
+	return mapWords(s, func(w string) string {
+		atCmd := cmdPos
+		cmdPos = false
+		if i := strings.Index(w, assignOp); i > 0 && strings.IndexByte(w[:i], '\'') == -1 {
+			cmdPos = atCmd
+		}
+		for _, p := range tok.CmdPrefixes {
+			if w == p {
+				cmdPos = atCmd
+			}
+		}
+		if w == "" || w[0] != '~' || w == "~" && atCmd {
+			return w
+		}
+		i := 1
+		for i < len(w) && isUserNameChar(w[i]) {
+			i++
+		}
+		if i < len(w) && w[i] != '/' {
+			return w
+		}
+		home, err := tok.HomeDir(w[1:i])
+		if err != nil || home == "" {
+			return w
+		}
+		return Quote(home) + w[i:]
+	})
+}
+
+func isUserNameChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	case c == '_', c == '-', c == '.':
+		return true
+	}
+	return false
+}
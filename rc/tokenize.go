@@ -3,10 +3,13 @@ package rc
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"regexp"
 	"strconv"
+	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 // An implementation of Plan 9's tokenize (see
@@ -22,15 +25,81 @@ func Tokenize(s string) []string {
 	return tokens.fields()
 }
 
+// A Tokenizer parses command lines. Each result is allocated
+// separately, so that results of previous calls stay valid; a
+// Tokenizer may be used concurrently, provided that Getenv is safe
+// for concurrent use.
 type Tokenizer struct {
-	buf    groupToken
 	Getenv func(string) []string
+
+	// AssignOp is the operator separating the name and the value
+	// of assignments at the front of a command line. If empty,
+	// "=" is used. Like "=", the operator must be written without
+	// white-space around it, also if it consists of multiple
+	// characters: with AssignOp ":=", "x:=1" is an assignment,
+	// while "x := 1" is a command x with the arguments ":=" and "1".
+	AssignOp string
+
+	// BraceExpand enables brace expansion: an unquoted word
+	// like "file.{c,h}" expands to "file.c file.h" before
+	// variables are expanded. The values of assignments, and
+	// the targets of redirections are not expanded.
+	BraceExpand bool
+
+	// HomeDir, if not nil, enables tilde expansion, which is
+	// disabled by default: an unquoted word "~", or a leading "~/"
+	// or "~user" of an unquoted word, is replaced by the home
+	// directory returned by HomeDir for the current user, specified
+	// as "", or for the named user. If HomeDir returns an error,
+	// the word is left as it is. A single "~" in command position,
+	// i.e. as the first word of a command line, or following one of
+	// the words in CmdPrefixes, is not expanded, as it refers to a
+	// command.
+	HomeDir func(user string) (string, error)
+
+	// CmdPrefixes lists words, like "if", that are followed by
+	// a command; see HomeDir.
+	CmdPrefixes []string
+
+	// inList is set while the elements of a list, or the words
+	// resulting from brace expansion are parsed, which may not
+	// contain assignments, and are not subject to brace expansion.
+	inList bool
+}
+
+func (tok *Tokenizer) assignOp() string {
+	if tok.AssignOp == "" {
+		return "="
+	}
+	return tok.AssignOp
 }
 
 type CmdLine struct {
 	Assignments EnvMap
 	Fields      []string
-	Redir       Redirection
+
+	// Redir lists the redirections in the order they
+	// have been specified on the command line.
+	Redir []Redirection
+
+	// Background is true if the command line has been
+	// terminated by an '&'.
+	Background bool
+
+	// HereDoc is non-nil if a here-document has been
+	// specified using "<<WORD".
+	HereDoc *HereDoc
+}
+
+// A HereDoc describes a here-document, i.e. the lines following
+// a command line up to a line consisting of the delimiter.
+type HereDoc struct {
+	Delim string
+
+	// Quoted is true if the delimiter has been quoted,
+	// meaning that variables inside the here-document
+	// shall not be expanded.
+	Quoted bool
 }
 
 func (c *CmdLine) String() string {
@@ -47,22 +116,96 @@ func (c *CmdLine) String() string {
 			sep = " "
 		}
 	}
-	if r := &c.Redir; r.Type != "" {
-		fmt.Fprint(b, sep, r.Type, r.Filename)
+	for _, r := range c.Redir {
+		fmt.Fprint(b, sep, r.String())
+		sep = " "
+	}
+	if h := c.HereDoc; h != nil {
+		delim := h.Delim
+		if h.Quoted {
+			delim = "'" + strings.Replace(delim, "'", "''", -1) + "'"
+		}
+		fmt.Fprint(b, sep, "<<", delim)
+		sep = " "
+	}
+	if c.Background {
+		fmt.Fprint(b, sep, "&")
 	}
 	return b.String()
 }
 
+// A Redirection describes an input or output redirection
+// like "<file", ">file", ">>file", "2>file", or "2>&1".
 type Redirection struct {
-	Type     string
+	// Type is one of "<", ">", ">>", or ">&".
+	Type string
+
+	// Fd is the file descriptor being redirected. If no
+	// number has been specified in front of the operator,
+	// it is 0 for "<", and 1 otherwise.
+	Fd int
+
+	// Filename is the name of the file to be opened. For ">&"
+	// it contains the number of the file descriptor Fd shall
+	// become a duplicate of.
 	Filename string
 }
 
+func (r *Redirection) String() string {
+	fd := ""
+	if r.Fd != defaultRedirFd(r.Type) {
+		fd = strconv.Itoa(r.Fd)
+	}
+	return fd + r.Type + r.Filename
+}
+
+func defaultRedirFd(op string) int {
+	if op == "<" {
+		return 0
+	}
+	return 1
+}
+
 // ParseCmdLine is similar to Tokenize in that  a string is separated into fields, and
 // quoted sections are recognized. It also expands variable references, if Tokenizer.Getenv
 // has been set. Any assignments given at the front of a line are parsed into an EnvMap.
+// A trailing '&' marks the command line for background execution.
 // On success, a CmdLine structure is returned.
 func (tok *Tokenizer) ParseCmdLine(s string) (c *CmdLine, err error) {
+	return tok.parseCmdLine(s, nil)
+}
+
+// ExpandStrict is like ParseCmdLine, but additionally returns the names
+// of variables that have been referenced, but expanded to nothing,
+// because they are unset, or their list of values is empty, or an index
+// is out of range. Each name is reported once, in order of appearance.
+func (tok *Tokenizer) ExpandStrict(s string) (c *CmdLine, missing []string, err error) {
+	m := new(missingVars)
+	c, err = tok.parseCmdLine(s, m)
+	return c, m.names, err
+}
+
+// missingVars records the names of variables that expanded to nothing.
+type missingVars struct {
+	names []string
+}
+
+func (m *missingVars) add(name string) {
+	if m == nil {
+		return
+	}
+	for _, n := range m.names {
+		if n == name {
+			return
+		}
+	}
+	m.names = append(m.names, name)
+}
+
+func (tok *Tokenizer) parseCmdLine(s string, missing *missingVars) (c *CmdLine, err error) {
+	if tok.HomeDir != nil {
+		s = tok.expandTilde(s)
+	}
 	tokens, nAssign, err := tok.do(s, true)
 	if err != nil {
 		return
@@ -73,7 +216,9 @@ func (tok *Tokenizer) ParseCmdLine(s string) (c *CmdLine, err error) {
 	}
 	if tok.Getenv != nil {
 		for i, t := range tokens {
-			tokens[i] = tok.expandEnv(t)
+			if tokens[i], err = tok.expandEnv(t, missing); err != nil {
+				return nil, err
+			}
 		}
 		// filter out nil tokens
 		iw := 0
@@ -90,18 +235,41 @@ func (tok *Tokenizer) ParseCmdLine(s string) (c *CmdLine, err error) {
 
 	c = new(CmdLine)
 	c.Fields = tokens.fields()
-	c.Redir = tokens.redirection()
+	c.Redir = tokens.redirections()
+	c.Background = tokens.background()
+	c.HereDoc = tokens.hereDoc()
 	if nAssign != 0 {
 		c.Assignments = make(EnvMap, nAssign)
 		for _, t := range tokens[:nAssign] {
-			a := t.(*assignmentToken)
-			c.Assignments[a.name.String()] = []string{string(a.stringToken)[1:]}
+			name, values := tok.assignment(t)
+			c.Assignments[name] = values
 		}
 		c.Fields = c.Fields[nAssign:]
 	}
 	return
 }
 
+// assignment returns the name and the values of an assignment. If
+// parts of the value have been quoted, or consist of variable
+// references, the assignmentToken is the first element of a group.
+// If a list has been assigned, like in "a=(x y)", each element
+// results in a value.
+func (tok *Tokenizer) assignment(t token) (name string, values []string) {
+	rest := ""
+	if g, ok := t.(groupToken); ok {
+		t, rest = g[0], g[1:].String()
+	}
+	a := t.(*assignmentToken)
+	if a.isList {
+		values = a.list.fields()
+		if values == nil {
+			values = []string{}
+		}
+		return a.name.String(), values
+	}
+	return a.name.String(), []string{string(a.stringToken)[len(tok.assignOp()):] + rest}
+}
+
 type token interface {
 	String() string
 	setString(string)
@@ -120,9 +288,23 @@ type varRefToken struct {
 type assignmentToken struct {
 	stringToken
 	name token
+
+	// isList is true if the value is a list, like "(a b)";
+	// list holds its elements.
+	isList bool
+	list   groupToken
 }
 type redirToken struct {
-	*stringToken
+	stringToken
+	fd     int
+	target string
+}
+type bgToken struct {
+	stringToken
+}
+type hereDocToken struct {
+	stringToken
+	quoted bool
 }
 
 func (t assignmentToken) String() string {
@@ -177,30 +359,69 @@ func (list groupToken) String() (s string) {
 }
 func (groupToken) setString(_ string) {}
 
+// fields returns the words of list; redirections, including their
+// targets, here-document delimiters, and a trailing '&' are skipped,
+// so that words following a redirection are kept.
 func (list groupToken) fields() (f []string) {
-	for _, t := range list {
-		if _, ok := t.(*redirToken); ok {
-			break
+	for i := 0; i < len(list); i++ {
+		switch list[i].(type) {
+		case *redirToken:
+			if list.hasRedirTarget(i) {
+				i++
+			}
+			continue
+		case *bgToken, *hereDocToken:
+			continue
 		}
-		f = append(f, t.String())
+		f = append(f, list[i].String())
 	}
 	return
 }
 
-func (list groupToken) redirection() Redirection {
-	var r Redirection
-	inRedir := false
-	for _, t := range list {
-		if inRedir {
-			r.Filename = t.String()
-			break
+// hasRedirTarget reports whether the redirection at list[i]
+// is followed by a separate word naming its target.
+func (list groupToken) hasRedirTarget(i int) bool {
+	if rt := list[i].(*redirToken); rt.target != "" || i+1 == len(list) {
+		return false
+	}
+	switch list[i+1].(type) {
+	case *redirToken, *bgToken, *hereDocToken:
+		return false
+	}
+	return true
+}
+
+func (list groupToken) redirections() (redir []Redirection) {
+	for i := 0; i < len(list); i++ {
+		rt, ok := list[i].(*redirToken)
+		if !ok {
+			continue
+		}
+		r := Redirection{Type: rt.String(), Fd: rt.fd, Filename: rt.target}
+		if list.hasRedirTarget(i) {
+			i++
+			r.Filename = list[i].String()
 		}
-		if _, ok := t.(*redirToken); ok {
-			inRedir = true
-			r.Type = t.String()
+		redir = append(redir, r)
+	}
+	return
+}
+
+func (list groupToken) hereDoc() *HereDoc {
+	for _, t := range list {
+		if h, ok := t.(*hereDocToken); ok {
+			return &HereDoc{Delim: h.String(), Quoted: h.quoted}
 		}
 	}
-	return r
+	return nil
+}
+
+func (list groupToken) background() bool {
+	if n := len(list); n != 0 {
+		_, ok := list[n-1].(*bgToken)
+		return ok
+	}
+	return false
 }
 
 func dump(list groupToken, indent string) {
@@ -214,20 +435,112 @@ func dump(list groupToken, indent string) {
 	}
 }
 
+// ExpandVars expands the variable references contained in s, which
+// is regarded as plain text, like the lines of a here-document:
+// quotes and other special characters are copied unchanged. References
+// are written, and expanded, like within commands: $name, $name(n),
+// $#name, $n, and $*. The values of a list are joined using spaces;
+// undefined variables expand to the empty string.
+func (tok *Tokenizer) ExpandVars(s string) string {
+	if strings.IndexByte(s, '$') == -1 {
+		return s
+	}
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '$')
+		if i == -1 {
+			break
+		}
+		b.WriteString(s[:i])
+		s = s[i:]
+		ref := new(varRefToken)
+		n := 1
+		if n < len(s) && s[n] == '#' {
+			ref.isCount = true
+			n++
+		}
+		iName := n
+		for n < len(s) {
+			r, size := utf8.DecodeRuneInString(s[n:])
+			if !unicode.IsLetter(r) && r != '_' && !unicode.IsDigit(r) && r != '*' {
+				break
+			}
+			n += size
+		}
+		if n == iName {
+			b.WriteString(s[:n])
+			s = s[n:]
+			continue
+		}
+		if m := refIndexRE.FindString(s[n:]); m != "" && !ref.isCount {
+			n += len(m)
+		}
+		ref.setString(s[:n])
+		t, _ := tok.expandEnv(ref, nil)
+		switch t := t.(type) {
+		case stringListToken:
+			b.WriteString(strings.Join(t, " "))
+		case nil:
+		default:
+			b.WriteString(t.String())
+		}
+		s = s[n:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+var refIndexRE = regexp.MustCompile(`^\([0-9]+\)`)
+
 var argrefRE = regexp.MustCompile("^[1-9][0-9]*$")
 var arridxRE = regexp.MustCompile(`\(([0-9]*)\)$`)
 
-func (tok *Tokenizer) expandEnv(t token) token {
+func (tok *Tokenizer) expandEnv(t token, missing *missingVars) (token, error) {
+	var err error
 	switch x := t.(type) {
 	case groupToken:
-		for i, sub := range x {
-			x[i] = tok.expandEnv(sub)
+		// references expanding to nothing are dropped
+		iw := 0
+		hasList := false
+		for _, sub := range x {
+			if sub, err = tok.expandEnv(sub, missing); err != nil {
+				return nil, err
+			}
+			if sub != nil {
+				if _, ok := sub.(stringListToken); ok {
+					hasList = true
+				}
+				x[iw] = sub
+				iw++
+			}
+		}
+		if iw == 0 {
+			return nil, nil
+		}
+		if hasList {
+			return tok.concatLists(x[:iw])
 		}
-		t = mergeStringTokens(x)
+		t = mergeStringTokens(x[:iw])
 	case *assignmentToken:
-		x.name = tok.expandEnv(x.name)
+		if x.name, err = tok.expandEnv(x.name, missing); err != nil {
+			return nil, err
+		}
+		if x.isList {
+			iw := 0
+			for _, sub := range x.list {
+				if sub, err = tok.expandEnv(sub, missing); err != nil {
+					return nil, err
+				}
+				if sub != nil {
+					x.list[iw] = sub
+					iw++
+				}
+			}
+			x.list = flattenStringLists(x.list[:iw])
+		}
 	case *varRefToken:
 		ref := x.String()[1:]
+		name := ref
 		i := -1
 		if x.isCount {
 			ref = ref[1:]
@@ -242,6 +555,7 @@ func (tok *Tokenizer) expandEnv(t token) token {
 		} else if si := arridxRE.FindStringSubmatchIndex(ref); len(si) == 4 {
 			index := ref[si[2]:si[3]]
 			if index == "0" || index == "" {
+				missing.add(name)
 				t.setString("")
 				break
 			}
@@ -254,19 +568,78 @@ func (tok *Tokenizer) expandEnv(t token) token {
 		if i == -1 {
 			switch len(value) {
 			case 0:
-				return nil
+				missing.add(name)
+				return nil, nil
 			case 1:
 				t.setString(value[0])
 			default:
 				t = stringListToken(value)
 			}
 		} else if len(value) <= i {
+			missing.add(name)
 			t.setString("")
 		} else {
 			t.setString(value[i])
 		}
 	}
-	return t
+	return t, nil
+}
+
+var errListLengths = errors.New("mismatched list lengths in concatenation")
+
+// concatLists concatenates the parts of a word, at least one of which
+// has expanded to a list of several values, like in rc: two lists of
+// equal length are concatenated pairwise; a single value is
+// concatenated with each element of the other side. The result is a
+// stringListToken, or, if the word is an assignment, an assignment
+// of the resulting list.
+func (tok *Tokenizer) concatLists(parts groupToken) (token, error) {
+	a, isAssign := parts[0].(*assignmentToken)
+	if isAssign {
+		v := stringToken(string(a.stringToken)[len(tok.assignOp()):])
+		parts[0] = &v
+		if v == "" {
+			parts = parts[1:]
+		}
+	}
+	var values []string
+	for i, t := range parts {
+		list, ok := t.(stringListToken)
+		if !ok {
+			list = stringListToken{t.String()}
+		}
+		switch {
+		case i == 0:
+			values = append(values, list...)
+		case len(list) == len(values):
+			for j := range values {
+				values[j] += list[j]
+			}
+		case len(list) == 1:
+			for j := range values {
+				values[j] += list[0]
+			}
+		case len(values) == 1:
+			v := values[0]
+			values = make([]string, len(list))
+			for j, s := range list {
+				values[j] = v + s
+			}
+		default:
+			return nil, errListLengths
+		}
+	}
+	if !isAssign {
+		return stringListToken(values), nil
+	}
+	a.setString(tok.assignOp())
+	a.isList = true
+	a.list = make(groupToken, len(values))
+	for i, v := range values {
+		s := stringToken(v)
+		a.list[i] = &s
+	}
+	return a, nil
 }
 
 func mergeStringTokens(list groupToken) token {
@@ -311,11 +684,18 @@ func (tok *Tokenizer) do(s string, handleSpecial bool) (fields groupToken, nAssi
 
 		i0 = -1
 
-		countAssign = true
+		// wordStart is the position where the current word
+		// started, or -1; it is -2 if the word is a continuation
+		// of the previous one, as in "a ^b".
+		wordStart = -1
+
+		countAssign = !tok.inList
 		seenAssign  = false
 
 		t token
 
+		assignOp = tok.assignOp()
+
 		setText = func(text string) {
 			if t == nil {
 				if len(field) != 0 {
@@ -329,17 +709,22 @@ func (tok *Tokenizer) do(s string, handleSpecial bool) (fields groupToken, nAssi
 			t.setString(text)
 		}
 		addField = func(iPos int) {
+			w0 := wordStart
+			wordStart = -1
 			if i0 == -1 {
 				return
 			}
+			isAssign := false
 			if countAssign {
 				if seenAssign {
 					nAssign++
 					seenAssign = false
+					isAssign = true
 				} else {
 					countAssign = false
 				}
 			}
+			n := len(fields)
 			if setText(s[i0:iPos]); t != nil {
 				if field == nil {
 					fields = append(fields, t)
@@ -357,6 +742,11 @@ func (tok *Tokenizer) do(s string, handleSpecial bool) (fields groupToken, nAssi
 			field = nil
 			t = nil
 			i0 = -1
+			if handleSpecial && tok.BraceExpand && !tok.inList && !isAssign && w0 >= 0 && len(fields) == n+1 {
+				if n == 0 || !isRedirOp(fields[n-1]) {
+					fields = tok.expandBraces(fields, s[w0:iPos])
+				}
+			}
 		}
 
 		flushToken = func(iPos int) {
@@ -371,10 +761,42 @@ func (tok *Tokenizer) do(s string, handleSpecial bool) (fields groupToken, nAssi
 		}
 	)
 
-	fields = tok.buf[:0]
+	// wordChar handles a character that is part of a word
+	wordChar := func(i int, r rune) {
+		if wordStart == -1 {
+			wordStart = i
+		}
+		if strings.HasPrefix(s[i:], assignOp) {
+			if _, ok := t.(*assignmentToken); !ok && countAssign && !seenAssign && i0 != -1 {
+				seenAssign = true
+				flushToken(i)
+				a := new(assignmentToken)
+				a.name = field
+				field = nil
+				t = a
+				return
+			}
+		}
+		if _, ok := t.(*varRefToken); ok {
+			if !unicode.IsLetter(r) && r != '_' && !unicode.IsDigit(r) && r != '*' && r != '(' && r != ')' {
+				flushToken(i)
+				return
+			}
+		}
+		if i0 == -1 {
+			i0 = i
+		}
+	}
 
+	iSkip := 0
 	for i, r := range s {
+		if i < iSkip {
+			continue
+		}
 		if r == '\'' {
+			if wordStart == -1 {
+				wordStart = i
+			}
 			if !quoting {
 				if wasq {
 					i0--
@@ -408,15 +830,60 @@ func (tok *Tokenizer) do(s string, handleSpecial bool) (fields groupToken, nAssi
 
 		switch r {
 		case '<', '>':
-			if _, ok := t.(*redirToken); ok {
+			if strings.HasPrefix(s[i:], "<<") {
+				addField(i)
+				h, n, err1 := parseHereDocDelim(s[i+2:])
+				if err1 != nil {
+					err = err1
+					return
+				}
+				fields = append(fields, h)
+				iSkip = i + 2 + n
 				break
 			}
+			fd := -1
+			if i0 != -1 && t == nil && field == nil && isDigits(s[i0:i]) {
+				// a file descriptor number like in "2>file"
+				fd, _ = strconv.Atoi(s[i0:i])
+				i0 = -1
+			}
 			addField(i)
-			t = &redirToken{
-				stringToken: new(stringToken),
+			rt, n, err1 := parseRedirOp(s[i:], fd)
+			if err1 != nil {
+				err = err1
+				return
 			}
-			i0 = i
+			fields = append(fields, rt)
+			countAssign = false
+			iSkip = i + n
+		case '(':
+			if a, ok := t.(*assignmentToken); ok && i == i0+len(assignOp) {
+				n, err1 := tok.parseList(a, s[i:])
+				if err1 != nil {
+					err = err1
+					return
+				}
+				iSkip = i + n
+				break
+			}
+			wordChar(i, r)
+		case '&':
+			if i+1 < len(s) && strings.IndexByte(" \t\r\n#", s[i+1]) == -1 {
+				// within a word, '&' is an ordinary character
+				wordChar(i, r)
+				break
+			}
+			addField(i)
+			if rest := strings.TrimLeft(s[i+1:], " \t\r\n"); rest != "" && rest[0] != '#' {
+				err = tokenSyntaxErr(r)
+				return
+			}
+			fields = append(fields, &bgToken{stringToken: "&"})
+			return
 		case '$':
+			if wordStart == -1 {
+				wordStart = i
+			}
 			flushToken(i)
 			t = new(varRefToken)
 		case '^':
@@ -433,6 +900,7 @@ func (tok *Tokenizer) do(s string, handleSpecial bool) (fields groupToken, nAssi
 					field = groupToken{tPrev}
 				}
 				fields = fields[:iLast]
+				wordStart = -2
 			}
 			flushToken(i)
 			i0++
@@ -447,31 +915,135 @@ func (tok *Tokenizer) do(s string, handleSpecial bool) (fields groupToken, nAssi
 			}
 			addField(i)
 			return
-		case '=':
-			if _, ok := t.(*assignmentToken); !ok && countAssign && !seenAssign && i0 != -1 {
-				seenAssign = true
-				flushToken(i)
-				a := new(assignmentToken)
-				a.name = field
-				field = nil
-				t = a
-				break
-			}
-			fallthrough
 		default:
-			if _, ok := t.(*varRefToken); ok {
-				if !unicode.IsLetter(r) && r != '_' && !unicode.IsDigit(r) && r != '*' && r != '(' && r != ')' {
-					flushToken(i)
-					continue
+			wordChar(i, r)
+		}
+	}
+	addField(len(s))
+	return
+}
+
+// parseList parses a list, like "(a 'b c')", at the start of s,
+// which is the value of assignment a. Elements are separated by
+// white-space. It returns the number of bytes consumed.
+func (tok *Tokenizer) parseList(a *assignmentToken, s string) (n int, err error) {
+	quoting := false
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\'':
+			quoting = !quoting
+		case quoting:
+		case c == '(':
+			return 0, tokenSyntaxErr('(')
+		case c == ')':
+			if i+1 < len(s) && strings.IndexByte(" \t\r\n#", s[i+1]) == -1 {
+				return 0, tokenSyntaxErr(')')
+			}
+			sub := *tok
+			sub.inList = true
+			list, _, err := sub.do(s[1:i], true)
+			if err != nil {
+				return 0, err
+			}
+			for _, t := range list {
+				switch t.(type) {
+				case *redirToken, *bgToken, *hereDocToken:
+					return 0, errors.New("list: unexpected " + t.String())
 				}
 			}
-			if i0 == -1 {
-				i0 = i
+			a.isList = true
+			a.list = list
+			return i + 1, nil
+		}
+	}
+	return 0, errors.New("list: missing ')'")
+}
+
+// parseHereDocDelim parses the delimiter of a here-document at the
+// start of s, which may be preceded by white-space, and may be quoted.
+// It returns the number of bytes consumed.
+func parseHereDocDelim(s string) (h *hereDocToken, n int, err error) {
+	h = new(hereDocToken)
+	i := 0
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+		i++
+	}
+	if i < len(s) && s[i] == '\'' {
+		h.quoted = true
+		delim := ""
+		for i++; ; i++ {
+			if i == len(s) {
+				return nil, 0, errors.New("here-document delimiter: unterminated quoted string")
 			}
+			if s[i] == '\'' {
+				if i+1 < len(s) && s[i+1] == '\'' {
+					i++
+				} else {
+					i++
+					break
+				}
+			}
+			delim += s[i : i+1]
+		}
+		h.setString(delim)
+	} else {
+		i0 := i
+		for i < len(s) && strings.IndexByte(" \t\r\n#&<>", s[i]) == -1 {
+			i++
 		}
+		h.setString(s[i0:i])
 	}
-	addField(len(s))
-	return
+	if h.String() == "" {
+		return nil, 0, errors.New("here-document delimiter missing")
+	}
+	return h, i, nil
+}
+
+// parseRedirOp parses the redirection operator at the start of s,
+// which, in case of ">&", is followed by the number of the file
+// descriptor to be duplicated. Fd is the number specified in front
+// of the operator, or -1. It returns the number of bytes consumed.
+func parseRedirOp(s string, fd int) (r *redirToken, n int, err error) {
+	op := s[:1]
+	n = 1
+	if op == ">" {
+		if strings.HasPrefix(s[n:], ">") {
+			op = ">>"
+			n++
+		} else if strings.HasPrefix(s[n:], "&") {
+			i := n + 1
+			for i < len(s) && isDigits(s[i:i+1]) {
+				i++
+			}
+			if i == n+1 {
+				return nil, 0, tokenSyntaxErr('&')
+			}
+			r = &redirToken{target: s[n+1 : i]}
+			op = ">&"
+			n = i
+		}
+	}
+	if r == nil {
+		r = new(redirToken)
+	}
+	if fd == -1 {
+		fd = defaultRedirFd(op)
+	}
+	r.fd = fd
+	r.setString(op)
+	return r, n, nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
 }
 
 func tokenSyntaxErr(r rune) error {
@@ -1,6 +1,7 @@
 package rc
 
 import (
+	"errors"
 	"testing"
 )
 
@@ -9,7 +10,9 @@ type testSpec struct {
 	fields      []string
 	assignments EnvMap
 	env         EnvMap
-	redir       Redirection
+	redir       []Redirection
+	background  bool
+	hereDoc     *HereDoc
 	mustFail    bool
 }
 
@@ -141,6 +144,39 @@ var tokenizeCmdTests = []testSpec{
 		assignments: EnvMap{
 			"a": {"bbar=c"},
 		},
+	}, {
+		input: "x=1 y=2 # set x and y",
+		assignments: EnvMap{
+			"x": {"1"},
+			"y": {"2"},
+		},
+	}, {
+		input: "x='a b'c d",
+		assignments: EnvMap{
+			"x": {"a bc"},
+		},
+		fields: []string{"d"},
+	}, {
+		input: "x=1# set x",
+		assignments: EnvMap{
+			"x": {"1"},
+		},
+	}, {
+		input: "x=(a 'b c' $foo) y=() f",
+		assignments: EnvMap{
+			"x": {"a", "b c", "bar"},
+			"y": {},
+		},
+		fields: []string{"f"},
+	}, {
+		input:    "x=(d)e",
+		mustFail: true,
+	}, {
+		input:    "x=(a (b))",
+		mustFail: true,
+	}, {
+		input:    "x=(a b",
+		mustFail: true,
 	}, {
 		input:    "^a",
 		mustFail: true,
@@ -149,13 +185,163 @@ var tokenizeCmdTests = []testSpec{
 		fields: []string{
 			"a", "b",
 		},
-		redir: Redirection{Type: ">", Filename: "c"},
+		redir: []Redirection{{Type: ">", Fd: 1, Filename: "c"}},
 	}, {
 		input: "a b< c",
 		fields: []string{
 			"a", "b",
 		},
-		redir: Redirection{Type: "<", Filename: "c"},
+		redir: []Redirection{{Type: "<", Fd: 0, Filename: "c"}},
+	}, {
+		input: "a b &",
+		fields: []string{
+			"a", "b",
+		},
+		background: true,
+	}, {
+		input: "a b > c& # comment",
+		fields: []string{
+			"a", "b",
+		},
+		redir:      []Redirection{{Type: ">", Fd: 1, Filename: "c"}},
+		background: true,
+	}, {
+		input: "a '&' b",
+		fields: []string{
+			"a", "&", "b",
+		},
+	}, {
+		input:    "a & b",
+		mustFail: true,
+	}, {
+		input: "a&b c&&d x=$y&z &",
+		fields: []string{
+			"a&b", "c&&d", "x=&z",
+		},
+		background: true,
+	}, {
+		input: "cat <<EOF",
+		fields: []string{
+			"cat",
+		},
+		hereDoc: &HereDoc{Delim: "EOF"},
+	}, {
+		input: "cat a<< 'E O''F' > out",
+		fields: []string{
+			"cat", "a",
+		},
+		redir:   []Redirection{{Type: ">", Fd: 1, Filename: "out"}},
+		hereDoc: &HereDoc{Delim: "E O'F", Quoted: true},
+	}, {
+		input: "a >c 2>>err",
+		fields: []string{
+			"a",
+		},
+		redir: []Redirection{
+			{Type: ">", Fd: 1, Filename: "c"},
+			{Type: ">>", Fd: 2, Filename: "err"},
+		},
+	}, {
+		input: "a b>out 2>&1",
+		fields: []string{
+			"a", "b",
+		},
+		redir: []Redirection{
+			{Type: ">", Fd: 1, Filename: "out"},
+			{Type: ">&", Fd: 2, Filename: "1"},
+		},
+	}, {
+		input: "a '2'>c",
+		fields: []string{
+			"a", "2",
+		},
+		redir: []Redirection{{Type: ">", Fd: 1, Filename: "c"}},
+	}, {
+		input:    "a >& c",
+		mustFail: true,
+	}, {
+		input:    "cat <<",
+		mustFail: true,
+	}, {
+		input:    "cat <<'EOF",
+		mustFail: true,
+	}, {
+		input: "b=$args c=x$args d=$args^$*(1)",
+		assignments: EnvMap{
+			"b": {"x", "y"},
+			"c": {"xx", "xy"},
+			"d": {"xx", "yx"},
+		},
+	}, {
+		input: "x=$args echo $args^1 $args^$args",
+		fields: []string{
+			"echo", "x1", "y1", "xx", "yy",
+		},
+		assignments: EnvMap{
+			"x": {"x", "y"},
+		},
+	}, {
+		input:    "echo $args^$*",
+		mustFail: true,
+	}, {
+		input: "echo 2> f x",
+		fields: []string{
+			"echo", "x",
+		},
+		redir: []Redirection{{Type: ">", Fd: 2, Filename: "f"}},
+	}, {
+		input: "echo x>>f y <in z",
+		fields: []string{
+			"echo", "x", "y", "z",
+		},
+		redir: []Redirection{
+			{Type: ">>", Fd: 1, Filename: "f"},
+			{Type: "<", Fd: 0, Filename: "in"},
+		},
+	}, {
+		input: "cat <<EOF >out x &",
+		fields: []string{
+			"cat", "x",
+		},
+		redir:      []Redirection{{Type: ">", Fd: 1, Filename: "out"}},
+		hereDoc:    &HereDoc{Delim: "EOF"},
+		background: true,
+	},
+}
+
+var assignOpTests = []testSpec{
+	{
+		input: "x:=1 y:=$foo cmd a=b",
+		fields: []string{
+			"cmd", "a=b",
+		},
+		assignments: EnvMap{
+			"x": {"1"},
+			"y": {"bar"},
+		},
+	}, {
+		input: "x=1 y:=a:=b",
+		fields: []string{
+			"x=1", "y:=a:=b",
+		},
+	}, {
+		input: "x: :=1",
+		fields: []string{
+			"x:", ":=1",
+		},
+	}, {
+		input: "x := 1",
+		fields: []string{
+			"x", ":=", "1",
+		},
+	}, {
+		input: "x:= 1",
+		fields: []string{
+			"1",
+		},
+		assignments: EnvMap{
+			"x": {""},
+		},
 	},
 }
 
@@ -189,8 +375,16 @@ func TestTokenizeCmd(t *testing.T) {
 			t.Errorf("[%d] number of assignments don't match: %d != %d", i, n1, n2)
 			continue
 		}
-		if r1, r2 := test.redir, cmd.Redir; r1.Type != r2.Type || r1.Filename != r2.Filename {
-			t.Errorf("[%d] redirection doesn't match: %v != %v", i, r1, r2)
+		if r1, r2 := test.redir, cmd.Redir; !equalRedirections(r1, r2) {
+			t.Errorf("[%d] redirections don't match: %v != %v", i, r1, r2)
+			continue
+		}
+		if h1, h2 := test.hereDoc, cmd.HereDoc; (h1 == nil) != (h2 == nil) || h1 != nil && *h1 != *h2 {
+			t.Errorf("[%d] here-document doesn't match: %v != %v", i, h1, h2)
+			continue
+		}
+		if test.background != cmd.Background {
+			t.Errorf("[%d] background flag doesn't match: %v != %v", i, test.background, cmd.Background)
 			continue
 		}
 		for name, val1 := range test.assignments {
@@ -204,6 +398,68 @@ func TestTokenizeCmd(t *testing.T) {
 	}
 }
 
+func equalRedirections(r1, r2 []Redirection) bool {
+	if len(r1) != len(r2) {
+		return false
+	}
+	for i := range r1 {
+		if r1[i] != r2[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCmdLineStringRedir(t *testing.T) {
+	tok := new(Tokenizer)
+	c, err := tok.ParseCmdLine("a b >>log 2>&1 <in")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := c.String(); s != "a b >>log 2>&1 <in" {
+		t.Errorf("unexpected string: %q", s)
+	}
+}
+
+func TestParseCmdLineReentrant(t *testing.T) {
+	tok := new(Tokenizer)
+	c1, err := tok.ParseCmdLine("a b c > out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tok.ParseCmdLine("x=1 d e f g h > other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareStringSlices(t, []string{"a", "b", "c"}, c1.Fields, "field", 0)
+	if len(c1.Redir) != 1 || c1.Redir[0].Filename != "out" || c1.Assignments != nil {
+		t.Errorf("first result has been modified: %v", c1)
+	}
+}
+
+func TestAssignOp(t *testing.T) {
+	tok := new(Tokenizer)
+	tok.AssignOp = ":="
+	tok.Getenv = func(name string) []string {
+		return testEnvMap[name]
+	}
+	for i, test := range assignOpTests {
+		cmd, err := tok.ParseCmdLine(test.input)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		compareStringSlices(t, test.fields, cmd.Fields, "field", i)
+		if n1, n2 := len(test.assignments), len(cmd.Assignments); n1 != n2 {
+			t.Errorf("[%d] number of assignments don't match: %d != %d", i, n1, n2)
+			continue
+		}
+		for name, val1 := range test.assignments {
+			compareStringSlices(t, val1, cmd.Assignments[name], "assignment value", i)
+		}
+	}
+}
+
 func compareStringSlices(t *testing.T, want, have []string, context string, iTest int) {
 	if len(want) != len(have) {
 		t.Errorf("[%d] %s count: %d != %d", iTest, context, len(want), len(have))
@@ -216,3 +472,174 @@ func compareStringSlices(t *testing.T, want, have []string, context string, iTes
 		}
 	}
 }
+
+func TestExpandStrict(t *testing.T) {
+	env := EnvMap{"bar": {"1"}, "*": {"a"}}
+	tok := &Tokenizer{Getenv: func(name string) []string { return env[name] }}
+	c, missing, err := tok.ExpandStrict("echo $bar $foo x$foo $2 $bar(3)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareStringSlices(t, []string{"echo", "1", "x", "", ""}, c.Fields, "field", 0)
+	compareStringSlices(t, []string{"foo", "2", "bar(3)"}, missing, "missing", 0)
+
+	_, missing, err = tok.ExpandStrict("echo $bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 0 {
+		t.Errorf("unexpected missing variables: %q", missing)
+	}
+}
+
+var braceExpandTests = []testSpec{{
+	input:  "cc file.{c,h}",
+	fields: []string{"cc", "file.c", "file.h"},
+}, {
+	input:  "echo {a,b}{1,2}",
+	fields: []string{"echo", "a1", "a2", "b1", "b2"},
+}, {
+	input:  "echo x{a,b{1,2}}y",
+	fields: []string{"echo", "xay", "xb1y", "xb2y"},
+}, {
+	input:  "mv file{,.bak}",
+	fields: []string{"mv", "file", "file.bak"},
+}, {
+	input:  "echo '{a,b}' {x} {a,b",
+	fields: []string{"echo", "{a,b}", "{x}", "{a,b"},
+}, {
+	input:  "echo $foo.{x,y}",
+	fields: []string{"echo", "bar.x", "bar.y"},
+}, {
+	input:  "if a {",
+	fields: []string{"if", "a", "{"},
+}, {
+	input:  "echo a # {b,c}",
+	fields: []string{"echo", "a"},
+}, {
+	input:  "x={a,b} y=({c,d}) echo {e,f}",
+	fields: []string{"echo", "e", "f"},
+	assignments: EnvMap{
+		"x": {"{a,b}"},
+		"y": {"{c,d}"},
+	},
+}, {
+	input:  "echo {a,b} >f.{c,d} <g.{e,f} >> h.{i,j}",
+	fields: []string{"echo", "a", "b"},
+	redir: []Redirection{
+		{Type: ">", Fd: 1, Filename: "f.{c,d}"},
+		{Type: "<", Fd: 0, Filename: "g.{e,f}"},
+		{Type: ">>", Fd: 1, Filename: "h.{i,j}"},
+	},
+}, {
+	input:  "echo {a,b}'c d'^{e,f} x&{y,z}",
+	fields: []string{"echo", "ac de", "ac df", "bc de", "bc df", "x&y", "x&z"},
+}}
+
+func TestBraceExpand(t *testing.T) {
+	tok := new(Tokenizer)
+	tok.BraceExpand = true
+	tok.Getenv = func(name string) []string {
+		return testEnvMap[name]
+	}
+	for i, test := range braceExpandTests {
+		cmd, err := tok.ParseCmdLine(test.input)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		compareStringSlices(t, test.fields, cmd.Fields, "field", i)
+		if r1, r2 := test.redir, cmd.Redir; !equalRedirections(r1, r2) {
+			t.Errorf("[%d] redirections don't match: %v != %v", i, r1, r2)
+		}
+		if n1, n2 := len(test.assignments), len(cmd.Assignments); n1 != n2 {
+			t.Errorf("[%d] number of assignments don't match: %d != %d", i, n1, n2)
+			continue
+		}
+		for name, val1 := range test.assignments {
+			compareStringSlices(t, val1, cmd.Assignments[name], "assignment value", i)
+		}
+	}
+
+	cmd, err := new(Tokenizer).ParseCmdLine("echo {a,b}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareStringSlices(t, []string{"echo", "{a,b}"}, cmd.Fields, "field", 0)
+}
+
+var tildeTests = []testSpec{{
+	input:  "cat ~/notes ~bob/x ~bob",
+	fields: []string{"cat", "/home/me/notes", "/home/b ob/x", "/home/b ob"},
+}, {
+	input:  "~ ~ a~/x '~/x' ~'/x' '~'",
+	fields: []string{"~", "/home/me", "a~/x", "~/x", "~/x", "~"},
+}, {
+	input:  "if ! ~ ~ x",
+	fields: []string{"if", "!", "~", "/home/me", "x"},
+}, {
+	input:  "x=~ echo ~",
+	fields: []string{"echo", "/home/me"},
+	assignments: EnvMap{
+		"x": {"~"},
+	},
+}, {
+	input:  "x=1 ~ ~ y",
+	fields: []string{"~", "/home/me", "y"},
+	assignments: EnvMap{
+		"x": {"1"},
+	},
+}, {
+	input:  "ls ~unknownuser/x ~bob'x'",
+	fields: []string{"ls", "~unknownuser/x", "~bobx"},
+}}
+
+func TestTildeExpand(t *testing.T) {
+	tok := new(Tokenizer)
+	tok.HomeDir = func(user string) (string, error) {
+		switch user {
+		case "":
+			return "/home/me", nil
+		case "bob":
+			return "/home/b ob", nil
+		}
+		return "", errors.New("unknown user")
+	}
+	tok.CmdPrefixes = []string{"if", "!"}
+	for i, test := range tildeTests {
+		cmd, err := tok.ParseCmdLine(test.input)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		compareStringSlices(t, test.fields, cmd.Fields, "field", i)
+	}
+}
+
+func TestExpandVars(t *testing.T) {
+	tok := new(Tokenizer)
+	tok.Getenv = func(name string) []string {
+		switch name {
+		case "l":
+			return []string{"a", "b c"}
+		case "*":
+			return []string{"arg1"}
+		}
+		return testEnvMap[name]
+	}
+	tests := []struct {
+		input, want string
+	}{
+		{"no refs", "no refs"},
+		{"$foo, '$foo'!", "bar, 'bar'!"},
+		{"$#l $l $l(2) $l(3)|", "2 a b c b c |"},
+		{"$1 $2 $* $#*", "arg1  arg1 1"},
+		{"$ $$ 5$ $undefined.", "$ $$ 5$ ."},
+		{"($l(1))", "(a)"},
+	}
+	for i, test := range tests {
+		if s := tok.ExpandVars(test.input); s != test.want {
+			t.Errorf("[%d] %q: got %q, want %q", i, test.input, s, test.want)
+		}
+	}
+}
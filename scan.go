@@ -17,6 +17,12 @@ type SectionScanner struct {
 	text        string
 	NumSepLines int
 	n           int
+
+	// IsSeparator, if set, reports whether a line is a separator
+	// line, like "---". By default, empty lines are separators.
+	IsSeparator func(line string) bool
+
+	numLines int
 }
 
 func (s *SectionScanner) Scan() (ok bool) {
@@ -24,10 +30,13 @@ func (s *SectionScanner) Scan() (ok bool) {
 	if !ok {
 		return false
 	}
+	s.numLines++
 	s.text = s.Scanner.Text()
-	if s.text == "" {
+	if s.isSeparator(s.text) {
 		s.n++
 		if s.n == s.NumSepLines {
+			// prepare for reading the next section
+			s.n = 0
 			return false
 		}
 	} else {
@@ -40,6 +49,19 @@ func (s *SectionScanner) Text() string {
 	return s.text
 }
 
+func (s *SectionScanner) isSeparator(line string) bool {
+	if s.IsSeparator != nil {
+		return s.IsSeparator(line)
+	}
+	return line == ""
+}
+
+// NumLines returns the number of lines consumed from the underlying
+// Scanner, including separator lines.
+func (s *SectionScanner) NumLines() int {
+	return s.numLines
+}
+
 type multiScanner struct {
 	c    chan scanLine
 	line scanLine
@@ -77,3 +99,88 @@ func (m *multiScanner) Text() string {
 func (m *multiScanner) Err() error {
 	return m.line.err
 }
+
+// A PeekScanner wraps a Scanner, providing one line of lookahead.
+type PeekScanner struct {
+	s       Scanner
+	text    string
+	next    string
+	err     error
+	peeked  bool
+	hasNext bool
+}
+
+// NewPeekScanner returns a PeekScanner reading from s.
+func NewPeekScanner(s Scanner) *PeekScanner {
+	return &PeekScanner{s: s}
+}
+
+// Peek returns the text of the line that the next call to Scan
+// will advance to, without consuming it. It returns false if no such
+// line exists, either because of the end of input, or an error, which
+// will be reported by Err. The text returned by Text is not affected.
+func (p *PeekScanner) Peek() (string, bool) {
+	if !p.peeked {
+		p.hasNext = p.s.Scan()
+		if p.hasNext {
+			p.next = p.s.Text()
+		} else {
+			p.err = p.s.Err()
+		}
+		p.peeked = true
+	}
+	return p.next, p.hasNext
+}
+
+func (p *PeekScanner) Scan() bool {
+	_, ok := p.Peek()
+	p.peeked = false
+	if !ok {
+		p.text = ""
+		return false
+	}
+	p.text = p.next
+	p.next = ""
+	return true
+}
+
+func (p *PeekScanner) Text() string {
+	return p.text
+}
+
+func (p *PeekScanner) Err() error {
+	if p.err != nil {
+		return p.err
+	}
+	return p.s.Err()
+}
+
+// A LineScanner wraps a Scanner, counting the lines
+// that have been read successfully. It counts raw scans:
+// the text of the lines is passed through unmodified, so
+// handling of a byte order mark in the first line, or of
+// line prefixes, is left to the caller, and does not affect
+// the line numbers.
+type LineScanner struct {
+	Scanner
+	n int
+}
+
+// NewLineScanner returns a LineScanner reading from s.
+func NewLineScanner(s Scanner) *LineScanner {
+	return &LineScanner{Scanner: s}
+}
+
+func (s *LineScanner) Scan() bool {
+	if !s.Scanner.Scan() {
+		return false
+	}
+	s.n++
+	return true
+}
+
+// LineNum returns the 1-based number of the line
+// returned by Text, or 0 if Scan has not been called yet.
+func (s *LineScanner) LineNum() int {
+	return s.n
+}
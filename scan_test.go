@@ -0,0 +1,134 @@
+package text
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func newScanner(s string) *bufio.Scanner {
+	return bufio.NewScanner(strings.NewReader(s))
+}
+
+func TestPeekScanner(t *testing.T) {
+	p := NewPeekScanner(newScanner("a\nb\n"))
+
+	if s, ok := p.Peek(); !ok || s != "a" {
+		t.Fatalf("Peek before Scan: %q, %v", s, ok)
+	}
+	if s, ok := p.Peek(); !ok || s != "a" {
+		t.Fatalf("repeated Peek: %q, %v", s, ok)
+	}
+	if !p.Scan() || p.Text() != "a" {
+		t.Fatalf("Scan after Peek: %q", p.Text())
+	}
+	if s, ok := p.Peek(); !ok || s != "b" {
+		t.Fatalf("Peek after Scan: %q, %v", s, ok)
+	}
+	if p.Text() != "a" {
+		t.Errorf("Text changed by Peek: %q", p.Text())
+	}
+	if !p.Scan() || p.Text() != "b" {
+		t.Fatalf("second Scan: %q", p.Text())
+	}
+
+	// at the end of input
+	if s, ok := p.Peek(); ok || s != "" {
+		t.Errorf("Peek at EOF: %q, %v", s, ok)
+	}
+	if p.Text() != "b" {
+		t.Errorf("Text changed by Peek at EOF: %q", p.Text())
+	}
+	if p.Scan() {
+		t.Errorf("Scan at EOF succeeded: %q", p.Text())
+	}
+	if _, ok := p.Peek(); ok {
+		t.Error("Peek after EOF succeeded")
+	}
+	if err := p.Err(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestPeekScannerScanOnly(t *testing.T) {
+	p := NewPeekScanner(newScanner("a\nb\nc"))
+	var lines []string
+	for p.Scan() {
+		lines = append(lines, p.Text())
+	}
+	if s := strings.Join(lines, ","); s != "a,b,c" {
+		t.Errorf("unexpected lines: %q", s)
+	}
+}
+
+func TestLineScanner(t *testing.T) {
+	s := NewLineScanner(newScanner("\ufeffa\n\nb"))
+	if n := s.LineNum(); n != 0 {
+		t.Errorf("LineNum before Scan: %d", n)
+	}
+	var lines []string
+	for s.Scan() {
+		lines = append(lines, fmt.Sprintf("%d:%s", s.LineNum(), s.Text()))
+	}
+	if s := strings.Join(lines, ","); s != "1:\ufeffa,2:,3:b" {
+		t.Errorf("unexpected lines: %q", s)
+	}
+	if n := s.LineNum(); n != 3 {
+		t.Errorf("LineNum at EOF: %d", n)
+	}
+	if err := s.Err(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func scanSections(s *SectionScanner, n int) (sections []string) {
+	for i := 0; i < n; i++ {
+		var lines []string
+		for s.Scan() {
+			lines = append(lines, s.Text())
+		}
+		sections = append(sections, strings.Join(lines, ","))
+	}
+	return sections
+}
+
+func TestSectionScanner(t *testing.T) {
+	s := NewSectionScanner(newScanner("a\nb\n\nc\n\nd\n"))
+	got := strings.Join(scanSections(s, 4), "|")
+	if got != "a,b|c|d|" {
+		t.Errorf("unexpected sections: %q", got)
+	}
+	if n := s.NumLines(); n != 6 {
+		t.Errorf("unexpected number of lines: %d", n)
+	}
+
+	// a separator directly following a boundary ends an empty section
+	s = NewSectionScanner(newScanner("a\n\n\nb\n"))
+	got = strings.Join(scanSections(s, 3), "|")
+	if got != "a||b" {
+		t.Errorf("unexpected sections: %q", got)
+	}
+
+	// two separator lines, a single one belongs to the section
+	s = NewSectionScanner(newScanner("a\n\nb\n\n\nc\n\n\nd\n"))
+	s.NumSepLines = 2
+	got = strings.Join(scanSections(s, 3), "|")
+	if got != "a,,b,|c,|d" {
+		t.Errorf("unexpected sections: %q", got)
+	}
+}
+
+func TestSectionScannerIsSeparator(t *testing.T) {
+	s := NewSectionScanner(newScanner("a\n\nb\n---\nc\n---\n"))
+	s.IsSeparator = func(line string) bool {
+		return line == "---"
+	}
+	got := strings.Join(scanSections(s, 3), "|")
+	if got != "a,,b|c|" {
+		t.Errorf("unexpected sections: %q", got)
+	}
+	if n := s.NumLines(); n != 6 {
+		t.Errorf("unexpected number of lines: %d", n)
+	}
+}
@@ -5,17 +5,39 @@ import (
 	"regexp"
 )
 
-var (
-	fncallREStr = `\pL[\pL\pN]*\(`
-	chainingRE  = regexp.MustCompile(`\) *\.(` + fncallREStr + `)`)
-	fncallRE    = regexp.MustCompile(fncallREStr + `$`)
-)
+type methodChainREs struct {
+	chaining *regexp.Regexp
+	fncall   *regexp.Regexp
+}
+
+func newMethodChainREs(identRE string) *methodChainREs {
+	fncallREStr := `(?:` + identRE + `)\(`
+	return &methodChainREs{
+		chaining: regexp.MustCompile(`\) *\.(` + fncallREStr + `)`),
+		fncall:   regexp.MustCompile(fncallREStr + `$`),
+	}
+}
+
+var defaultMethodChainREs = newMethodChainREs(`\pL[\pL\pN]*`)
 
 // ConvertMethodChain converts all, possibly nested,
 // (x).method(y) expressions found in s into method(x, y) expressions.
 func ConvertMethodChain(s, argsep string) (string, error) {
+	return convertMethodChain(s, argsep, defaultMethodChainREs)
+}
+
+// ConvertMethodChainFunc is like ConvertMethodChain, but function and
+// method names are recognized using identPattern instead of the default
+// `\pL[\pL\pN]*`. This allows, for instance, names containing
+// underscores, or dotted names like pkg.fn. The pattern must not
+// be anchored.
+func ConvertMethodChainFunc(s, argsep string, identPattern *regexp.Regexp) (string, error) {
+	return convertMethodChain(s, argsep, newMethodChainREs(identPattern.String()))
+}
+
+func convertMethodChain(s, argsep string, re *methodChainREs) (string, error) {
 	for {
-		loc := chainingRE.FindStringSubmatchIndex(s)
+		loc := re.chaining.FindStringSubmatchIndex(s)
 		if loc == nil {
 			return s, nil
 		}
@@ -25,7 +47,7 @@ func ConvertMethodChain(s, argsep string) (string, error) {
 			return "", errors.New("missing opening brace")
 		}
 		i0 := iob
-		identLoc := fncallRE.FindStringIndex(s[:iob+1])
+		identLoc := re.fncall.FindStringIndex(s[:iob+1])
 		object := s[i0+1 : icb]
 		if identLoc != nil {
 			i0 = identLoc[0]
@@ -1,6 +1,7 @@
 package stringutil
 
 import (
+	"regexp"
 	"testing"
 )
 
@@ -43,3 +44,38 @@ func TestConvertMethodChain(t *testing.T) {
 		}
 	}
 }
+
+var methodChainFuncConvTests = []*methodChainConvTest{
+	{
+		src:      `_load(x).round_to(0.1)`,
+		expected: `round_to(_load(x), 0.1)`,
+	}, {
+		src:      `1*math.sin(x).fmt.round(0.1).clip_to(0, 1)`,
+		expected: `1*clip_to(fmt.round(math.sin(x), 0.1), 0, 1)`,
+	}, {
+		src:      `a.b(c.d(x).e_f(1)).g.h(2)`,
+		expected: `g.h(a.b(e_f(c.d(x), 1)), 2)`,
+	}, {
+		src:           `x).a.b(1)`,
+		expectFailure: true,
+	},
+}
+
+func TestConvertMethodChainFunc(t *testing.T) {
+	ident := regexp.MustCompile(`[\pL_][\pL\pN_]*(\.[\pL_][\pL\pN_]*)*`)
+	for _, test := range methodChainFuncConvTests {
+		converted, err := ConvertMethodChainFunc(test.src, ", ", ident)
+		if err != nil {
+			if !test.expectFailure {
+				t.Fatalf("%s: unexpected failure: %v", test.src, err)
+			}
+			continue
+		}
+		if test.expectFailure {
+			t.Fatalf("%s: test succeeded, expected failure", test.src)
+		}
+		if converted != test.expected {
+			t.Fatalf("mismatch: expected: %v, got: %v", test.expected, converted)
+		}
+	}
+}
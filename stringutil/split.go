@@ -3,6 +3,7 @@ package stringutil
 
 import (
 	"strings"
+	"unicode"
 )
 
 // DelimitedBlockAttr specifies the delimiters and other attributes
@@ -26,17 +27,82 @@ type DelimitedBlockAttr struct {
 // those separators. If sep is empty, or if s does not contain sep, Split returns s
 // as the only element of a slice.
 func RootLevelSplit(s, sep string, blockAttrs []*DelimitedBlockAttr) []string {
+	var list []string
+
+	i0 := 0
+	for _, i := range RootLevelSeparatorPositions(s, sep, blockAttrs) {
+		list = append(list, s[i0:i])
+		i0 = i + len(sep)
+	}
+	return append(list, s[i0:])
+}
+
+// RootLevelFields splits s around runs of white space, as defined by
+// unicode.IsSpace, on the topmost level of a hierarchy of delimited blocks,
+// like strings.Fields does for a flat string. Consecutive white space
+// characters are treated as a single separator, so no empty strings
+// are returned. If blockAttrs is nil, DefaultBlockAttrs is used.
+func RootLevelFields(s string, blockAttrs []*DelimitedBlockAttr) []string {
+	var list []string
+
+	i0 := 0
+	for _, sep := range rootLevelSeparators(s, spaceLen, blockAttrs) {
+		if sep[0] > i0 {
+			list = append(list, s[i0:sep[0]])
+		}
+		i0 = sep[1]
+	}
+	if i0 < len(s) {
+		list = append(list, s[i0:])
+	}
+	return list
+}
+
+// spaceLen returns the length in bytes of the run of white space
+// at the beginning of s.
+func spaceLen(s string) int {
+	for i, r := range s {
+		if !unicode.IsSpace(r) {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// RootLevelSeparatorPositions returns the byte offsets of all occurrences
+// of sep in s that are located on the topmost level of a hierarchy of
+// delimited blocks, i.e. the positions where RootLevelSplit would
+// split s.
+func RootLevelSeparatorPositions(s, sep string, blockAttrs []*DelimitedBlockAttr) []int {
+	var pos []int
+
+	sepLen := func(s string) int {
+		if strings.HasPrefix(s, sep) {
+			return len(sep)
+		}
+		return 0
+	}
+	for _, sep := range rootLevelSeparators(s, sepLen, blockAttrs) {
+		pos = append(pos, sep[0])
+	}
+	return pos
+}
+
+// rootLevelSeparators returns the start and end offsets of the separators
+// located on the topmost level of a hierarchy of delimited blocks. A separator
+// is detected where sepLen, called with the remaining part of s, returns
+// a length greater than zero.
+func rootLevelSeparators(s string, sepLen func(string) int, blockAttrs []*DelimitedBlockAttr) [][2]int {
 	var stk []*DelimitedBlockAttr
 	var cur *DelimitedBlockAttr
 	iStk := -1
 	iCont := 0
-	var list []string
+	var seps [][2]int
 
 	if blockAttrs == nil {
 		blockAttrs = DefaultBlockAttrs
 	}
 
-	i0 := 0
 	for i := range s {
 		b := s[i]
 		if i < iCont {
@@ -61,10 +127,9 @@ func RootLevelSplit(s, sep string, blockAttrs []*DelimitedBlockAttr) []string {
 			}
 		}
 		if iStk == -1 {
-			if strings.HasPrefix(s[i:], sep) {
-				list = append(list, s[i0:i])
-				i0 = i + len(sep)
-				iCont = i0
+			if n := sepLen(s[i:]); n > 0 {
+				seps = append(seps, [2]int{i, i + n})
+				iCont = i + n
 				continue
 			}
 		}
@@ -76,7 +141,7 @@ func RootLevelSplit(s, sep string, blockAttrs []*DelimitedBlockAttr) []string {
 			}
 		}
 	}
-	return append(list, s[i0:])
+	return seps
 }
 
 // DefaultBlockAttrs defines a list of block delimiters and attributes,
@@ -1,6 +1,7 @@
 package stringutil
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -53,3 +54,61 @@ func TestScopedSplit(t *testing.T) {
 		}
 	}
 }
+
+func TestRootLevelFields(t *testing.T) {
+	tests := []struct {
+		src      string
+		expected []string
+	}{
+		{"", nil},
+		{"  \t ", nil},
+		{"a b  c", []string{"a", "b", "c"}},
+		{" f(a, b)\t[c d]  \"e f\" ", []string{"f(a, b)", "[c d]", `"e f"`}},
+		{`g(h(x y) z) "a\" b"`, []string{"g(h(x y) z)", `"a\" b"`}},
+	}
+	for _, test := range tests {
+		f := RootLevelFields(test.src, nil)
+		if !reflect.DeepEqual(f, test.expected) {
+			t.Errorf("%q: expected: %q, got: %q", test.src, test.expected, f)
+		}
+	}
+}
+
+type sepPosTest struct {
+	src      string
+	sep      string
+	expected []int
+}
+
+var sepPosTests = []*sepPosTest{
+	{
+		src:      `a, b, c`,
+		sep:      ",",
+		expected: []int{1, 4},
+	}, {
+		src:      `f(a, b), [c, d], e`,
+		sep:      ",",
+		expected: []int{7, 15},
+	}, {
+		src:      `"a, b" :: {c :: d} :: e`,
+		sep:      "::",
+		expected: []int{7, 19},
+	}, {
+		src: `(a, b)`,
+		sep: ",",
+	},
+}
+
+func TestRootLevelSeparatorPositions(t *testing.T) {
+	for _, test := range sepPosTests {
+		pos := RootLevelSeparatorPositions(test.src, test.sep, nil)
+		if len(pos) != len(test.expected) {
+			t.Fatalf("%q: length mismatch: expected: %v, got: %v", test.src, test.expected, pos)
+		}
+		for i, p := range pos {
+			if p != test.expected[i] {
+				t.Fatalf("%q: position mismatch: expected: %v, got: %v", test.src, test.expected, pos)
+			}
+		}
+	}
+}
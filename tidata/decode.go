@@ -4,6 +4,7 @@ import (
 	"encoding"
 	"errors"
 	"fmt"
+	"os"
 	"reflect"
 	"runtime"
 	"strconv"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/knieriem/text/line"
 	"github.com/knieriem/text/rc"
+	"github.com/knieriem/text/stringutil"
 )
 
 // An UnmarshalTypeError describes a tidata value that was
@@ -28,7 +30,40 @@ type Config struct {
 	Sep            string // a string separating key and value, e.g. ":"
 	MapSym         string
 	KeyToFieldName func(string) string
+
+	// FieldNameToKey, the inverse of KeyToFieldName, is used by an
+	// Encoder to derive keys from field names. If it is nil, field
+	// names are used as keys.
+	FieldNameToKey func(string) string
+
 	MultiStringSep string
+
+	// SliceSep, if not empty, separates the elements of slices
+	// specified inline, i.e. in the value part of a line, for
+	// fields without a "sep=" tag option. The value is split on
+	// the topmost level of brackets, see stringutil.RootLevelSplit,
+	// and white-space surrounding the elements is removed. By default,
+	// the value is split into white-space separated, possibly
+	// quoted, tokens.
+	SliceSep string
+
+	// UnknownFieldWarnings makes Decode report keys that do not
+	// correspond to a field as warnings, see line.Severity,
+	// instead of errors. Warnings do not make Decode fail;
+	// they are passed to Warn, if it is not nil, in the order
+	// they have been found.
+	UnknownFieldWarnings bool
+	Warn                 func(err error)
+
+	// LookupEnv, if not nil, enables the expansion of references
+	// to variables, written as $NAME or ${NAME}, in values decoded
+	// into strings, see os.Expand; "$$" results in a single '$'.
+	// Like os.LookupEnv, it returns the value of a variable, and
+	// whether it is defined. Undefined variables expand to the
+	// empty string, or, if UndefinedVarErrors is set, are
+	// reported as errors.
+	LookupEnv          func(name string) (value string, ok bool)
+	UndefinedVarErrors bool
 }
 
 var dfltConfig = Config{
@@ -47,6 +82,14 @@ type decoder struct {
 	cur struct {
 		field string
 		line  int
+
+		// sep is the separator specified using the
+		// "sep=" tag option of the current field
+		sep string
+
+		// mapKey is the name of the field specified using
+		// the "key=" tag option of the current field
+		mapKey string
 	}
 	errList line.ErrorList
 
@@ -71,25 +114,66 @@ type Error struct {
 	Err  error
 	Key  string
 	line int
+	col  int
+	sev  line.Severity
 }
 
 func (e *Error) Line() int {
 	return e.line
 }
 
+// Column returns the column the error refers to, counting from 1,
+// or zero if it is unknown.
+func (e *Error) Column() int {
+	return e.col
+}
+
+// Severity returns line.SeverityWarning for warnings,
+// and line.SeverityError otherwise.
+func (e *Error) Severity() line.Severity {
+	return e.sev
+}
+
 func (e *Error) Error() string {
 	return fmt.Sprintf("tidata: %s: %s", e.Key, e.Err.Error())
 }
 
 func (d *decoder) saveError(err error) {
+	d.addError(err, line.SeverityError)
+}
+
+// saveWarning is like saveError, but the error
+// has severity line.SeverityWarning.
+func (d *decoder) saveWarning(err error) {
+	d.addError(err, line.SeverityWarning)
+}
+
+func (d *decoder) addError(err error, sev line.Severity) {
 	e := &Error{
 		line: d.cur.line,
 		Err:  err,
 		Key:  d.cur.field,
+		sev:  sev,
+	}
+	if ce, ok := err.(*colError); ok {
+		e.Err = ce.error
+		e.col = ce.col
+	}
+	if sev == line.SeverityWarning {
+		if d.Warn != nil {
+			d.Warn(e)
+		}
+		return
 	}
 	d.errList.Add(e)
 }
 
+// A colError annotates an error with the column it refers to.
+type colError struct {
+	error
+	col int
+}
+
 func (e Elem) Decode(i interface{}, c *Config) (err error) {
 	v := reflect.ValueOf(i)
 	if v.Kind() != reflect.Ptr {
@@ -143,6 +227,9 @@ func (d *decoder) deriveKey(el Elem) (key string, err error) {
 	if d.Sep != "" {
 		if !strings.HasSuffix(k, d.Sep) {
 			err = errors.New("missing '" + d.Sep + "' in key")
+			if el.col != 0 {
+				err = &colError{err, el.col + len(k)}
+			}
 			return
 		}
 		k = k[:len(k)-len(d.Sep)]
@@ -171,13 +258,13 @@ func (d *decoder) decodeStruct(dest reflect.Value, src Elem) {
 	d.cur.line = src.LineNum
 
 	t := dest.Type()
-	if f := dest.FieldByName("SrcLineNum"); f.IsValid() {
+	if f := fieldByName(dest, "SrcLineNum"); f.IsValid() {
 		f.SetInt(int64(d.cur.line))
 	}
-	if f := dest.FieldByName("TidataElem"); f.IsValid() {
+	if f := fieldByName(dest, "TidataElem"); f.IsValid() {
 		f.Set(reflect.ValueOf(&src))
 	}
-	if f := dest.FieldByName("TidataSeen"); f.IsValid() {
+	if f := fieldByName(dest, "TidataSeen"); f.IsValid() {
 		seenMap = f
 	}
 	di := dest.Addr().Interface()
@@ -204,17 +291,14 @@ func (d *decoder) decodeStruct(dest reflect.Value, src Elem) {
 		}
 	} else {
 		/* look into Value() if it contains short versions of fields */
-		v := src.Value()
+		attrs, err := parseAttrs(src.Value(), "=")
+		if err != nil {
+			d.saveError(err)
+			return
+		}
 		var pfx []Elem
-		for _, x := range rc.Tokenize(v) {
-			eq := strings.Index(x, "=")
-			el := Elem{LineNum: d.cur.line}
-			if eq != -1 {
-				el.Text = x[:eq] + d.Sep + "\t" + x[eq+1:]
-			} else {
-				el.Text = x + d.Sep + "\ttrue"
-			}
-			pfx = append(pfx, el)
+		for _, a := range attrs {
+			pfx = append(pfx, Elem{LineNum: d.cur.line, Text: a.name + d.Sep + "\t" + a.value})
 		}
 		if pfx != nil {
 			src.Children = append(pfx, src.Children...)
@@ -225,8 +309,7 @@ func (d *decoder) decodeStruct(dest reflect.Value, src Elem) {
 	for i, n := 0, t.NumField(); i < n; i++ {
 		f := t.Field(i)
 		if k := f.Type.Kind(); k == reflect.Slice || k == reflect.Map {
-			tag := f.Tag.Get("tidata")
-			if tag == "any" {
+			if hasTagOption(f, "any") {
 				anyIndex = i
 				break
 			}
@@ -254,14 +337,17 @@ func (d *decoder) decodeStruct(dest reflect.Value, src Elem) {
 
 		if f, ok := t.FieldByName(key); !ok {
 			if anyIndex == -1 {
-				d.saveError(errors.New("field does not exist"))
+				if d.UnknownFieldWarnings {
+					d.saveWarning(errors.New("field does not exist"))
+				} else {
+					d.saveError(errors.New("field does not exist"))
+				}
 			} else {
 				d.decodeItem(dest.Field(anyIndex), Elem{LineNum: el.LineNum, Children: src.Children[i:]})
 				break
 			}
 		} else {
-			v := dest.FieldByIndex(f.Index)
-			tag := f.Tag.Get("tidata")
+			v := fieldByIndex(dest, f.Index, true)
 			// Decide, whether multiple occurences of objects
 			// with the same key will be `combined', i.e. parsed
 			// into a single slice of values of the same type.
@@ -271,7 +357,7 @@ func (d *decoder) decodeStruct(dest reflect.Value, src Elem) {
 			// a TextUnmarshaler.
 			combine := false
 			isSlice := v.Kind() == reflect.Slice
-			if tag == "combine" {
+			if hasTagOption(f, "combine") {
 				if !isSlice {
 					panic("combine attr can be used with slice types only")
 				}
@@ -293,6 +379,8 @@ func (d *decoder) decodeStruct(dest reflect.Value, src Elem) {
 				d.postProcess(v, el)
 				continue
 			}
+			d.cur.sep, _ = tagValue(f, "sep")
+			d.cur.mapKey, _ = tagValue(f, "key")
 			d.decodeItem(v, el)
 			seen[key] = true
 		}
@@ -301,6 +389,30 @@ func (d *decoder) decodeStruct(dest reflect.Value, src Elem) {
 		seenMap.Set(reflect.ValueOf(seen))
 	}
 
+	// Report fields tagged as "required" that have not been
+	// specified in the input.
+	for _, name := range requiredFields(t) {
+		if seen[name] || seenCombined[name] {
+			continue
+		}
+		d.cur.line = src.LineNum
+		d.cur.field = t.String() + "." + name
+		d.saveError(errors.New("required field missing"))
+	}
+
+	// Apply the values of "default=" options to
+	// fields that have not been specified.
+	for _, name := range defaultFields(t) {
+		if seen[name] || seenCombined[name] {
+			continue
+		}
+		f, _ := t.FieldByName(name)
+		value, _ := tagValue(f, "default")
+		d.cur.field = t.String() + "." + name
+		d.cur.sep, _ = tagValue(f, "sep")
+		d.decodeItem(fieldByIndex(dest, f.Index, true), Elem{LineNum: src.LineNum, Text: ".\t" + value})
+	}
+
 	if r, ok := dest.Addr().Interface().(DeferredWorkRunner); ok {
 		for _, w := range d.deferredWork {
 			err = r.RunDeferredWork(w.fn)
@@ -317,6 +429,125 @@ func (d *decoder) decodeStruct(dest reflect.Value, src Elem) {
 	}
 }
 
+// fieldByIndex is like reflect.Value.FieldByIndex, but, if alloc is true,
+// nil pointers to embedded structs along the path will be allocated.
+// Otherwise, the zero Value is returned when such a pointer is met.
+func fieldByIndex(v reflect.Value, index []int, alloc bool) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Value{}
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// fieldByName returns the struct field with the given name, which may
+// be promoted from an embedded struct, as long as this does not
+// involve a nil pointer.
+func fieldByName(v reflect.Value, name string) reflect.Value {
+	f, ok := v.Type().FieldByName(name)
+	if !ok {
+		return reflect.Value{}
+	}
+	return fieldByIndex(v, f.Index, false)
+}
+
+// requiredFields returns the names of the fields of the struct type t,
+// including those promoted from embedded structs, that have been
+// tagged as "required".
+func requiredFields(t reflect.Type) []string {
+	return taggedFields(t, func(f reflect.StructField) bool {
+		return hasTagOption(f, "required")
+	})
+}
+
+// defaultFields returns the names of the fields of the struct type t,
+// including those promoted from embedded structs, that have a
+// "default=" tag option.
+func defaultFields(t reflect.Type) []string {
+	return taggedFields(t, func(f reflect.StructField) bool {
+		_, ok := tagValue(f, "default")
+		if ok && hasTagOption(f, "required") {
+			panic("tidata: field " + f.Name + ": options required and default are mutually exclusive")
+		}
+		return ok
+	})
+}
+
+func taggedFields(t reflect.Type, match func(reflect.StructField) bool) (names []string) {
+	for i, n := 0, t.NumField(); i < n; i++ {
+		f := t.Field(i)
+		if f.Anonymous {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				names = append(names, taggedFields(ft, match)...)
+				continue
+			}
+		}
+		if match(f) {
+			names = append(names, f.Name)
+		}
+	}
+	return
+}
+
+// hasTagOption reports whether the comma separated list of options
+// in the struct field's "tidata" tag contains opt.
+func hasTagOption(f reflect.StructField, opt string) bool {
+	_, ok := parseTag(f.Tag.Get("tidata"))[opt]
+	return ok
+}
+
+// tagValue returns the value of a "key=value" option
+// in the struct field's "tidata" tag.
+func tagValue(f reflect.StructField, key string) (value string, ok bool) {
+	value, ok = parseTag(f.Tag.Get("tidata"))[key+"="]
+	return
+}
+
+// tagOptions lists the known options of a tidata tag;
+// names ending in '=' take a value.
+var tagOptions = []string{"any", "combine", "required", "sep=", "default=", "key="}
+
+// parseTag splits a tidata tag into its comma separated options,
+// returning a map from option names to values. Since values may
+// contain commas themselves, a value extends up to the next comma
+// that is followed by a known option.
+func parseTag(tag string) map[string]string {
+	m := make(map[string]string, 4)
+	if tag == "" {
+		return m
+	}
+	cur := ""
+	for _, s := range strings.Split(tag, ",") {
+		name := ""
+		for _, opt := range tagOptions {
+			if s == opt || strings.HasSuffix(opt, "=") && strings.HasPrefix(s, opt) {
+				name = opt
+				break
+			}
+		}
+		switch {
+		case name != "":
+			m[name] = s[len(name):]
+			cur = name
+		case strings.HasSuffix(cur, "="):
+			m[cur] += "," + s
+		}
+	}
+	return m
+}
+
 func (d *decoder) postProcess(v reflect.Value, src Elem) {
 	if p, ok := v.Addr().Interface().(Postprocessor); ok {
 		d.cur.field = src.Key()
@@ -360,6 +591,10 @@ type Unmarshaler interface {
 
 func (d *decoder) decodeItem(v reflect.Value, el Elem) {
 	d.cur.line = el.LineNum
+	sep := d.cur.sep
+	d.cur.sep = ""
+	mapKey := d.cur.mapKey
+	d.cur.mapKey = ""
 
 	field := d.cur.field
 	defer func() {
@@ -398,7 +633,12 @@ retry:
 	case reflect.Struct:
 		d.decodeStruct(v, el)
 	case reflect.Slice:
-		sl := reflect.Zero(v.Type())
+		// Each child becomes an element of the slice; in case of
+		// nested slices, like [][]int, a child's value line, or its
+		// own children, make up an inner slice. Without children,
+		// the value is split into elements. A key without a value
+		// results in an empty, non-nil slice.
+		sl := reflect.MakeSlice(v.Type(), 0, 0)
 		if n := len(el.Children); n > 0 {
 			sl = reflect.MakeSlice(v.Type(), n, n)
 
@@ -407,7 +647,18 @@ retry:
 				d.decodeItem(sl.Index(i), Elem{LineNum: c.LineNum, Text: ".\t" + c.Text, Children: c.Children})
 			}
 		} else if s := el.Value(); s != "" {
-			list := rc.Tokenize(s)
+			var list []string
+			if sep == "" {
+				sep = d.SliceSep
+			}
+			if sep != "" {
+				list = stringutil.RootLevelSplit(s, sep, nil)
+				for i := range list {
+					list[i] = strings.TrimSpace(list[i])
+				}
+			} else {
+				list = rc.Tokenize(s)
+			}
 			if n = len(list); n > 0 {
 				sl = reflect.MakeSlice(v.Type(), n, n)
 				for i := 0; i < n; i++ {
@@ -417,11 +668,18 @@ retry:
 		}
 		v.Set(sl)
 	case reflect.Map:
-		d.decodeMap(v, el)
+		if mapKey != "" {
+			d.decodeKeyedMap(v, el, mapKey)
+		} else {
+			d.decodeMap(v, el)
+		}
 	case reflect.String:
 		val := el.Value()
 		if val == "" {
-			val = el.JoinSubElems("", "\t", d.MultiStringSep)
+			if sep == "" {
+				sep = d.MultiStringSep
+			}
+			val = el.JoinSubElems("", "\t", sep)
 		} else if len(el.Children) != 0 {
 			d.cur.line++
 			d.saveError(errors.New("wrong depth/inconsistent structure"))
@@ -440,6 +698,44 @@ retry:
 	d.postProcess(v, el)
 }
 
+// decodeKeyedMap decodes each child of src into a struct value,
+// which is stored in the map v using the value of its field keyField
+// as key. The keys of the children themselves are ignored.
+func (d *decoder) decodeKeyedMap(v reflect.Value, src Elem, keyField string) {
+	t := v.Type()
+	st := t.Elem()
+	if st.Kind() == reflect.Ptr {
+		st = st.Elem()
+	}
+	if st.Kind() != reflect.Struct {
+		panic("key attr can be used with maps of structs only")
+	}
+	f, ok := st.FieldByName(keyField)
+	if !ok {
+		panic("key attr: field " + keyField + " does not exist in " + st.String())
+	}
+	if !f.Type.AssignableTo(t.Key()) {
+		panic("key attr: type of field " + keyField + " does not match the map's key type")
+	}
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(t))
+	}
+
+	field := d.cur.field
+	for _, el := range src.Children {
+		val := reflect.New(t.Elem()).Elem()
+		d.decodeItem(val, el)
+		key := reflect.Indirect(val).FieldByIndex(f.Index)
+		if v.MapIndex(key).IsValid() {
+			d.cur.line = el.LineNum
+			d.cur.field = field
+			d.saveError(fmt.Errorf("duplicate key %q", fmt.Sprint(key.Interface())))
+			continue
+		}
+		v.SetMapIndex(key, val)
+	}
+}
+
 func (d *decoder) decodeMap(v reflect.Value, src Elem) {
 	t := v.Type()
 	if v.IsNil() {
@@ -454,6 +750,17 @@ func (d *decoder) decodeMap(v reflect.Value, src Elem) {
 	key := reflect.New(t.Key()).Elem()
 	val := reflect.New(t.Elem()).Elem()
 
+	// If the map's values are slices, the values of children
+	// with the same key are accumulated, like the elements of
+	// a slice field with the "combine" option.
+	var seen map[interface{}]bool
+	if et := t.Elem(); et.Kind() == reflect.Slice {
+		var etu encoding.TextUnmarshaler
+		if !reflect.PtrTo(et).Implements(reflect.TypeOf(&etu).Elem()) {
+			seen = make(map[interface{}]bool, n)
+		}
+	}
+
 	for i := 0; i < n; i++ {
 		el := src.Children[i]
 		d.cur.line = el.LineNum
@@ -468,35 +775,77 @@ func (d *decoder) decodeMap(v reflect.Value, src Elem) {
 			d.decodeString(key, kstr)
 			val.SetBool(true)
 		} else {
+			vf := f[1:]
 			if d.MapSym != "" {
-				if strings.HasSuffix(kstr, d.MapSym) {
-					kstr = kstr[:len(kstr)-len(d.MapSym)]
-				} else {
+				k, rest, ok := splitMapEntry(el.Text, d.MapSym)
+				kf := rc.Tokenize(k)
+				if !ok || len(kf) != 1 {
 					d.saveError(errors.New("missing map symbol '" + d.MapSym + "' in mapping"))
 					return
 				}
-
+				kstr = kf[0]
+				vf = rc.Tokenize(rest)
 			}
 			d.cur.field = kstr
 			d.decodeItem(key, Elem{LineNum: el.LineNum, Text: ".\t" + kstr})
 			if len(el.Children) == 0 {
-				d.decodeItem(val, Elem{LineNum: el.LineNum, Text: ".\t" + strings.Join(f[1:], " ")})
+				d.decodeItem(val, Elem{LineNum: el.LineNum, Text: ".\t" + strings.Join(vf, " ")})
 			} else {
 				d.decodeItem(val, el)
 			}
 		}
+		if seen != nil {
+			k := key.Interface()
+			if seen[k] {
+				val.Set(reflect.AppendSlice(v.MapIndex(key), val))
+			}
+			seen[k] = true
+		}
 		v.SetMapIndex(key, val)
 		key.Set(reflect.Zero(t.Key()))
 		val.Set(reflect.Zero(t.Elem()))
 	}
 }
 
+// splitMapEntry splits s at the first occurrence of sym that is
+// not enclosed in quotes. Further occurrences of sym, as in
+// "url: http://host", belong to the value.
+func splitMapEntry(s, sym string) (key, value string, ok bool) {
+	quoting := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\'':
+			quoting = !quoting
+		case !quoting && strings.HasPrefix(s[i:], sym):
+			return s[:i], s[i+len(sym):], true
+		}
+	}
+	return s, "", false
+}
+
+// expandEnv replaces references to variables in s by their values.
+func (d *decoder) expandEnv(s string) string {
+	return os.Expand(s, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		val, ok := d.LookupEnv(name)
+		if !ok && d.UndefinedVarErrors {
+			d.saveError(fmt.Errorf("undefined variable %q", name))
+		}
+		return val
+	})
+}
+
 func (d *decoder) decodeString(v reflect.Value, s string) {
 	switch v.Kind() {
 	default:
 		d.saveError(errors.New("data type not supported: " + v.Type().String()))
 
 	case reflect.String:
+		if d.LookupEnv != nil {
+			s = d.expandEnv(s)
+		}
 		v.SetString(s)
 
 	case reflect.Bool:
@@ -0,0 +1,469 @@
+package tidata
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/knieriem/text/line"
+)
+
+func parse(t *testing.T, src string) *Elem {
+	t.Helper()
+	el, err := NewReader(bufio.NewScanner(strings.NewReader(src))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return el
+}
+
+type Common struct {
+	Host string
+	Port int `tidata:"required"`
+}
+
+type Extra struct {
+	Debug bool
+}
+
+type embeddingConfig struct {
+	Common
+	*Extra
+	Name       string
+	SrcLineNum int
+}
+
+func TestDecodeEmbedded(t *testing.T) {
+	var c embeddingConfig
+	err := parse(t, "Name:\tx\nHost:\th\nPort:\t8\nDebug:\ttrue\n").Decode(&c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Name != "x" || c.Host != "h" || c.Port != 8 {
+		t.Errorf("unexpected result: %+v", c)
+	}
+	if c.Extra == nil || !c.Debug {
+		t.Errorf("embedded pointer not decoded: %+v", c.Extra)
+	}
+
+	c = embeddingConfig{}
+	err = parse(t, "Name:\tx\n").Decode(&c, nil)
+	if err == nil || !strings.Contains(err.Error(), "Port: required field missing") {
+		t.Errorf("missing required field in embedded struct not reported: %v", err)
+	}
+	if c.Extra != nil {
+		t.Errorf("embedded pointer allocated unnecessarily")
+	}
+}
+
+func TestDecodeSepTag(t *testing.T) {
+	var v struct {
+		Words  []string
+		Items  []string `tidata:"sep=,"`
+		Nums   []int    `tidata:"required,sep=,"`
+		Joined string   `tidata:"sep=;"`
+	}
+	el := parse(t, `Words:	a b  c
+Items:	x, f(y, z), w
+Nums:	1,2,3
+Joined:
+	p
+	q
+`)
+	if err := el.Decode(&v, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(v.Words, "|"); got != "a|b|c" {
+		t.Errorf("Words: got %q", got)
+	}
+	if got := strings.Join(v.Items, "|"); got != "x|f(y, z)|w" {
+		t.Errorf("Items: got %q", got)
+	}
+	if len(v.Nums) != 3 || v.Nums[2] != 3 {
+		t.Errorf("Nums: got %v", v.Nums)
+	}
+	if v.Joined != "p;q;" {
+		t.Errorf("Joined: got %q", v.Joined)
+	}
+}
+
+func TestDecodeSliceSep(t *testing.T) {
+	var v struct {
+		Tags  []string
+		Words []string `tidata:"sep= "`
+		Nums  []int
+	}
+	el := parse(t, `Tags:	a, b c, [d, e], f(g, h)
+Words:	x y
+Nums:	1, 2,3
+`)
+	c := dfltConfig
+	c.SliceSep = ","
+	if err := el.Decode(&v, &c); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(v.Tags, "|"); got != "a|b c|[d, e]|f(g, h)" {
+		t.Errorf("Tags: got %q", got)
+	}
+	if got := strings.Join(v.Words, "|"); got != "x|y" {
+		t.Errorf("Words: got %q", got)
+	}
+	if len(v.Nums) != 3 || v.Nums[1] != 2 {
+		t.Errorf("Nums: got %v", v.Nums)
+	}
+}
+
+func TestDecodeRequired(t *testing.T) {
+	type item struct {
+		ID   string `tidata:"required"`
+		Note string
+	}
+	var v struct {
+		Name  string   `tidata:"required"`
+		Alias []string `tidata:"required,sep=,"`
+		First item
+		Next  item
+	}
+	src := `Name:	a
+Alias:	x, y
+First:
+	ID:	1
+Next:
+	Note:	missing ID
+`
+	err := parse(t, src).Decode(&v, nil)
+	list, ok := err.(*line.ErrorList)
+	if !ok || len(list.List) != 1 {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if e, ok := list.List[0].(*Error); !ok || e.Line() != 5 || !strings.HasSuffix(e.Key, ".ID") {
+		t.Errorf("unexpected error: %v", list.List[0])
+	}
+	if got := strings.Join(v.Alias, " "); got != "x y" {
+		t.Errorf("Alias: got %q", got)
+	}
+
+	err = parse(t, "First:\n\tID:\t2\n").Decode(&v, nil)
+	list, ok = err.(*line.ErrorList)
+	if !ok || len(list.List) != 2 {
+		t.Fatalf("missing fields not reported: %v", err)
+	}
+	for _, err := range list.List {
+		if !strings.Contains(err.Error(), "required field missing") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+}
+
+type defaultsConfig struct {
+	Name    string   `tidata:"required"`
+	Port    int      `tidata:"default=8080"`
+	Verbose bool     `tidata:"default=false"`
+	Hosts   []string `tidata:"sep=,,default=a, b"`
+	Title   string   `tidata:"default=x,y"`
+}
+
+func TestDecodeDefaults(t *testing.T) {
+	var v defaultsConfig
+	el := parse(t, "Name:\tsrv\nVerbose:\ttrue\n")
+	if err := el.Decode(&v, nil); err != nil {
+		t.Fatal(err)
+	}
+	if v.Port != 8080 || !v.Verbose || v.Title != "x,y" {
+		t.Errorf("unexpected result: %+v", v)
+	}
+	if got := strings.Join(v.Hosts, "|"); got != "a|b" {
+		t.Errorf("Hosts: got %q", got)
+	}
+
+	v = defaultsConfig{}
+	err := parse(t, "Port:\t1\n").Decode(&v, nil)
+	if err == nil || !strings.Contains(err.Error(), "required field missing") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if v.Port != 1 {
+		t.Errorf("default overrides value: %d", v.Port)
+	}
+}
+
+func TestDecodeDefaultRequiredConflict(t *testing.T) {
+	var v struct {
+		A int `tidata:"required,default=1"`
+	}
+	err := parse(t, "A:\t2\n").Decode(&v, nil)
+	if err == nil || !strings.Contains(err.Error(), "mutually exclusive") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+type server struct {
+	Name string
+	Port int
+}
+
+type keyedConfig struct {
+	Servers map[string]server `tidata:"key=Name"`
+}
+
+func TestDecodeKeyedMap(t *testing.T) {
+	var v keyedConfig
+	el := parse(t, `Servers:
+	server
+		Name:	a
+		Port:	1
+	server
+		Name:	b
+		Port:	2
+`)
+	if err := el.Decode(&v, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Servers) != 2 || v.Servers["a"].Port != 1 || v.Servers["b"].Port != 2 {
+		t.Errorf("unexpected result: %+v", v.Servers)
+	}
+
+	v = keyedConfig{}
+	el = parse(t, `Servers:
+	server
+		Name:	a
+	server
+		Name:	a
+`)
+	err := el.Decode(&v, nil)
+	if err == nil || !strings.Contains(err.Error(), `duplicate key "a"`) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDecodeExpandEnv(t *testing.T) {
+	env := map[string]string{"HOME": "/home/u", "N": "5"}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+	var v struct {
+		Dir   string
+		Price string
+		Dirs  []string
+		Num   int
+		Undef string
+	}
+	el := parse(t, `Dir:	${HOME}/data
+Price:	$$5
+Dirs:	$HOME/a 'b c'
+Num:	5
+Undef:	x${NONE}y
+`)
+	c := dfltConfig
+	c.LookupEnv = lookup
+	if err := el.Decode(&v, &c); err != nil {
+		t.Fatal(err)
+	}
+	if v.Dir != "/home/u/data" || v.Price != "$5" || v.Undef != "xy" {
+		t.Errorf("unexpected result: %+v", v)
+	}
+	if len(v.Dirs) != 2 || v.Dirs[0] != "/home/u/a" || v.Dirs[1] != "b c" {
+		t.Errorf("unexpected slice: %q", v.Dirs)
+	}
+
+	c.UndefinedVarErrors = true
+	err := el.Decode(&v, &c)
+	list, ok := err.(*line.ErrorList)
+	if !ok || len(list.List) != 1 || !strings.Contains(list.Error(), `undefined variable "NONE"`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if e, ok := list.List[0].(line.Error); !ok || e.Line() != 5 {
+		t.Errorf("unexpected error: %v", list.List[0])
+	}
+
+	// by default, values are not expanded
+	if err := el.Decode(&v, nil); err != nil {
+		t.Fatal(err)
+	}
+	if v.Dir != "${HOME}/data" {
+		t.Errorf("unexpected expansion: %q", v.Dir)
+	}
+}
+
+func TestDecodeMapMultiValue(t *testing.T) {
+	v := struct {
+		Hosts map[string][]string
+		Names map[string]string
+	}{
+		Hosts: map[string][]string{"host": {"old"}},
+	}
+	el := parse(t, `Hosts:
+	host:	a
+	port:	1 2
+	host:	b
+	host:
+		c
+		d
+Names:
+	x:	1
+	x:	2
+`)
+	if err := el.Decode(&v, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"host": {"a", "b", "c", "d"},
+		"port": {"1", "2"},
+	}
+	if !reflect.DeepEqual(v.Hosts, want) {
+		t.Errorf("unexpected result: %q", v.Hosts)
+	}
+	if s := v.Names["x"]; s != "2" {
+		t.Errorf("scalar map value: got %q, want %q", s, "2")
+	}
+}
+
+func TestDecodeMapSymInValue(t *testing.T) {
+	var v struct {
+		Links map[string]string
+		Times map[string]string
+	}
+	el := parse(t, `Links:
+	home:	http://example.com:8080/a
+	docs:https://example.com/docs
+	'a:b':	c
+Times:
+	start:	12:30:00
+`)
+	if err := el.Decode(&v, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"home": "http://example.com:8080/a",
+		"docs": "https://example.com/docs",
+		"a:b":  "c",
+	}
+	for k, s := range want {
+		if v.Links[k] != s {
+			t.Errorf("Links[%q] = %q, want %q", k, v.Links[k], s)
+		}
+	}
+	if len(v.Links) != len(want) {
+		t.Errorf("unexpected keys: %v", v.Links)
+	}
+	if s := v.Times["start"]; s != "12:30:00" {
+		t.Errorf("unexpected time: %q", s)
+	}
+
+	el = parse(t, "Links:\n\thome http://x\n")
+	err := el.Decode(&v, nil)
+	if err == nil || !strings.Contains(err.Error(), "missing map symbol") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDecodeNestedSlices(t *testing.T) {
+	var v struct {
+		Matrix [][]int
+		Rows   [][]string
+		Flat   []int
+	}
+	el := parse(t, `Matrix:
+	1 2 3
+	4 5 6
+Rows:
+	a b
+	row
+		c
+		d e
+Flat:	7 8 9
+`)
+	if err := el.Decode(&v, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(v.Matrix); got != "[[1 2 3] [4 5 6]]" {
+		t.Errorf("Matrix: got %s", got)
+	}
+	if got := fmt.Sprintf("%q", v.Rows); got != `[["a" "b"] ["c" "d e"]]` {
+		t.Errorf("Rows: got %s", got)
+	}
+	if got := fmt.Sprint(v.Flat); got != "[7 8 9]" {
+		t.Errorf("Flat: got %s", got)
+	}
+}
+
+func TestDecodeUnknownFieldWarnings(t *testing.T) {
+	var v struct {
+		Name string
+	}
+	src := "Name:\tx\nColor:\tred\n"
+	c := dfltConfig
+	c.UnknownFieldWarnings = true
+	var warnings []error
+	c.Warn = func(err error) {
+		warnings = append(warnings, err)
+	}
+	err := parse(t, src).Decode(&v, &c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("unexpected warnings: %v", warnings)
+	}
+	if e, ok := warnings[0].(*Error); !ok || e.Line() != 2 || line.SeverityOf(e) != line.SeverityWarning {
+		t.Errorf("unexpected warning: %v", warnings[0])
+	}
+	if v.Name != "x" {
+		t.Errorf("unexpected value: %q", v.Name)
+	}
+	c.Warn = nil
+	if err := parse(t, src).Decode(&v, &c); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err = parse(t, src).Decode(&v, nil)
+	if list, ok := err.(*line.ErrorList); !ok || !list.HasErrors() {
+		t.Errorf("unknown field not reported as error: %v", err)
+	}
+}
+
+func TestDecoderNext(t *testing.T) {
+	src := `# servers
+server
+	Host:	a
+	Port:	1
+server
+	Host:	b
+	Port:	x
+server
+	Host:	c
+	Port:	3
+`
+	d := NewDecoder(bufio.NewScanner(strings.NewReader(src)), nil)
+	d.Reader().CommentPrefix = "#"
+	var hosts []string
+	var errs []error
+	for {
+		var c Common
+		err := d.Next(&c)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		hosts = append(hosts, fmt.Sprint(c.Host, c.Port))
+	}
+	if s := strings.Join(hosts, " "); s != "a1 c3" {
+		t.Errorf("unexpected result: %s", s)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	el, ok := errs[0].(*line.ErrorList)
+	if !ok || len(el.List) == 0 {
+		t.Fatalf("unexpected error: %#v", errs[0])
+	}
+	if e, ok := el.List[0].(line.Error); !ok || e.Line() != 7 {
+		t.Errorf("unexpected error: %v", el.List[0])
+	}
+}
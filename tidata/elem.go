@@ -1,14 +1,21 @@
 package tidata
 
 import (
+	"errors"
 	"fmt"
 	"strings"
+
+	"github.com/knieriem/text/rc"
 )
 
 type Elem struct {
 	Text     string
 	Children []Elem
 	LineNum  int
+
+	// col is the column of Text within the source line, counting
+	// from 1, or zero if unknown.
+	col int
 }
 
 func (e *Elem) String() string {
@@ -27,6 +34,36 @@ func (e *Elem) pfxString(pfx string) string {
 	return s
 }
 
+// SkipChildren may be returned by the function passed to Walk
+// to make it skip the children of the current Elem.
+var SkipChildren = errors.New("skip children")
+
+// Walk traverses the descendants of e in pre-order, calling fn for
+// each Elem, starting with the direct children of e, which have
+// depth 0. If fn returns SkipChildren, the children of the Elem in
+// question are not visited; any other error aborts the walk,
+// and is returned by Walk. The Elem passed to fn may be modified.
+func (e *Elem) Walk(fn func(e *Elem, depth int) error) error {
+	return e.walk(fn, 0)
+}
+
+func (e *Elem) walk(fn func(e *Elem, depth int) error, depth int) error {
+	for i := range e.Children {
+		c := &e.Children[i]
+		err := fn(c, depth)
+		if err == SkipChildren {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err = c.walk(fn, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (e *Elem) JoinSubElems(initialIndent, indent, sep string) string {
 	val := ""
 	prefix := initialIndent
@@ -53,16 +90,57 @@ func (e Elem) Key() (key string) {
 	return
 }
 
+// Attrs parses the value of e as a list of attributes in short form,
+// as accepted by Decode for the fields of a struct: Each attribute is
+// either a name, followed by sep and a value, or a bare name, which
+// is assigned the value "true". Attributes are separated by white
+// space, and may be quoted. If sep is empty, "=" is used. An error is
+// returned if a name is empty, or if it is specified more than once.
+func (e Elem) Attrs(sep string) (map[string]string, error) {
+	list, err := parseAttrs(e.Value(), sep)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string, len(list))
+	for _, a := range list {
+		if _, ok := m[a.name]; ok {
+			return nil, fmt.Errorf("tidata: duplicate attribute %q", a.name)
+		}
+		m[a.name] = a.value
+	}
+	return m, nil
+}
+
+type attr struct {
+	name, value string
+}
+
+// parseAttrs splits s into attributes in short form, see Attrs.
+func parseAttrs(s, sep string) (list []attr, err error) {
+	if sep == "" {
+		sep = "="
+	}
+	for _, x := range rc.Tokenize(s) {
+		a := attr{name: x, value: "true"}
+		if i := strings.Index(x, sep); i != -1 {
+			a.name, a.value = x[:i], x[i+len(sep):]
+		}
+		if a.name == "" {
+			return nil, fmt.Errorf("tidata: missing attribute name in %q", x)
+		}
+		list = append(list, a)
+	}
+	return list, nil
+}
+
 // Find the first occurance of ‘key’ in the list of childs,
 // on success, return the corresponding slice index
 // and a pointer to the Elem. Otherwise, return nil.
 func (el *Elem) Lookup(key string) (i int, e *Elem) {
 	var c Elem
 
-	pfxTab := key + "\t"
-	pfxSpace := key + " "
 	for i, c = range el.Children {
-		if key == c.Text || strings.HasPrefix(c.Text, pfxTab) || strings.HasPrefix(c.Text, pfxSpace) {
+		if c.hasKey(key) {
 			e = &c
 			break
 		}
@@ -70,6 +148,45 @@ func (el *Elem) Lookup(key string) (i int, e *Elem) {
 	return
 }
 
+// LookupPath descends through the children of el, looking up
+// each key in turn at the next level, as done by Lookup.
+// It returns the element found for the last key; if any key
+// cannot be found, ok is false.
+func (el *Elem) LookupPath(keys ...string) (e *Elem, ok bool) {
+	e = el
+	for _, key := range keys {
+		if _, e = e.Lookup(key); e == nil {
+			return nil, false
+		}
+	}
+	return e, true
+}
+
+// CountKey returns the number of direct children matching key,
+// as determined by the same rules Lookup uses.
+func (el *Elem) CountKey(key string) int {
+	n := 0
+	for i := range el.Children {
+		if el.Children[i].hasKey(key) {
+			n++
+		}
+	}
+	return n
+}
+
+func (e *Elem) hasKey(key string) bool {
+	if !strings.HasPrefix(e.Text, key) {
+		return false
+	}
+	switch rest := e.Text[len(key):]; {
+	case rest == "":
+		return true
+	case rest[0] == '\t', rest[0] == ' ':
+		return true
+	}
+	return false
+}
+
 func (el *Elem) Match(key string) bool {
 	if strings.HasPrefix(el.Text, key+"\t") || key == el.Text {
 		return true
@@ -0,0 +1,144 @@
+package tidata
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/knieriem/text/line"
+)
+
+func TestCountKey(t *testing.T) {
+	el := parse(t, `server a
+	port 1
+server	b
+servers
+	x
+server
+client c
+`)
+	if n := el.CountKey("server"); n != 3 {
+		t.Errorf("CountKey(server): got %d, want 3", n)
+	}
+	if n := el.CountKey("client"); n != 1 {
+		t.Errorf("CountKey(client): got %d, want 1", n)
+	}
+	if n := el.CountKey("port"); n != 0 {
+		t.Errorf("CountKey(port): got %d, want 0", n)
+	}
+}
+
+func TestLookupPath(t *testing.T) {
+	el := parse(t, `server a
+	net
+		port 1
+client c
+`)
+	e, ok := el.LookupPath("server", "net", "port")
+	if !ok || e.Value() != "1" {
+		t.Errorf("LookupPath(server, net, port): got %v, %v", e, ok)
+	}
+	if e, ok := el.LookupPath("server", "port"); ok || e != nil {
+		t.Errorf("LookupPath(server, port): got %v, %v", e, ok)
+	}
+	if e, ok := el.LookupPath(); !ok || e != el {
+		t.Errorf("LookupPath(): got %v, %v", e, ok)
+	}
+}
+
+func TestErrorColumn(t *testing.T) {
+	src := "a\n\tb  \n"
+	_, err := NewReader(bufio.NewScanner(strings.NewReader(src))).ReadAll()
+	list, ok := err.(*line.ErrorList)
+	if !ok || len(list.List) == 0 {
+		t.Fatalf("parse: unexpected error %v", err)
+	}
+	ce, ok := list.List[0].(line.ColumnError)
+	if !ok {
+		t.Fatalf("parse: got %T, want line.ColumnError", list.List[0])
+	}
+	if ce.Line() != 2 || ce.Column() != 3 {
+		t.Errorf("parse: got %d:%d, want 2:3", ce.Line(), ce.Column())
+	}
+
+	var v struct {
+		Name string
+		Sub  struct {
+			Port int
+		}
+	}
+	el := parse(t, "Name:\tx\nSub:\n\tPort\t1\n")
+	err = el.Decode(&v, nil)
+	list, ok = err.(*line.ErrorList)
+	if !ok || len(list.List) == 0 {
+		t.Fatalf("decode: unexpected error %v", err)
+	}
+	ce, ok = list.List[0].(line.ColumnError)
+	if !ok {
+		t.Fatalf("decode: got %T, want line.ColumnError", list.List[0])
+	}
+	if ce.Line() != 3 || ce.Column() != 6 {
+		t.Errorf("decode: got %d:%d, want 3:6", ce.Line(), ce.Column())
+	}
+}
+
+func TestWalk(t *testing.T) {
+	el := parse(t, "a\n\tb\n\t\tc\n\td\ne\n\tf\n")
+	var visited []string
+	err := el.Walk(func(e *Elem, depth int) error {
+		visited = append(visited, fmt.Sprint(e.Text, depth))
+		if e.Text == "b" {
+			return SkipChildren
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := strings.Join(visited, " "); s != "a0 b1 d1 e0 f1" {
+		t.Errorf("unexpected visiting order: %s", s)
+	}
+
+	errStop := errors.New("stop")
+	n := 0
+	err = el.Walk(func(e *Elem, _ int) error {
+		n++
+		if e.Text == "d" {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop || n != 4 {
+		t.Errorf("walk not aborted: %v, %d", err, n)
+	}
+}
+
+func TestAttrs(t *testing.T) {
+	el := Elem{Text: "server\thost=a 'name=x y' debug port:=8"}
+	m, err := el.Attrs("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"host": "a", "name": "x y", "debug": "true", "port:": "8"}
+	if len(m) != len(want) {
+		t.Errorf("unexpected result: %v", m)
+	}
+	for k, v := range want {
+		if m[k] != v {
+			t.Errorf("%s: got %q, want %q", k, m[k], v)
+		}
+	}
+	if m, err := el.Attrs(":="); err != nil || m["port"] != "8" || m["host=a"] != "true" {
+		t.Errorf("custom separator: %v, %v", m, err)
+	}
+	for _, s := range []string{"x\t=1", "x\ta=1 a=2"} {
+		if _, err := (Elem{Text: s}).Attrs(""); err == nil {
+			t.Errorf("%q: error expected", s)
+		}
+	}
+	if m, err := (Elem{Text: "x"}).Attrs(""); err != nil || len(m) != 0 {
+		t.Errorf("empty value: %v, %v", m, err)
+	}
+}
@@ -0,0 +1,435 @@
+package tidata
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/knieriem/text/rc"
+)
+
+// An Encoder writes values as tab indented trees, in a form that
+// Decode, using the same Config, turns into equal values.
+type Encoder struct {
+	// CommentPrefix, if not empty, should be set to the comment
+	// prefix of the Reader that will read the output. Values that
+	// would be taken as a comment, or as an inline comment, are
+	// then rejected.
+	CommentPrefix string
+
+	w    io.Writer
+	conf *Config
+}
+
+// NewEncoder returns an Encoder writing to w using configuration c,
+// which may be nil. Keys are derived from field names using
+// c.FieldNameToKey.
+func NewEncoder(w io.Writer, c *Config) *Encoder {
+	if c == nil {
+		c = &dfltConfig
+	}
+	return &Encoder{w: w, conf: c}
+}
+
+// An EncodeError reports a value that cannot be encoded;
+// Field is the path of the affected struct field.
+type EncodeError struct {
+	Field string
+	Err   error
+}
+
+func (e *EncodeError) Error() string {
+	return "tidata: " + e.Field + ": " + e.Err.Error()
+}
+
+func (e *EncodeError) Unwrap() error {
+	return e.Err
+}
+
+// Encode writes v, a struct or a pointer to a struct, to the
+// Encoder's output. Each exported field becomes an element, except
+// fields holding nil pointers, nil maps, or nil slices, which are
+// omitted; fields of embedded structs are written as if they were
+// fields of v. Slices of scalar values are written on a single line,
+// an empty slice as a key without a value, which Decode turns into
+// an empty slice again. Slices of structs are written as repeated
+// elements, as expected by Decode; they are omitted if empty, and
+// decoded as nil. If a value cannot be
+// represented, e.g. a string containing line breaks, while the
+// relevant separator is not "\n", an *EncodeError is returned, and
+// nothing is written.
+func (e *Encoder) Encode(v interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return errors.New("tidata: argument is not a struct or a pointer to a struct")
+	}
+	var b strings.Builder
+	if err := e.encodeFields(&b, rv, ""); err != nil {
+		return err
+	}
+	_, err := io.WriteString(e.w, b.String())
+	return err
+}
+
+func (e *Encoder) encodeFields(b *strings.Builder, v reflect.Value, indent string) error {
+	t := v.Type()
+	for i, n := 0, t.NumField(); i < n; i++ {
+		f := t.Field(i)
+		fv := v.Field(i)
+		if f.Anonymous {
+			if fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				if err := e.encodeFields(b, fv, indent); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		switch f.Name {
+		case "SrcLineNum", "TidataElem", "TidataSeen":
+			continue
+		}
+		if err := e.encodeField(b, fv, f, indent); err != nil {
+			if ee, ok := err.(*EncodeError); ok {
+				ee.Field = f.Name + "." + ee.Field
+				return ee
+			}
+			return &EncodeError{Field: f.Name, Err: err}
+		}
+	}
+	return nil
+}
+
+func (e *Encoder) encodeField(b *strings.Builder, v reflect.Value, f reflect.StructField, indent string) error {
+	if hasTagOption(f, "any") {
+		return errors.New("tag option any not supported")
+	}
+	key := f.Name
+	if fn := e.conf.FieldNameToKey; fn != nil {
+		key = fn(key)
+	}
+	key += e.conf.Sep
+	sep, _ := tagValue(f, "sep")
+
+	// Slices that Decode combines from multiple
+	// elements are written as repeated elements.
+	if v.Kind() == reflect.Slice && (hasTagOption(f, "combine") || isCombined(v.Type().Elem())) {
+		for i, n := 0, v.Len(); i < n; i++ {
+			if err := e.encodeItem(b, indent, key, v.Index(i), sep); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return e.encodeItem(b, indent, key, v, sep)
+}
+
+// isCombined reports whether Decode combines multiple elements
+// into a slice with element type t, see decodeStruct.
+func isCombined(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	var tu encoding.TextUnmarshaler
+	return t.Kind() == reflect.Struct && !reflect.PtrTo(t).Implements(reflect.TypeOf(&tu).Elem())
+}
+
+func (e *Encoder) encodeItem(b *strings.Builder, indent, key string, v reflect.Value, sep string) error {
+	if s, ok, err := scalarString(v); ok {
+		if err != nil {
+			return err
+		}
+		if v.Kind() == reflect.String || isTextMarshaler(v) {
+			return e.writeString(b, indent, key, s, sep)
+		}
+		b.WriteString(indent + key + "\t" + s + "\n")
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return e.encodeItem(b, indent, key, v.Elem(), sep)
+	case reflect.Struct:
+		b.WriteString(indent + key + "\n")
+		return e.encodeFields(b, v, indent+"\t")
+	case reflect.Slice:
+		return e.encodeSlice(b, indent, key, v, sep)
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		b.WriteString(indent + key + "\n")
+		return e.encodeMapEntries(b, v, indent+"\t")
+	}
+	return errors.New("data type not supported: " + v.Type().String())
+}
+
+// encodeSlice writes the elements of a slice of scalar values on
+// a single line, or, in case of nested slices, each inner slice
+// on a line of its own.
+func (e *Encoder) encodeSlice(b *strings.Builder, indent, key string, v reflect.Value, sep string) error {
+	if v.IsNil() {
+		return nil
+	}
+	if v.Len() == 0 {
+		b.WriteString(indent + key + "\n")
+		return nil
+	}
+	if et := v.Type().Elem(); et.Kind() == reflect.Slice && !reflect.PtrTo(et).Implements(textMarshalerType) {
+		b.WriteString(indent + key + "\n")
+		for i, n := 0, v.Len(); i < n; i++ {
+			line, err := e.inlineList(v.Index(i), "")
+			if err != nil {
+				return err
+			}
+			if line == "" {
+				return errors.New("empty nested slice cannot be represented")
+			}
+			b.WriteString(indent + "\t" + line + "\n")
+		}
+		return nil
+	}
+	line, err := e.inlineList(v, sep)
+	if err != nil {
+		return err
+	}
+	b.WriteString(indent + key + "\t" + line + "\n")
+	return nil
+}
+
+// inlineList returns the elements of v, which must be scalar
+// values, separated by sep, or by the Config's SliceSep. If both
+// are empty, the elements are quoted as tokens and separated by spaces.
+func (e *Encoder) inlineList(v reflect.Value, sep string) (string, error) {
+	if sep == "" {
+		sep = e.conf.SliceSep
+	}
+	list := make([]string, v.Len())
+	for i := range list {
+		s, ok, err := scalarString(v.Index(i))
+		if !ok {
+			return "", errors.New("data type not supported in slice: " + v.Type().Elem().String())
+		}
+		if err != nil {
+			return "", err
+		}
+		if sep == "" {
+			s = quoteToken(s)
+		} else if strings.Contains(s, sep) || s != strings.TrimSpace(s) {
+			return "", fmt.Errorf("slice element %q cannot be represented", s)
+		}
+		if err := e.checkValue(s); err != nil {
+			return "", err
+		}
+		list[i] = s
+	}
+	if sep == "" {
+		return strings.Join(list, " "), nil
+	}
+	return strings.Join(list, sep), nil
+}
+
+// encodeMapEntries writes the entries of map v, sorted by key.
+func (e *Encoder) encodeMapEntries(b *strings.Builder, v reflect.Value, indent string) error {
+	type entry struct {
+		key string
+		val reflect.Value
+	}
+	var list []entry
+	iter := v.MapRange()
+	for iter.Next() {
+		k, ok, err := scalarString(iter.Key())
+		if !ok {
+			return errors.New("map key type not supported: " + v.Type().Key().String())
+		}
+		if err != nil {
+			return err
+		}
+		list = append(list, entry{k, iter.Value()})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].key < list[j].key
+	})
+
+	sym := e.conf.MapSym
+	for _, ent := range list {
+		key := quoteToken(ent.key)
+		if sym != "" && strings.Contains(key, sym) {
+			key = "'" + strings.Replace(ent.key, "'", "''", -1) + "'"
+		}
+		if err := e.checkValue(key); err != nil {
+			return err
+		}
+		key += sym
+
+		val := ent.val
+		for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+			if val.IsNil() {
+				return fmt.Errorf("map entry %q: nil value cannot be represented", ent.key)
+			}
+			val = val.Elem()
+		}
+		if s, ok, err := scalarString(val); ok {
+			if err != nil {
+				return err
+			}
+			s = quoteToken(s)
+			if err := e.checkValue(s); err != nil {
+				return err
+			}
+			b.WriteString(indent + key + "\t" + s + "\n")
+			continue
+		}
+		if val.Kind() == reflect.Slice {
+			// Decode splits the rest of an entry's line into
+			// tokens once more, so elements are written
+			// as children, one per line.
+			b.WriteString(indent + key + "\n")
+			for i, n := 0, val.Len(); i < n; i++ {
+				s, ok, err := scalarString(val.Index(i))
+				if !ok {
+					return errors.New("data type not supported in slice: " + val.Type().Elem().String())
+				}
+				if err != nil {
+					return err
+				}
+				if s == "" || s != strings.TrimSpace(s) {
+					return fmt.Errorf("slice element %q cannot be represented", s)
+				}
+				if err := e.checkValue(s); err != nil {
+					return err
+				}
+				b.WriteString(indent + "\t" + s + "\n")
+			}
+			continue
+		}
+		if err := e.encodeItem(b, indent, key, val, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeString writes a string value. If it cannot be written on the
+// key's line, but ends with sep, or with the Config's MultiStringSep,
+// its parts are written as children, which Decode joins again.
+func (e *Encoder) writeString(b *strings.Builder, indent, key, s, sep string) error {
+	if s == "" {
+		b.WriteString(indent + key + "\n")
+		return nil
+	}
+	err := e.checkLine(s)
+	if err == nil {
+		b.WriteString(indent + key + "\t" + s + "\n")
+		return nil
+	}
+	if sep == "" {
+		sep = e.conf.MultiStringSep
+	}
+	if sep == "" || !strings.HasSuffix(s, sep) {
+		return err
+	}
+	lines := strings.Split(strings.TrimSuffix(s, sep), sep)
+	for _, l := range lines {
+		if l == "" || l != strings.TrimLeft(l, " \t") {
+			return fmt.Errorf("string %q cannot be represented", s)
+		}
+		if err := e.checkLine(l); err != nil {
+			return err
+		}
+	}
+	b.WriteString(indent + key + "\n")
+	for _, l := range lines {
+		b.WriteString(indent + "\t" + l + "\n")
+	}
+	return nil
+}
+
+// checkLine reports an error if s cannot be written
+// as the value part of a line.
+func (e *Encoder) checkLine(s string) error {
+	if strings.ContainsAny(s, "\r\n") || s != strings.TrimRight(s, " \t") {
+		return fmt.Errorf("string %q cannot be represented", s)
+	}
+	return e.checkValue(s)
+}
+
+// checkValue reports an error if s contains something
+// that would be taken as an inline comment.
+func (e *Encoder) checkValue(s string) error {
+	c := e.CommentPrefix
+	if c == "" {
+		return nil
+	}
+	if strings.HasPrefix(s, c) || strings.Contains(s, " "+c) || strings.Contains(s, "\t"+c) {
+		return fmt.Errorf("value %q would be taken as a comment", s)
+	}
+	return nil
+}
+
+// quoteToken quotes s so that rc.Tokenize results in a single token.
+func quoteToken(s string) string {
+	if s == "" {
+		return "''"
+	}
+	return rc.Quote(s)
+}
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+func isTextMarshaler(v reflect.Value) bool {
+	_, ok := textMarshaler(v)
+	return ok
+}
+
+func textMarshaler(v reflect.Value) (encoding.TextMarshaler, bool) {
+	if v.Kind() == reflect.Ptr && v.IsNil() || !v.CanInterface() {
+		return nil, false
+	}
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		return m, true
+	}
+	if v.CanAddr() {
+		m, ok := v.Addr().Interface().(encoding.TextMarshaler)
+		return m, ok
+	}
+	return nil, false
+}
+
+// scalarString formats v, if it is a value of a basic type, or
+// implements encoding.TextMarshaler, in a form decodeString, or
+// UnmarshalText, accepts. If v is of another kind, ok is false.
+func scalarString(v reflect.Value) (s string, ok bool, err error) {
+	if m, ok := textMarshaler(v); ok {
+		text, err := m.MarshalText()
+		return string(text), true, err
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), true, nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), true, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), true, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), true, nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), true, nil
+	}
+	return "", false, nil
+}
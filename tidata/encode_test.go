@@ -0,0 +1,81 @@
+package tidata
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type encodeTarget struct {
+	Name  string
+	Count int
+	Ratio float64
+	Off   bool
+	Tags  []string
+	IPs   []net.IP
+	Rows  [][]int
+	Text  string
+	Env   map[string]string
+	Flags map[string]bool
+	Lists map[string][]string
+	Peer  *Common
+	None  *Common
+	Items []Common
+	Common
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	in := encodeTarget{
+		Name:  " spaced name",
+		Count: -3,
+		Ratio: 0.25,
+		Tags:  []string{"a", "b c", "", "it's"},
+		IPs:   []net.IP{net.IPv4(10, 0, 0, 1)},
+		Rows:  [][]int{{1, 2}, {3}},
+		Text:  "line 1\nline 2\n",
+		Env:   map[string]string{"k:1": "v: x", "b": ""},
+		Flags: map[string]bool{"on": true, "off": false},
+		Lists: map[string][]string{"l": {"x y", "z"}},
+		Peer:  &Common{Host: "peer", Port: 2},
+		Items: []Common{{Host: "a", Port: 3}, {Host: "b", Port: 4}},
+		Common: Common{
+			Host: "h",
+			Port: 1,
+		},
+	}
+	var b strings.Builder
+	if err := NewEncoder(&b, nil).Encode(&in); err != nil {
+		t.Fatal(err)
+	}
+	var out encodeTarget
+	err := parse(t, b.String()).Decode(&out, nil)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, b.String())
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("result differs:\n%+v\nwant:\n%+v\nencoded:\n%s", out, in, b.String())
+	}
+}
+
+func TestEncodeError(t *testing.T) {
+	var conf struct {
+		Items []struct {
+			Note string
+		}
+	}
+	conf.Items = append(conf.Items, struct{ Note string }{"x # no comment"})
+
+	var b strings.Builder
+	enc := NewEncoder(&b, nil)
+	enc.CommentPrefix = "#"
+	err := enc.Encode(&conf)
+	var ee *EncodeError
+	if !errors.As(err, &ee) || ee.Field != "Items.Note" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b.Len() != 0 {
+		t.Errorf("output written despite error: %q", b.String())
+	}
+}
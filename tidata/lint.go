@@ -0,0 +1,119 @@
+package tidata
+
+import (
+	"bufio"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/knieriem/text"
+	"github.com/knieriem/text/line"
+)
+
+// Lint reads a document from r and checks its structure. In contrast
+// to the Reader, which stops at the first problem, it reports all
+// issues found: white-space at the end of lines, including comment
+// lines, spaces used for indentation, and lines indented too deeply.
+// Lines starting with "#", possibly after indentation, are treated
+// as comments.
+//
+// If schema is not nil, the document is also decoded, using
+// configuration c, into a new value of schema's type, which must be
+// a struct, or a pointer to a struct. This way keys not corresponding
+// to a field, and keys defined more than once, are reported. Without
+// a schema duplicate keys are not detected, since repeated keys may
+// form a list. The schema is passed separately from c, which, as
+// for Decode, only contains the options controlling the decoding.
+// Warnings reported by Decode, see Config.UnknownFieldWarnings, are
+// included in the result instead of being passed to c.Warn.
+//
+// The issues are returned sorted by line number. If none have been
+// found, Lint returns nil.
+func Lint(r io.Reader, schema interface{}, c *Config) *line.ErrorList {
+	list := new(line.ErrorList)
+
+	// Collect the issues of each line, and create a repaired
+	// version of the document that can be parsed afterwards.
+	var doc strings.Builder
+	ls := text.NewLineScanner(bufio.NewScanner(r))
+	depth := -1
+	for ls.Scan() {
+		s := ls.Text()
+		if ls.LineNum() == 1 {
+			s = text.StripBOM(s)
+		}
+		doc.WriteString(lintLine(list, ls.LineNum(), s, &depth))
+		doc.WriteByte('\n')
+	}
+	if err := ls.Err(); err != nil {
+		list.Add(err)
+		return list
+	}
+
+	tr := NewReader(bufio.NewScanner(strings.NewReader(doc.String())))
+	tr.CommentPrefix = "#"
+	tr.CommentPrefixEscaped = `\#`
+	top, err := tr.ReadAll()
+	if err == nil && schema != nil {
+		t := reflect.TypeOf(schema)
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if c != nil {
+			cc := *c
+			cc.Warn = list.Add
+			c = &cc
+		}
+		err = top.Decode(reflect.New(t).Interface(), c)
+	}
+	if el, ok := err.(*line.ErrorList); ok {
+		list.List = append(list.List, el.List...)
+	} else if err != nil {
+		list.Add(err)
+	}
+
+	if len(list.List) == 0 {
+		return nil
+	}
+	sort.Stable(list)
+	return list
+}
+
+// lintLine adds the issues found in line s to list, and returns
+// the line with these issues fixed. Depth is the indentation
+// depth of the previous data line; it will be updated.
+func lintLine(list *line.ErrorList, lineNum int, s string, depth *int) string {
+	body := strings.TrimLeft(s, " \t")
+	if body == "" {
+		if s != "" {
+			list.Add(line.NewMsgCol(lineNum, 1, "extra white-space at the end of the line"))
+		}
+		return ""
+	}
+	indent := s[:len(s)-len(body)]
+
+	if t := strings.TrimRight(body, " \t"); t != body {
+		col := len(indent) + len(t) + 1
+		list.Add(line.NewMsgCol(lineNum, col, "extra white-space at the end of the line"))
+		body = t
+	}
+	if strings.HasPrefix(body, "#") {
+		return indent + body
+	}
+
+	n := strings.Count(indent, "\t")
+	if i := strings.IndexByte(indent, ' '); i != -1 {
+		msg := "extra space character near start of line"
+		if isSpaceIndent(s[i:], i) {
+			msg = "space used for indentation; expected tab"
+		}
+		list.Add(line.NewMsgCol(lineNum, i+1, msg))
+	}
+	if n > *depth+1 {
+		list.Add(line.NewMsgCol(lineNum, *depth+2, "wrong depth"))
+		n = *depth + 1
+	}
+	*depth = n
+	return strings.Repeat("\t", n) + body
+}
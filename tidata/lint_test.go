@@ -0,0 +1,61 @@
+package tidata
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/knieriem/text/line"
+)
+
+func TestLint(t *testing.T) {
+	src := "# comment \n" +
+		"Host:\th \n" +
+		"Port:\t1\n" +
+		"Port:\t2\n" +
+		"  Host:\tx\n" +
+		"Colour:\tred\n" +
+		"\t\tshade:\tdark\n" +
+		"\n"
+	list := Lint(strings.NewReader(src), &Common{}, nil)
+	if list == nil {
+		t.Fatal("no issues reported")
+	}
+	want := []string{
+		"1: extra white-space at the end of the line",
+		"2: extra white-space at the end of the line",
+		"4: tidata: Port:: field defined more than once",
+		"5: space used for indentation; expected tab",
+		"5: tidata: Host:: field defined more than once",
+		"6: tidata: Colour:: field does not exist",
+		"7: wrong depth",
+	}
+	if got := list.Messages(); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected issues:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// without a schema, only structural issues are reported
+	list = Lint(strings.NewReader(src), nil, nil)
+	if n := len(list.List); n != 4 {
+		t.Errorf("unexpected issues without schema: %v", list.Messages())
+	}
+
+	if list := Lint(strings.NewReader("Host:\th\nPort:\t1\n"), &Common{}, nil); list != nil {
+		t.Errorf("unexpected issues: %v", list.Messages())
+	}
+
+	// warnings are included in the result
+	warned := false
+	c := dfltConfig
+	c.UnknownFieldWarnings = true
+	c.Warn = func(error) {
+		warned = true
+	}
+	list = Lint(strings.NewReader("Host:\th\nPort:\t1\nColour:\tred\n"), &Common{}, &c)
+	if list == nil || len(list.List) != 1 || line.SeverityOf(list.List[0]) != line.SeverityWarning {
+		t.Errorf("unexpected issues: %v", list)
+	}
+	if warned {
+		t.Error("warning passed to Config.Warn")
+	}
+}
@@ -8,10 +8,14 @@
 //	Comment	:=	{ \t } CommentPfx \n
 //
 // Whitespace surrounding a Key/Value pair will be stripped. The comment prefix
-// can be configured.
+// can be configured; multiple prefixes may be specified. Unless disabled,
+// text following a comment prefix that is preceded by white-space,
+// and not part of a quoted string, is stripped as an inline comment.
 package tidata
 
 import (
+	"bufio"
+	"io"
 	"regexp"
 	"strings"
 
@@ -22,12 +26,33 @@ import (
 type Reader struct {
 	CommentPrefix        string
 	CommentPrefixEscaped string
-	inlineCommentRE      *regexp.Regexp
-	TrimPrefix           string
-	StripUtf8BOM         bool
 
-	s       text.Scanner
-	errC    chan error
+	// CommentPrefixes may contain prefixes that are recognized
+	// in addition to CommentPrefix.
+	CommentPrefixes []string
+
+	// NoInlineComments disables the stripping of comments
+	// at the end of data lines.
+	NoInlineComments bool
+
+	inlineCommentRE *regexp.Regexp
+	commentPrefixes []string
+	TrimPrefix      string
+	StripUtf8BOM    bool
+
+	s          text.Scanner
+	ls         *text.LineScanner
+	lineOffset int
+	pending    *input // a line read ahead by Next
+	err        error
+	errC       chan error
+
+	// LineNum initially specifies the number assigned to the
+	// first line read; during ReadAll and Next it is the number
+	// of the current line. Once the end of the input has been
+	// reached, it is the number following that of the last line,
+	// so that a Reader continuing on the same input may be
+	// initialized with it.
 	LineNum int
 }
 
@@ -35,71 +60,206 @@ func NewReader(s text.Scanner) *Reader {
 	return &Reader{s: s, LineNum: 1}
 }
 
+// NewReaderSize returns a Reader reading lines from r, which may
+// be up to maxLine bytes long, including the line terminator.
+// If maxLine is not positive, bufio.MaxScanTokenSize is used.
+func NewReaderSize(r io.Reader, maxLine int) *Reader {
+	if maxLine <= 0 {
+		maxLine = bufio.MaxScanTokenSize
+	}
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, maxLine)
+	return NewReader(s)
+}
+
 type input struct {
 	insert  bool // if false: report current list of elements to parent
 	line    string
 	lineNum int
+	col     int // byte offset of line within the source line
+	depth   int // number of leading tabs that have been stripped
 }
 
 // Parse a whole file into atree structure of Elems and return a pointer
-// to the root Elem.
+// to the root Elem. The first error found is returned, including one
+// that is only detected while the last lines are being processed,
+// after the end of the input has been reached; top is nil in this case.
 func (r *Reader) ReadAll() (top *Elem, err error) {
-	if c := r.CommentPrefix; c != "" {
-		r.inlineCommentRE, err = regexp.Compile(`^((?:[^"']|"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*')*)` + c)
+	top = new(Elem)
+	for {
+		el, err := r.Next()
+		if err == io.EOF {
+			return top, nil
+		}
 		if err != nil {
 			return nil, err
 		}
+		top.Children = append(top.Children, *el)
+	}
+}
+
+// Next reads the next top-level Elem, including its children, from the
+// input. At the end of the input, it returns io.EOF. In contrast to ReadAll,
+// only the lines belonging to a single top-level Elem are held in memory.
+func (r *Reader) Next() (el *Elem, err error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.ls == nil {
+		err = r.init()
+		if err != nil {
+			r.err = err
+			return nil, err
+		}
 	}
 
 	sub := make(chan input)
 	rsub := make(chan []Elem)
-	r.errC = make(chan error, 4)
+	errC := make(chan error, 4)
+	r.errC = errC
 	go r.handleLevel(sub, rsub)
 	defer func() {
-		if err != nil {
+		close(sub)
+
+		// wait until the handlers have terminated
+		for range errC {
+		}
+		if err != nil && err != io.EOF {
 			l := new(line.ErrorList)
 			l.Add(err)
 			err = l
 		}
-		close(sub)
+		if err != nil {
+			r.err = err
+		}
 	}()
 
-	nTrimPrefix := len(r.TrimPrefix)
-
-	first := true
-	for ; r.s.Scan(); r.LineNum++ {
-		line := r.s.Text()
-		if first {
-			if r.StripUtf8BOM && strings.HasPrefix(line, "\uFEFF") {
-				line = line[3:]
+	haveElem := false
+	for {
+		in, ok := r.nextInput()
+		if !ok {
+			break
+		}
+		if r.isTopLevel(in.line) {
+			if haveElem {
+				r.pending = &in
+				break
 			}
-			first = false
+			haveElem = true
 		}
+		select {
+		case sub <- in:
+		case err = <-r.errC:
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	err = r.ls.Err()
+	if err != nil {
+		return nil, err
+	}
+	sub <- input{}
+	list := <-rsub
 
-		if nTrimPrefix != 0 {
+	// all lines have been processed now; report
+	// an error that may still be pending
+	select {
+	case err = <-r.errC:
+		if err != nil {
+			return nil, err
+		}
+	default:
+	}
+	if len(list) == 0 {
+		return nil, io.EOF
+	}
+	return &list[0], nil
+}
+
+func (r *Reader) init() (err error) {
+	r.commentPrefixes = r.commentPrefixes[:0]
+	if c := r.CommentPrefix; c != "" {
+		r.commentPrefixes = append(r.commentPrefixes, c)
+	}
+	for _, c := range r.CommentPrefixes {
+		if c != "" {
+			r.commentPrefixes = append(r.commentPrefixes, c)
+		}
+	}
+	r.inlineCommentRE = nil
+	if len(r.commentPrefixes) != 0 && !r.NoInlineComments {
+		alt := make([]string, len(r.commentPrefixes))
+		for i, c := range r.commentPrefixes {
+			alt[i] = regexp.QuoteMeta(c)
+		}
+		r.inlineCommentRE, err = regexp.Compile(`^((?:[^"']|"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*')*?)[ \t]+(?:` + strings.Join(alt, "|") + `)`)
+		if err != nil {
+			return err
+		}
+	}
+	r.ls = text.NewLineScanner(r.s)
+	r.lineOffset = r.LineNum - 1
+	return nil
+}
+
+// nextInput returns the next non-empty line of the input, either
+// the one that has been put back by Next, or a new one.
+func (r *Reader) nextInput() (in input, ok bool) {
+	if in := r.pending; in != nil {
+		r.pending = nil
+		return *in, true
+	}
+	for r.ls.Scan() {
+		r.LineNum = r.lineOffset + r.ls.LineNum()
+		line := r.ls.Text()
+		if r.ls.LineNum() == 1 && r.StripUtf8BOM {
+			line = text.StripBOM(line)
+		}
+
+		col := 0
+		if n := len(r.TrimPrefix); n != 0 {
 			if strings.HasPrefix(line, r.TrimPrefix) {
-				line = line[nTrimPrefix:]
+				line = line[n:]
+				col = n
 			}
 		}
 		if len(line) > 0 {
-			select {
-			case sub <- input{insert: true, line: line, lineNum: r.LineNum}:
-			case err = <-r.errC:
-				if err != nil {
-					return
-				}
-			}
+			return input{insert: true, line: line, lineNum: r.LineNum, col: col}, true
 		}
 	}
-	err = r.s.Err()
-	if err != nil {
-		return
+	r.LineNum = r.lineOffset + r.ls.LineNum() + 1
+	return in, false
+}
+
+// isTopLevel reports whether a line starts a new top-level Elem.
+func (r *Reader) isTopLevel(s string) bool {
+	if s[0] == '\t' {
+		return false
 	}
-	sub <- input{}
-	top = new(Elem)
-	top.Children = <-rsub
+	if esc := r.CommentPrefixEscaped; esc != "" && r.CommentPrefix != "" && strings.HasPrefix(s, esc) {
+		return true
+	}
+	return !r.isComment(s)
+}
 
-	return
+func (r *Reader) isComment(s string) bool {
+	for _, c := range r.commentPrefixes {
+		if strings.HasPrefix(s, c) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSpaceIndent reports whether the white-space at the start of s,
+// which begins with a space, looks like an attempt to indent
+// the line using spaces instead of tabs.
+func isSpaceIndent(s string, depth int) bool {
+	if depth > 0 {
+		return true
+	}
+	return len(s)-len(strings.TrimLeft(s, " \t")) > 1
 }
 
 func (r *Reader) handleLevel(inCh <-chan input, ret chan<- []Elem) {
@@ -132,7 +292,7 @@ func (r *Reader) handleLevel(inCh <-chan input, ret chan<- []Elem) {
 		if len(in.line) > 0 {
 			if in.line[0] == '\t' {
 				if el == nil {
-					r.errC <- line.NewMsg(in.lineNum, "wrong depth")
+					r.errC <- line.NewMsgCol(in.lineNum, in.col+1, "wrong depth")
 				}
 				if len(list) > 0 {
 					// input is not for me, propagate it to sub handler
@@ -141,17 +301,16 @@ func (r *Reader) handleLevel(inCh <-chan input, ret chan<- []Elem) {
 						rsub = make(chan []Elem)
 						go r.handleLevel(sub, rsub)
 					}
-					sub <- input{insert: true, line: in.line[1:], lineNum: in.lineNum}
+					sub <- input{insert: true, line: in.line[1:], lineNum: in.lineNum, col: in.col + 1, depth: in.depth + 1}
 				}
 				continue
 			}
 			// escaped comment?
-			if r.CommentPrefix != "" {
-				if esc := r.CommentPrefixEscaped; esc != "" && strings.HasPrefix(in.line, esc) {
-					in.line = in.line[1:]
-				} else if strings.HasPrefix(in.line, r.CommentPrefix) { // comment?
-					continue
-				}
+			if esc := r.CommentPrefixEscaped; esc != "" && r.CommentPrefix != "" && strings.HasPrefix(in.line, esc) {
+				in.line = in.line[1:]
+				in.col++
+			} else if r.isComment(in.line) {
+				continue
 			}
 		}
 		if el != nil && sub != nil {
@@ -163,9 +322,14 @@ func (r *Reader) handleLevel(inCh <-chan input, ret chan<- []Elem) {
 		if n := len(s); n != 0 {
 			c0, cLast := in.line[0], in.line[n-1]
 			if c0 == ' ' {
-				r.errC <- line.NewMsg(in.lineNum, "extra space character near start of line")
+				msg := "extra space character near start of line"
+				if isSpaceIndent(s, in.depth) {
+					msg = "space used for indentation; expected tab"
+				}
+				r.errC <- line.NewMsgCol(in.lineNum, in.col+1, msg)
 			} else if cLast == ' ' || cLast == '\t' {
-				r.errC <- line.NewMsg(in.lineNum, "extra white-space at the end of the line")
+				col := in.col + len(strings.TrimRight(s, " \t")) + 1
+				r.errC <- line.NewMsgCol(in.lineNum, col, "extra white-space at the end of the line")
 			}
 		}
 		t := in.line
@@ -175,8 +339,9 @@ func (r *Reader) handleLevel(inCh <-chan input, ret chan<- []Elem) {
 				t = t[ic[2]:ic[3]]
 			}
 		}
+		col := in.col + len(t) - len(strings.TrimLeft(t, " \t")) + 1
 		t = strings.TrimSpace(t)
-		list = append(list, Elem{Text: t, LineNum: in.lineNum})
+		list = append(list, Elem{Text: t, LineNum: in.lineNum, col: col})
 		el = &list[len(list)-1]
 	}
 
@@ -0,0 +1,186 @@
+package tidata
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/knieriem/text/line"
+)
+
+func TestSpaceIndent(t *testing.T) {
+	for _, test := range []struct {
+		src     string
+		lineNum int
+		col     int
+		msg     string
+	}{
+		{"a\n\tb\n\t    c\n", 3, 2, "space used for indentation; expected tab"},
+		{"a\n    b\n", 2, 1, "space used for indentation; expected tab"},
+		{"a\n b\n", 2, 1, "extra space character near start of line"},
+	} {
+		_, err := NewReader(bufio.NewScanner(strings.NewReader(test.src))).ReadAll()
+		list, ok := err.(*line.ErrorList)
+		if !ok || len(list.List) == 0 {
+			t.Fatalf("%q: unexpected error %v", test.src, err)
+		}
+		ce, ok := list.List[0].(line.ColumnError)
+		if !ok {
+			t.Fatalf("%q: got %T, want line.ColumnError", test.src, list.List[0])
+		}
+		if ce.Line() != test.lineNum || ce.Column() != test.col || ce.Error() != test.msg {
+			t.Errorf("%q: got %d:%d: %s", test.src, ce.Line(), ce.Column(), ce.Error())
+		}
+	}
+}
+
+func TestNewReaderSize(t *testing.T) {
+	long := strings.Repeat("x", 100000)
+	src := "a\n\tdata\t" + long + "\nb\n"
+
+	_, err := NewReader(bufio.NewScanner(strings.NewReader(src))).ReadAll()
+	if err == nil {
+		t.Fatal("default scanner: expected an error")
+	}
+
+	el, err := NewReaderSize(strings.NewReader(src), 1<<20).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(el.Children) != 2 {
+		t.Fatalf("got %d elements, want 2", len(el.Children))
+	}
+	if v := el.Children[0].Children[0].Value(); v != long {
+		t.Errorf("value has length %d, want %d", len(v), len(long))
+	}
+}
+
+func TestReaderNext(t *testing.T) {
+	src := `# comment
+a	1
+	x
+		y
+
+# comment
+	z
+b	2
+c
+`
+	r := NewReader(bufio.NewScanner(strings.NewReader(src)))
+	r.CommentPrefix = "#"
+	var keys []string
+	for {
+		el, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, el.Key())
+		if el.Key() == "a" {
+			if n := len(el.Children); n != 2 {
+				t.Errorf("a: got %d children, want 2", n)
+			} else if el.Children[1].Text != "z" || el.Children[1].LineNum != 7 {
+				t.Errorf("a: unexpected child %q at line %d", el.Children[1].Text, el.Children[1].LineNum)
+			}
+		}
+	}
+	if got := strings.Join(keys, " "); got != "a b c" {
+		t.Errorf("got keys %q", got)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("got %v after end of input, want io.EOF", err)
+	}
+}
+
+func TestReaderCommentPrefixes(t *testing.T) {
+	src := `# comment
+// another comment
+a	1 # inline
+	; nested comment
+	b	2 // inline
+c	"x # y" ; inline
+d	e#f
+`
+	r := NewReader(bufio.NewScanner(strings.NewReader(src)))
+	r.CommentPrefix = "#"
+	r.CommentPrefixes = []string{"//", ";"}
+	top, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	top.Walk(func(el *Elem, _ int) error {
+		texts = append(texts, fmt.Sprintf("%d:%s", el.LineNum, el.Text))
+		return nil
+	})
+	want := `3:a	1 5:b	2 6:c	"x # y" 7:d	e#f`
+	if got := strings.Join(texts, " "); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestReaderNoInlineComments(t *testing.T) {
+	src := "# comment\na\t1 # not a comment\n\t# nested comment\n\tb\t2 // x\n"
+	r := NewReader(bufio.NewScanner(strings.NewReader(src)))
+	r.CommentPrefix = "#"
+	r.CommentPrefixes = []string{"//"}
+	r.NoInlineComments = true
+	top, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(top.Children); n != 1 {
+		t.Fatalf("got %d elements, want 1", n)
+	}
+	a := top.Children[0]
+	if a.Text != "a\t1 # not a comment" {
+		t.Errorf("unexpected text: %q", a.Text)
+	}
+	if len(a.Children) != 1 || a.Children[0].Text != "b\t2 // x" {
+		t.Errorf("unexpected children: %v", a.Children)
+	}
+}
+
+func TestReaderErrorInLastLine(t *testing.T) {
+	for _, src := range []string{"a\nb \n", "a\nb\t", "a\n\t\tx\n"} {
+		top, err := NewReader(bufio.NewScanner(strings.NewReader(src))).ReadAll()
+		list, ok := err.(*line.ErrorList)
+		if !ok || len(list.List) == 0 {
+			t.Errorf("%q: unexpected error: %v", src, err)
+			continue
+		}
+		if e, ok := list.List[0].(line.Error); !ok || e.Line() != 2 {
+			t.Errorf("%q: unexpected error: %v", src, err)
+		}
+		if top != nil {
+			t.Errorf("%q: result not nil", src)
+		}
+	}
+}
+
+func TestReaderLineNum(t *testing.T) {
+	r := NewReader(bufio.NewScanner(strings.NewReader("a\nb\n\nc\n")))
+	r.LineNum = 10
+	top, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if el := top.Children[2]; el.Text != "c" || el.LineNum != 13 {
+		t.Errorf("unexpected line %q at %d", el.Text, el.LineNum)
+	}
+	if r.LineNum != 14 {
+		t.Errorf("LineNum after end of input: %d, want 14", r.LineNum)
+	}
+
+	r = NewReader(bufio.NewScanner(strings.NewReader("")))
+	if _, err := r.ReadAll(); err != nil {
+		t.Fatal(err)
+	}
+	if r.LineNum != 1 {
+		t.Errorf("LineNum after empty input: %d, want 1", r.LineNum)
+	}
+}
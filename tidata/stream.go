@@ -0,0 +1,36 @@
+package tidata
+
+import (
+	"github.com/knieriem/text"
+)
+
+// A Decoder decodes the top-level elements of its input one at
+// a time, so that the whole tree never needs to be held in memory.
+type Decoder struct {
+	r    *Reader
+	conf *Config
+}
+
+// NewDecoder returns a Decoder reading lines from s, that decodes
+// each top-level Elem using configuration c, which may be nil.
+// The Reader used may be adjusted, e.g. to recognize comments,
+// before Next is called for the first time.
+func NewDecoder(s text.Scanner, c *Config) *Decoder {
+	return &Decoder{r: NewReader(s), conf: c}
+}
+
+// Reader returns the Reader used by d.
+func (d *Decoder) Reader() *Reader {
+	return d.r
+}
+
+// Next reads the next top-level Elem, including its children, and
+// decodes it into the value pointed to by v, as Elem.Decode does.
+// At the end of the input, Next returns io.EOF.
+func (d *Decoder) Next(v interface{}) error {
+	el, err := d.r.Next()
+	if err != nil {
+		return err
+	}
+	return el.Decode(v, d.conf)
+}